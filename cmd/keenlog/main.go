@@ -0,0 +1,118 @@
+// keenlog 查询提供者日志目录中的日志。
+//
+//	keenlog [-dir 日志目录] [-job 任务ID] [-cmd 命令] [-level 级别] [-since 时间] [-until 时间] [-caller 调用者] [-f] [文件...]
+//
+// 没有指定文件时读取日志目录和归档目录中的所有日志（包括压缩和打包的日志），多个文件的日志按照时间合并。
+// 时间可以是 2006-01-02 15:04:05、RFC 3339 格式或者 1h30m 这样的时长（表示当前时间之前）。
+// -f 持续输出日志目录中最新的日志文件，出现更新的文件时自动切换
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"gitea.fcdm.top/lixuan/keen/ylog"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// parseTime 解析查询的时间，now用于计算时长表示的时间
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(ylog.LOG_TIME_FMT, s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("illegal time [%s]", s)
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("keenlog", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		dir    = fs.String("dir", ".", "provider log directory")
+		job    = fs.String("job", "", "job ID")
+		cmd    = fs.String("cmd", "", "command name or log name of the command")
+		level  = fs.String("level", "TRACE", "minimum level")
+		since  = fs.String("since", "", "start time (inclusive)")
+		until  = fs.String("until", "", "end time (exclusive)")
+		caller = fs.String("caller", "", "substring of the caller file:line")
+		follow = fs.Bool("f", false, "follow the newest log file in the directory")
+	)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	q := pvd.LogQuery{JobID: *job, Command: *cmd, Caller: *caller}
+	var err error
+	if q.MinLevel, err = ylog.ParseLevel(*level); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	now := time.Now()
+	if q.Since, err = parseTime(*since, now); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if q.Until, err = parseTime(*until, now); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	output := func(r ylog.Record) {
+		fmt.Fprintln(stdout, r.Raw)
+	}
+
+	if *follow {
+		stop := make(chan struct{})
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		go func() {
+			<-sig
+			close(stop)
+		}()
+		err = ylog.Follow(*dir, q.MatchFile, ylog.FOLLOW_INTERVAL, stop, func(r ylog.Record) {
+			if q.Match(r) {
+				output(r)
+			}
+		})
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to follow logs in [%s]: %v\n", *dir, err)
+			return 1
+		}
+		return 0
+	}
+
+	var res []ylog.Record
+	if fs.NArg() > 0 {
+		res, err = ylog.ReadLogs(fs.Args(), q.Match)
+	} else {
+		res, err = pvd.QueryLogs(*dir, q)
+	}
+	for _, r := range res {
+		output(r)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to read logs: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	tm, err := parseTime("2024-03-01 10:00:00", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local), tm)
+
+	tm, err = parseTime("2024-03-01T10:00:00Z", now)
+	assert.NoError(t, err)
+	assert.True(t, tm.Equal(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)))
+
+	tm, err = parseTime("1h30m", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 10, 30, 0, 0, time.Local), tm)
+
+	_, err = parseTime("yesterday", now)
+	assert.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "mount_j1_20240301100000.log"), []byte(
+		"[2024-03-01 10:00:00] [INFO ] [pvd/idea.go:10] - mount start\n"+
+			"[2024-03-01 10:00:02] [ERROR] [pvd/mount.go:20] - mount failed:\n  exit status 1\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "mount_j2_20240301100001.log"), []byte(
+		"[2024-03-01 10:00:01] [ERROR] [pvd/mount.go:20] - other job\n"), 0644))
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	assert.Equal(t, 0, run([]string{"-dir", dir, "-level", "error"}, stdout, stderr))
+	assert.Equal(t, "[2024-03-01 10:00:01] [ERROR] [pvd/mount.go:20] - other job\n"+
+		"[2024-03-01 10:00:02] [ERROR] [pvd/mount.go:20] - mount failed:\n  exit status 1\n", stdout.String())
+
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"-dir", dir, "-job", "j1", "-until", "2024-03-01 10:00:01"}, stdout, stderr))
+	assert.Equal(t, "[2024-03-01 10:00:00] [INFO ] [pvd/idea.go:10] - mount start\n", stdout.String())
+
+	stdout.Reset()
+	assert.Equal(t, 0, run([]string{"-caller", "idea.go", filepath.Join(dir, "mount_j1_20240301100000.log")}, stdout, stderr))
+	assert.Equal(t, "[2024-03-01 10:00:00] [INFO ] [pvd/idea.go:10] - mount start\n", stdout.String())
+
+	assert.Equal(t, 2, run([]string{"-level", "loud"}, stdout, stderr))
+	assert.Equal(t, 1, run([]string{"-dir", filepath.Join(dir, "missing")}, stdout, stderr))
+}
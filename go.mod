@@ -7,6 +7,7 @@ require (
 	github.com/cnyjp/fcdmpublic v0.0.11
 	github.com/fatih/color v1.15.0
 	github.com/go-ole/go-ole v1.3.0
+	github.com/stretchr/testify v1.8.4
 	golang.org/x/sys v0.12.0
 	golang.org/x/text v0.14.0
 )
@@ -18,6 +19,5 @@ require (
 	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.5.1 // indirect
-	github.com/stretchr/testify v1.8.4 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
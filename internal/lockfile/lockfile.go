@@ -0,0 +1,144 @@
+// Package lockfile 以O_EXCL创建锁文件实现跨进程互斥，供util和ylog共同使用（ylog不能依赖util）。
+//
+// 锁文件中写入持有者唯一的令牌（进程号、获取时间和随机数），释放锁和清理失效的锁时先将锁文件原子地重命名，
+// 确认移走的是预期的锁之后才删除，否则放回原处，因此不会删除其他进程在接管之后重新获取的锁，
+// 多个等待者同时清理同一个失效的锁时也只有一个能够获取锁
+package lockfile
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_RETRY   = 50 * time.Millisecond
+	DEFAULT_TIMEOUT = 10 * time.Second
+	DEFAULT_STALE   = time.Minute
+)
+
+// Options 获取锁的参数，零值使用默认值
+type Options struct {
+	Retry   time.Duration     // 重试的间隔
+	Timeout time.Duration     // 等待超过此时长时返回错误
+	Stale   time.Duration     // 获取之后超过此时长未释放的锁视为持有进程已经退出
+	OnStale func(path string) // 清理失效的锁之前调用，一般用于记录日志
+}
+
+func (o Options) retry() time.Duration {
+	if o.Retry > 0 {
+		return o.Retry
+	}
+	return DEFAULT_RETRY
+}
+
+func (o Options) timeout() time.Duration {
+	if o.Timeout > 0 {
+		return o.Timeout
+	}
+	return DEFAULT_TIMEOUT
+}
+
+func (o Options) stale() time.Duration {
+	if o.Stale > 0 {
+		return o.Stale
+	}
+	return DEFAULT_STALE
+}
+
+func randomHex() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newToken 进程号 获取时间（纳秒） 随机数
+func newToken() string {
+	return fmt.Sprintf("%d %d %s", os.Getpid(), time.Now().UnixNano(), randomHex())
+}
+
+// tokenTime 从令牌中解析获取锁的时间，旧格式或者尚未写入令牌的锁文件返回false
+func tokenTime(token string) (time.Time, bool) {
+	fs := strings.Fields(token)
+	if len(fs) != 3 {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(fs[1], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
+
+// Lock 获取path处的锁，返回释放锁的函数。锁文件所在的目录需要已经存在
+func Lock(path string, opts Options) (func(), error) {
+	deadline := time.Now().Add(opts.timeout())
+	for {
+		token := newToken()
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_, err = f.WriteString(token)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return func() { removeIf(path, token) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if cur, ok := staleToken(path, opts.stale()); ok {
+			if opts.OnStale != nil {
+				opts.OnStale(path)
+			}
+			removeIf(path, cur)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout while waiting for the lock file [%s]", path)
+		}
+		time.Sleep(opts.retry())
+	}
+}
+
+// staleToken 锁已经失效时返回锁文件的内容。令牌中没有获取时间时按照文件的修改时间判断
+func staleToken(path string, stale time.Duration) (string, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	t, ok := tokenTime(string(bs))
+	if !ok {
+		t = fi.ModTime()
+	}
+	return string(bs), time.Since(t) > stale
+}
+
+// removeIf 将锁文件原子地移走，内容为want时删除并返回true；否则锁已经被其他进程重新获取，放回原处。
+// 放回时使用硬链接，不会覆盖在此期间新创建的锁文件
+func removeIf(path, want string) bool {
+	moved := path + "." + randomHex() + ".removing"
+	if err := os.Rename(path, moved); err != nil {
+		return false
+	}
+	bs, err := os.ReadFile(moved)
+	if err == nil && string(bs) == want {
+		os.Remove(moved)
+		return true
+	}
+	os.Link(moved, path)
+	os.Remove(moved)
+	return false
+}
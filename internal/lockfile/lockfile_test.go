@@ -0,0 +1,103 @@
+package lockfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/internal/lockfile"
+	"github.com/stretchr/testify/assert"
+)
+
+// overlaps 多个goroutine同时获取锁，返回获取锁时已经有其他持有者的次数
+func overlaps(t *testing.T, path string, opts lockfile.Options, n int) int32 {
+	var (
+		wg       sync.WaitGroup
+		holders  atomic.Int32
+		overlap  atomic.Int32
+		acquired atomic.Int32
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lockfile.Lock(path, opts)
+			if !assert.NoError(t, err) {
+				return
+			}
+			acquired.Add(1)
+			if holders.Add(1) > 1 {
+				overlap.Add(1)
+			}
+			time.Sleep(5 * time.Millisecond)
+			holders.Add(-1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(n), acquired.Load())
+	return overlap.Load()
+}
+
+func TestLockExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.lock")
+	opts := lockfile.Options{Retry: time.Millisecond}
+	assert.Zero(t, overlaps(t, path, opts, 8))
+	assert.NoFileExists(t, path)
+}
+
+func TestLockTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.lock")
+	unlock, err := lockfile.Lock(path, lockfile.Options{})
+	assert.NoError(t, err)
+	defer unlock()
+
+	_, err = lockfile.Lock(path, lockfile.Options{Retry: time.Millisecond, Timeout: 20 * time.Millisecond})
+	assert.Error(t, err)
+}
+
+func TestStaleLockTakenOverOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.lock")
+	// 旧格式的锁文件按照修改时间判断失效
+	assert.NoError(t, os.WriteFile(path, []byte("1234"), 0600))
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(path, old, old))
+
+	var stale atomic.Int32
+	opts := lockfile.Options{
+		Retry:   time.Millisecond,
+		Stale:   time.Minute,
+		OnStale: func(string) { stale.Add(1) },
+	}
+	assert.Zero(t, overlaps(t, path, opts, 8), "waiters should not both acquire the lock after removing the stale one")
+	assert.GreaterOrEqual(t, stale.Load(), int32(1))
+	assert.NoFileExists(t, path)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "no temporary files should be left")
+}
+
+func TestUnlockKeepsTakenOverLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.lock")
+	opts := lockfile.Options{Retry: time.Millisecond, Stale: 20 * time.Millisecond}
+	unlockA, err := lockfile.Lock(path, opts)
+	assert.NoError(t, err)
+
+	// A持有超过Stale之后被B接管
+	time.Sleep(30 * time.Millisecond)
+	unlockB, err := lockfile.Lock(path, opts)
+	assert.NoError(t, err)
+	held, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	unlockA()
+	bs, err := os.ReadFile(path)
+	assert.NoError(t, err, "the lock of B should not be removed by A")
+	assert.Equal(t, held, bs)
+
+	unlockB()
+	assert.NoFileExists(t, path)
+}
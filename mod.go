@@ -1,6 +1,28 @@
 package keen
 
-import "gitea.fcdm.top/lixuan/keen/ylog"
+import (
+	"os"
 
-var consoleWriter ylog.ConsoleWriter = *ylog.NewConsoleWriter(func(i int8) bool { return i >= ylog.DEBUG }, true)
-var Log ylog.Logger = ylog.NewLogger(&consoleWriter)
+	"gitea.fcdm.top/lixuan/keen/ylog"
+)
+
+const (
+	ENV_LOG_LEVEL      = "KEEN_LOG_LEVEL"
+	ENV_LOG_LEVEL_FILE = "KEEN_LOG_LEVEL_FILE"
+)
+
+// ConsoleLevel 控制台日志的级别，默认为DEBUG，登记在Levels中的名称为console
+var ConsoleLevel = ylog.NewLevelVar(ylog.DEBUG)
+
+// Levels 运行时调整日志级别：KEEN_LOG_LEVEL设置所有级别，KEEN_LOG_LEVEL_CONSOLE等设置单个级别，
+// KEEN_LOG_LEVEL_FILE为控制文件的路径。提供者创建的输出目标可以将自己的LevelVar登记到Levels中
+var Levels = ylog.NewLevelControl(ENV_LOG_LEVEL, os.Getenv(ENV_LOG_LEVEL_FILE))
+
+var consoleWriter *ylog.ConsoleWriter = ylog.NewConsoleWriter(ConsoleLevel.Filter(), true)
+var Log ylog.Logger = ylog.NewLogger(consoleWriter)
+
+func init() {
+	if err := Levels.Register("console", ConsoleLevel); err != nil {
+		ylog.Errorf("failed to load the log levels: %v\n", err)
+	}
+}
@@ -0,0 +1,160 @@
+package pvd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cnyjp/fcdmpublic/model"
+)
+
+const (
+	LOG_NAME_ILLEGAL = "illegal"
+)
+
+var (
+	ErrCommandExists  = errors.New("the command is already registered")
+	ErrInvalidCommand = errors.New("the command definition is invalid")
+	errMissingVolumes = errors.New("volume information is empty")
+	errMissingJobID   = errors.New("the job ID is empty")
+	logNamePattern    = regexp.MustCompile(`^[a-z0-9]+$`)
+	commandsMu        sync.RWMutex
+	commandsByName    = make(map[string]Command)
+	commandsByLogName = make(map[string]string)
+)
+
+// CommandHandler 命令的执行逻辑。返回的结果不为nil时作为结果文档输出，json.RawMessage会原样输出；
+// 结果为nil时输出Outcome。返回错误时任务失败，但已返回的结果仍然会输出
+type CommandHandler func(pvd Provider, env FCDMArgument, j *Job) (any, error)
+
+// Command 可以由Do执行的命令，内置命令和提供者注册的命令使用相同的执行流程
+type Command struct {
+	Name            string                   // FCDM_EV_COMMAND的值
+	LogName         string                   // 日志文件名称中的命令名称，只能包含小写字母和数字
+	ValidConfig     bool                     // 执行前是否调用Provider.ValidConfig验证配置项
+	NeedVolumes     bool                     // 是否必须提供卷信息
+	RequiredConfigs []string                 // 必须提供且不为空的配置项名称，不包含FCDM_EV_AD_前缀
+	Validate        func(FCDMArgument) error // 命令特有的参数校验，可以为nil
+	Run             CommandHandler           // 命令的执行逻辑
+}
+
+// check 对参数进行命令相关的校验
+func (c Command) check(arg FCDMArgument) error {
+	if arg.JobID == "" {
+		return errMissingJobID
+	}
+	if c.NeedVolumes && len(arg.VolumeInformation) == 0 {
+		return errMissingVolumes
+	}
+	for _, name := range c.RequiredConfigs {
+		if v, _ := arg.GetConfig(name, false, nil); v == "" {
+			return fmt.Errorf("the configuration [%s] is required", name)
+		}
+	}
+	if c.Validate != nil {
+		return c.Validate(arg)
+	}
+	return nil
+}
+
+// RegisterCommand 注册命令，命令名称和日志名称都不能与已注册的命令重复。应当在init或者调用Do之前注册
+func RegisterCommand(c Command) error {
+	if c.Name == "" || c.Run == nil {
+		return fmt.Errorf("%w: the name and handler are required", ErrInvalidCommand)
+	}
+	if !logNamePattern.MatchString(c.LogName) || c.LogName == LOG_NAME_ILLEGAL {
+		return fmt.Errorf("%w: illegal log name [%s]", ErrInvalidCommand, c.LogName)
+	}
+
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+
+	if _, ok := commandsByName[c.Name]; ok {
+		return fmt.Errorf("%w: [%s]", ErrCommandExists, c.Name)
+	}
+	if name, ok := commandsByLogName[c.LogName]; ok {
+		return fmt.Errorf("%w: log name [%s] is used by [%s]", ErrCommandExists, c.LogName, name)
+	}
+
+	commandsByName[c.Name] = c
+	commandsByLogName[c.LogName] = c.Name
+	LogNameReg = buildLogNameReg()
+	return nil
+}
+
+// MustRegisterCommand 注册命令，失败时panic
+func MustRegisterCommand(c Command) {
+	if err := RegisterCommand(c); err != nil {
+		panic(err)
+	}
+}
+
+// LookupCommand 查找已注册的命令
+func LookupCommand(name string) (Command, bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	c, ok := commandsByName[name]
+	return c, ok
+}
+
+// Commands 返回所有已注册的命令，按照名称排序
+func Commands() []Command {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	res := make([]Command, 0, len(commandsByName))
+	for _, c := range commandsByName {
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// buildLogNameReg 根据已注册命令的日志名称生成日志文件名称的正则表达式，调用者需要持有锁
+func buildLogNameReg() *regexp.Regexp {
+	names := []string{LOG_NAME_ILLEGAL}
+	for n := range commandsByLogName {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return regexp.MustCompile(`(` + strings.Join(names, "|") + `)_(.+)_(\d{14})\.log$`)
+}
+
+// marshalResult 将命令的结果转换为结果文档
+func marshalResult(res any) ([]byte, error) {
+	if raw, ok := res.(json.RawMessage); ok {
+		return raw, nil
+	}
+	return json.Marshal(res)
+}
+
+func validateBackupType(arg FCDMArgument) error {
+	bt, err := strconv.Atoi(arg.BackupType)
+	if err != nil {
+		return fmt.Errorf("backup type [%s] is illegal: %w", arg.BackupType, err)
+	}
+	if bt != model.BACKUP_TYPE_ALL && bt != model.BACKUP_TYPE_DB && bt != model.BACKUP_TYPE_LOG {
+		return fmt.Errorf("backup type [%d] is out of scope", bt)
+	}
+	return nil
+}
+
+func init() {
+	for _, c := range []Command{
+		{Name: model.CMD_DISCOVER, LogName: "discover", ValidConfig: true, Run: discover},
+		{Name: model.CMD_APPLICATION_INFO, LogName: "refresh", ValidConfig: true, Run: refresh},
+		{Name: model.CMD_BACKUP, LogName: "backup", ValidConfig: true, NeedVolumes: true, Validate: validateBackupType, Run: backup},
+		{Name: model.CMD_RESTORE, LogName: "restore", ValidConfig: true, NeedVolumes: true, Run: restore},
+		{Name: model.CMD_MOUNT, LogName: "mount", ValidConfig: true, NeedVolumes: true, Run: mount},
+		{Name: model.CMD_UMOUNT, LogName: "umount", ValidConfig: true, NeedVolumes: true, Run: umount},
+		{Name: model.CMD_PLUGIN_INFO, LogName: "pluginfo", ValidConfig: true, Run: pluginInfo},
+		{Name: CMD_LIST_MOUNTS, LogName: "listmount", Run: listMounts},
+		{Name: CMD_CLEAN_MOUNTS, LogName: "cleanmount", ValidConfig: true, Run: cleanMounts},
+	} {
+		MustRegisterCommand(c)
+	}
+}
@@ -0,0 +1,107 @@
+package pvd_test
+
+import (
+	"errors"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"gitea.fcdm.top/lixuan/keen/pvd/pvdtest"
+	"github.com/cnyjp/fcdmpublic/model"
+	"github.com/stretchr/testify/assert"
+)
+
+const CMD_VERIFY = "pvdtest_verify"
+
+type verifyResult struct {
+	Application string `json:"application"`
+	Images      int    `json:"images"`
+}
+
+func init() {
+	pvd.MustRegisterCommand(pvd.Command{
+		Name:            CMD_VERIFY,
+		LogName:         "verify",
+		ValidConfig:     true,
+		RequiredConfigs: []string{"verify_level"},
+		Run: func(p pvd.Provider, env pvd.FCDMArgument, j *pvd.Job) (any, error) {
+			j.Enter("verify")
+			if _, err := p.FindApplication(env.ApplicationName); err != nil {
+				return nil, err
+			}
+			return verifyResult{env.ApplicationName, len(p.(*pvdtest.Provider).Images())}, nil
+		},
+	})
+}
+
+func verifyArgument(appName string) pvd.FCDMArgument {
+	arg := pvdtest.NewArgument(CMD_VERIFY, appName)
+	arg.Configs[model.FCDM_EV_AD_PREFIX+"verify_level"] = "full"
+	return arg
+}
+
+func TestRegisteredCommand(t *testing.T) {
+	pvdtest.TempState(t)
+	p := pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"))
+
+	arg := verifyArgument("orcl")
+	assert.True(t, arg.IsLegal())
+	assert.True(t, arg.Validate())
+	assert.Equal(t, "verify", arg.MapCommand())
+	assert.True(t, pvd.LogNameReg.MatchString(pvd.GenLogName(arg.MapCommand(), arg.JobID)))
+	ok, name := pvd.SimpleArch(pvd.GenLogName(arg.MapCommand(), arg.JobID))
+	assert.True(t, ok)
+	assert.Equal(t, "verify", name)
+
+	var res verifyResult
+	out := pvdtest.Run(p, arg)
+	assert.Equal(t, 0, out.Code)
+	assert.NoError(t, out.Decode(&res))
+	assert.Equal(t, verifyResult{"orcl", 0}, res)
+
+	out = pvdtest.Run(p, verifyArgument("missing"))
+	assert.Equal(t, pvd.C_ERR_EXIT, out.Code)
+	var o pvd.Outcome
+	assert.NoError(t, out.Decode(&o))
+	assert.NotEmpty(t, o.Error)
+}
+
+func TestCommandValidation(t *testing.T) {
+	arg := pvdtest.NewArgument(CMD_VERIFY, "orcl")
+	assert.False(t, arg.Validate(), "missing required configuration")
+
+	arg = pvdtest.NewArgument("pvdtest_unknown", "orcl")
+	assert.False(t, arg.IsLegal())
+	assert.Equal(t, "illegal", arg.MapCommand())
+
+	arg = pvdtest.NewArgument(model.CMD_BACKUP, "orcl")
+	arg.BackupType = "9"
+	assert.False(t, arg.Validate(), "backup type out of scope")
+	arg.BackupType = "1"
+	assert.True(t, arg.Validate())
+	arg.VolumeInformation = map[string]string{}
+	assert.False(t, arg.Validate(), "backup requires volumes")
+}
+
+func TestRegisterCommandConflicts(t *testing.T) {
+	run := func(pvd.Provider, pvd.FCDMArgument, *pvd.Job) (any, error) { return nil, nil }
+
+	err := pvd.RegisterCommand(pvd.Command{Name: CMD_VERIFY, LogName: "verify2", Run: run})
+	assert.True(t, errors.Is(err, pvd.ErrCommandExists))
+	err = pvd.RegisterCommand(pvd.Command{Name: "pvdtest_verify2", LogName: "backup", Run: run})
+	assert.True(t, errors.Is(err, pvd.ErrCommandExists))
+	err = pvd.RegisterCommand(pvd.Command{Name: "pvdtest_verify2", LogName: "Verify_2", Run: run})
+	assert.True(t, errors.Is(err, pvd.ErrInvalidCommand))
+	err = pvd.RegisterCommand(pvd.Command{Name: "pvdtest_verify2", LogName: "illegal", Run: run})
+	assert.True(t, errors.Is(err, pvd.ErrInvalidCommand))
+	err = pvd.RegisterCommand(pvd.Command{Name: "pvdtest_verify2", LogName: "verify2"})
+	assert.True(t, errors.Is(err, pvd.ErrInvalidCommand))
+
+	names := make([]string, 0)
+	for _, c := range pvd.Commands() {
+		names = append(names, c.Name)
+	}
+	assert.Contains(t, names, model.CMD_BACKUP)
+	assert.Contains(t, names, pvd.CMD_CLEAN_MOUNTS)
+	assert.Contains(t, names, CMD_VERIFY)
+	assert.NotContains(t, names, "pvdtest_verify2")
+}
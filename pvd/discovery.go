@@ -0,0 +1,177 @@
+package pvd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/util"
+	"github.com/cnyjp/fcdmpublic/model"
+)
+
+const (
+	EVENT_DISCOVERY_CHANGED = "discovery_changed"
+)
+
+// Discoveries 默认的发现结果跟踪器，位于状态目录下
+var Discoveries = NewDiscoveryTracker(filepath.Join(StateDir(), "discovery"))
+
+// AppChange 发生变化的应用及其变化的字段
+type AppChange struct {
+	Key    string            `json:"key"`
+	Fields []string          `json:"fields"`
+	Old    model.Application `json:"old"`
+	New    model.Application `json:"new"`
+}
+
+// DiscoveryDiff 本次发现结果与上次发现结果的差异。Initial表示没有上次的发现结果，本次结果只作为基准保存，差异为空
+type DiscoveryDiff struct {
+	Host     string              `json:"host"`
+	Initial  bool                `json:"initial"`
+	Previous time.Time           `json:"previous"`
+	Added    []model.Application `json:"added"`
+	Removed  []model.Application `json:"removed"`
+	Changed  []AppChange         `json:"changed"`
+}
+
+// Empty 两次发现结果之间没有差异
+func (d DiscoveryDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+type discoveryState struct {
+	Time         time.Time                    `json:"time"`
+	Applications map[string]model.Application `json:"applications"`
+}
+
+// DiscoveryTracker 按主机保存最近一次的发现结果，并计算与上次结果的差异
+type DiscoveryTracker struct {
+	Dir string
+}
+
+func NewDiscoveryTracker(dir string) *DiscoveryTracker {
+	return &DiscoveryTracker{Dir: dir}
+}
+
+func (t *DiscoveryTracker) path(host string) string {
+	return filepath.Join(t.Dir, host+"_"+ProviderName()+".json")
+}
+
+// appKey 应用在主机上的唯一标识，与GenFCDMApplicationName一致
+func appKey(app BackupApplication) string {
+	xn := app.GenFCDMApplicationName()
+	return xn.SecondlyType + "/" + xn.Name
+}
+
+// changedFields 比较应用中与保护相关的字段，忽略大小等随时间变化的字段
+func changedFields(o, n model.Application) []string {
+	volumes := func(vs []model.Volume) []model.Volume {
+		res := make([]model.Volume, 0, len(vs))
+		for _, v := range vs {
+			res = append(res, model.Volume{
+				Name:      v.Name,
+				StageType: v.StageType,
+				FsType:    v.FsType,
+				Identity:  v.Identity,
+				FsPath:    v.FsPath,
+			})
+		}
+		sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+		return res
+	}
+
+	fields := []struct {
+		name     string
+		old, new any
+	}{
+		{"displayName", o.DisplayName, n.DisplayName},
+		{"volumes", volumes(o.Volumes), volumes(n.Volumes)},
+		{"options", o.Options, n.Options},
+		{"extensions", o.Extensions, n.Extensions},
+		{"haslog", o.Haslog, n.Haslog},
+		{"available", o.Available, n.Available},
+		{"parentId", o.ParentId, n.ParentId},
+	}
+
+	res := make([]string, 0)
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.old, f.new) {
+			res = append(res, f.name)
+		}
+	}
+	return res
+}
+
+// Track 计算发现结果与上次结果的差异并保存本次的发现结果，第一次发现时不报告差异
+func (t *DiscoveryTracker) Track(apps []BackupApplication) (DiscoveryDiff, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return DiscoveryDiff{}, err
+	}
+
+	diff := DiscoveryDiff{
+		Host:    host,
+		Added:   make([]model.Application, 0),
+		Removed: make([]model.Application, 0),
+		Changed: make([]AppChange, 0),
+	}
+
+	var prev discoveryState
+	bs, err := os.ReadFile(t.path(host))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return diff, err
+		}
+		diff.Initial = true
+	} else if err := json.Unmarshal(bs, &prev); err != nil {
+		return diff, err
+	}
+	diff.Previous = prev.Time
+
+	cur := discoveryState{
+		Time:         time.Now(),
+		Applications: make(map[string]model.Application),
+	}
+	keys := make([]string, 0, len(apps))
+	for _, app := range apps {
+		k := appKey(app)
+		cur.Applications[k] = app.ToFCDMApplication()
+		keys = append(keys, k)
+	}
+
+	for _, k := range keys {
+		n := cur.Applications[k]
+		o, ok := prev.Applications[k]
+		if !ok {
+			// 第一次发现时只保存基准
+			if !diff.Initial {
+				diff.Added = append(diff.Added, n)
+			}
+		} else if fs := changedFields(o, n); len(fs) > 0 {
+			diff.Changed = append(diff.Changed, AppChange{k, fs, o, n})
+		}
+	}
+
+	removed := make([]string, 0)
+	for k := range prev.Applications {
+		if _, ok := cur.Applications[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(removed)
+	for _, k := range removed {
+		diff.Removed = append(diff.Removed, prev.Applications[k])
+	}
+
+	if err := os.MkdirAll(t.Dir, os.ModeDir|0700); err != nil {
+		return diff, err
+	}
+	bs, err = json.Marshal(cur)
+	if err != nil {
+		return diff, err
+	}
+	return diff, util.WriteFileAtomic(t.path(host), bs, 0600)
+}
@@ -0,0 +1,82 @@
+package pvd_test
+
+import (
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"gitea.fcdm.top/lixuan/keen/pvd/pvdtest"
+	"github.com/cnyjp/fcdmpublic/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordSink struct {
+	events []pvd.Event
+}
+
+func (s *recordSink) Notify(e pvd.Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func TestDiscoveryTracker(t *testing.T) {
+	tracker := pvd.NewDiscoveryTracker(t.TempDir())
+	orcl := pvdtest.NewApplication("orcl", "oracle", "orcl_data")
+	mysql := pvdtest.NewApplication("mysql", "mysql", "mysql_data")
+
+	diff, err := tracker.Track([]pvd.BackupApplication{orcl, mysql})
+	assert.NoError(t, err)
+	assert.True(t, diff.Initial, "first discovery has no previous result")
+	assert.True(t, diff.Empty(), "first discovery is only the baseline")
+
+	diff, err = tracker.Track([]pvd.BackupApplication{orcl, mysql})
+	assert.NoError(t, err)
+	assert.False(t, diff.Initial)
+	assert.True(t, diff.Empty(), "nothing changed")
+
+	// 大小的变化不视为应用发生变化
+	orcl.Size = 4096
+	orcl.Volumes = append(orcl.Volumes, "orcl_log")
+	pg := pvdtest.NewApplication("pg", "postgres", "pg_data")
+	diff, err = tracker.Track([]pvd.BackupApplication{orcl, pg})
+	assert.NoError(t, err)
+	assert.Len(t, diff.Added, 1)
+	assert.Equal(t, "pg", diff.Added[0].Name)
+	assert.Len(t, diff.Removed, 1)
+	assert.Equal(t, "mysql", diff.Removed[0].Name)
+	assert.Len(t, diff.Changed, 1)
+	assert.Equal(t, "oracle/orcl", diff.Changed[0].Key)
+	assert.Equal(t, []string{"volumes"}, diff.Changed[0].Fields)
+}
+
+func TestDiscoveryChangedEvent(t *testing.T) {
+	pvdtest.TempState(t)
+	sink := &recordSink{}
+	old := pvd.Notify
+	pvd.Notify = pvd.NewNotifier(sink)
+	defer func() { pvd.Notify = old }()
+
+	p := pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"))
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_DISCOVER, "")).Code)
+
+	changed := func() []pvd.Event {
+		res := make([]pvd.Event, 0)
+		for _, e := range sink.events {
+			if e.Type == pvd.EVENT_DISCOVERY_CHANGED {
+				res = append(res, e)
+			}
+		}
+		sink.events = nil
+		return res
+	}
+	assert.Empty(t, changed(), "initial discovery should not be reported")
+
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_DISCOVER, "")).Code)
+	assert.Empty(t, changed(), "unchanged discovery should not be reported")
+
+	p.Apps = p.Apps[:0]
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_DISCOVER, "")).Code)
+	events := changed()
+	assert.Len(t, events, 1, "removed application should be reported")
+	diff := events[0].Result.(pvd.DiscoveryDiff)
+	assert.Equal(t, "orcl", diff.Removed[0].Name)
+}
@@ -3,10 +3,9 @@ package pvd
 import (
 	"encoding/base64"
 	"os"
-	"strconv"
-	"strings"
 
 	"gitea.fcdm.top/lixuan/keen"
+	"gitea.fcdm.top/lixuan/keen/util"
 	"github.com/cnyjp/fcdmpublic/model"
 )
 
@@ -21,92 +20,50 @@ var (
 	}
 )
 
+// FCDMArgument FCDM通过环境变量传递给插件的参数，env标签对应model中的环境变量名称
 type FCDMArgument struct {
-	Command                 string            `json:"command"`
-	ApplicationName         string            `json:"application_name"`
-	ApplicationExtension    string            `json:"application_extension"`
-	Configs                 map[string]string `json:"configs"`
-	ImageConfigs            map[string]string `json:"image_configs"`
-	VolumeInformation       map[string]string `json:"volumes_information"`
-	VolsIdentityInformation map[string]string `json:"volumes_identity_information"`
-	BackupType              string            `json:"backup_type"`
-	BackupClusterMessage    string            `json:"backup_cluster_message"`
-	JobStep                 string            `json:"job_step"`
-	JobType                 string            `json:"job_type"`
-	JobID                   string            `json:"job_id"` // task id
+	Command                 string            `json:"command" env:"FCDM_EV_COMMAND"`
+	ApplicationName         string            `json:"application_name" env:"FCDM_EV_APPNAME"`
+	ApplicationExtension    string            `json:"application_extension" env:"FCDM_EV_APP_EXTENSION"`
+	Configs                 map[string]string `json:"configs" env:"FCDM_EV_AD_,prefix"`
+	ImageConfigs            map[string]string `json:"image_configs" env:"FCDM_EV_IMAGE_AD_,prefix"`
+	VolumeInformation       map[string]string `json:"volumes_information" env:"FCDM_EV_VOLUME_,prefix,exclude=FCDM_EV_VOLUME_IDENTITY_"`
+	VolsIdentityInformation map[string]string `json:"volumes_identity_information" env:"FCDM_EV_VOLUME_IDENTITY_,prefix"`
+	BackupType              string            `json:"backup_type" env:"FCDM_EV_JOB_BACKUP_TYPE"`
+	BackupClusterMessage    string            `json:"backup_cluster_message" env:"FCDM_EV_JOB_INIT_MESSAGE"`
+	JobStep                 string            `json:"job_step" env:"FCDM_EV_JOBSTEP"`
+	JobType                 string            `json:"job_type" env:"FCDM_EV_JOB_TYPE"`
+	JobID                   string            `json:"job_id" env:"FCDM_EV_MAINJOB_ID"` // task id
 }
 
+// NewFCDMArgument 从当前进程的环境变量中解析参数，无法解析的变量会记录日志并被忽略
 func NewFCDMArgument() FCDMArgument {
-	env := make(map[string]string)
-	for _, line := range os.Environ() {
-		segs := strings.Split(line, "=")
-		env[segs[0]] = segs[1]
-	}
-
-	cmd := env[model.FCDM_EV_COMMAND]
-	appName := env[model.FCDM_EV_APPNAME]
-	appExt := env[model.FCDM_EV_APP_EXTENSION]
-	jobStep := env[model.FCDM_EV_JOBSTEP]
-	jobType := env[model.FCDM_EV_JOB_TYPE]
-	jobID := env[model.FCDM_EV_MAINJOB_ID]
-
-	cfg := make(map[string]string)
-	icfg := make(map[string]string)
-	vi := make(map[string]string)
-	vii := make(map[string]string)
-	for k, v := range env {
-		if strings.HasPrefix(k, model.FCDM_EV_AD_PREFIX) {
-			cfg[k] = v
-		} else if strings.HasPrefix(k, model.FCDM_EV_IMAGE_AD_PREFIX) {
-			icfg[k] = v
-		} else if strings.HasPrefix(k, model.FCDM_EV_VOLUME_PREFIX) {
-			if strings.HasPrefix(k, model.FCDM_EV_VOLUME_IDENTITY_PREFIX) {
-				vii[k] = v
-			} else {
-				vi[k] = v
-			}
-		}
+	arg, err := ParseFCDMArgument(os.Environ())
+	if err != nil {
+		keen.Log.Warn("failed to parse some FCDM environment variables: %v", err)
 	}
+	return arg
+}
 
-	bkt := env[model.FCDM_EV_JOB_BACKUP_TYPE]
-	bkc := env[model.FCDM_EV_JOB_INIT_MESSAGE]
-
-	return FCDMArgument{
-		cmd, appName, appExt, cfg, icfg, vi, vii, bkt, bkc, jobStep, jobType, jobID,
-	}
+// ParseFCDMArgument 从os.Environ()格式的环境变量中解析参数
+func ParseFCDMArgument(environ []string) (FCDMArgument, error) {
+	var arg FCDMArgument
+	err := util.BindEnv(&arg, environ)
+	return arg, err
 }
 
-// MapCommand 文件日志名称中命令的对应名称
+// MapCommand 文件日志名称中命令的对应名称，未注册的命令为illegal
 func (arg FCDMArgument) MapCommand() string {
-	switch arg.Command {
-	case model.CMD_DISCOVER:
-		return "discover"
-	case model.CMD_APPLICATION_INFO:
-		return "refresh"
-	case model.CMD_BACKUP:
-		return "backup"
-	case model.CMD_MOUNT:
-		return "mount"
-	case model.CMD_UMOUNT:
-		return "umount"
-	case model.CMD_RESTORE:
-		return "restore"
-	case model.CMD_PLUGIN_INFO:
-		return "pluginfo"
-	default:
-		return "illegal"
+	if c, ok := LookupCommand(arg.Command); ok {
+		return c.LogName
 	}
+	return LOG_NAME_ILLEGAL
 }
 
-// IsLegal 判断命令是否有效
+// IsLegal 判断命令是否已注册
 func (arg FCDMArgument) IsLegal() bool {
-	return arg.Command == model.CMD_DISCOVER ||
-		arg.Command == model.CMD_APPLICATION_INFO ||
-		arg.Command == model.CMD_BACKUP ||
-		arg.Command == model.CMD_MOUNT ||
-		arg.Command == model.CMD_UMOUNT ||
-		arg.Command == model.CMD_RESTORE ||
-		arg.Command == model.CMD_PLUGIN_INFO
+	_, ok := LookupCommand(arg.Command)
+	return ok
 }
 
 func (arg FCDMArgument) IsSyncDistributeInstance() bool {
@@ -149,65 +106,17 @@ func (arg FCDMArgument) GetVolume(volumeIdentity string) string {
 	return arg.VolumeInformation[model.FCDM_EV_VOLUME_PREFIX+volumeIdentity]
 }
 
-// Validate 对参数的有效值进行基本校验
+// Validate 对参数的有效值进行基本校验，校验规则由注册的命令决定
 func (arg FCDMArgument) Validate() bool {
-	var r bool
-	// 命令必须有效
-	r = arg.IsLegal()
-	if !r {
+	c, ok := LookupCommand(arg.Command)
+	if !ok {
 		keen.Log.Warn("command is illegal")
-		return r
+		return false
 	}
 
-	// job ID 不能为空
-	r = arg.JobID != ""
-	if !r {
-		keen.Log.Warn("the job ID is empty")
-		return r
+	if err := c.check(arg); err != nil {
+		keen.Log.Warn("%s: %v", c.LogName, err)
+		return false
 	}
-
-	validVols := func() bool {
-		return len(arg.VolumeInformation) > 0
-	}
-
-	// 对于备份、恢复、挂载、卸载，volume路径必须存在
-	if arg.Command == model.CMD_BACKUP {
-		r = validVols()
-		if !r {
-			keen.Log.Warn("backup: volume information is empty")
-			return r
-		}
-
-		bt, err := strconv.Atoi(arg.BackupType)
-		if err != nil {
-			r = false
-			keen.Log.Warn("backup: backup type [%s] is illegal: %v", arg.BackupType, err)
-			return r
-		}
-		if bt != model.BACKUP_TYPE_ALL && bt != model.BACKUP_TYPE_DB && bt != model.BACKUP_TYPE_LOG {
-			r = false
-			keen.Log.Error("backup: backup type [%d] is out of scope", bt)
-			return r
-		}
-	} else if arg.Command == model.CMD_RESTORE {
-		r = validVols()
-		if !r {
-			keen.Log.Warn("restore: volume information is empty")
-			return r
-		}
-	} else if arg.Command == model.CMD_MOUNT {
-		r = validVols()
-		if !r {
-			keen.Log.Warn("mount: volume information is empty")
-			return r
-		}
-	} else if arg.Command == model.CMD_UMOUNT {
-		r = validVols()
-		if !r {
-			keen.Log.Warn("unmount: volume information is empty")
-			return r
-		}
-	}
-
-	return r
+	return true
 }
@@ -2,10 +2,14 @@ package pvd
 
 import (
 	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"runtime/debug"
 	"strconv"
 	"time"
 
 	"gitea.fcdm.top/lixuan/keen"
+	"gitea.fcdm.top/lixuan/keen/util"
 	"gitea.fcdm.top/lixuan/keen/ylog"
 	"github.com/cnyjp/fcdmpublic/model"
 )
@@ -52,235 +56,333 @@ func ValidateConfig(pvd Provider) bool {
 	return r
 }
 
-func Do(pvd Provider, env FCDMArgument) int {
-	switch env.Command {
-	case model.CMD_DISCOVER:
-		keen.Log.Info("start to discover applications")
-		if !ValidateConfig(pvd) {
-			return C_ERR_EXIT
-		}
-
-		apps, err := pvd.DiscoverApplications()
-		if err != nil {
-			keen.Log.Error("failed to discover applications in the target host: %v", err)
-			return C_ERR_EXIT
+// Do 执行命令，无论命令成功、失败还是发生panic，都只输出一个结果文档
+func Do(pvd Provider, env FCDMArgument) (code int) {
+	// 长时间运行的任务可以通过SIGHUP或者控制文件调整日志级别
+	stop := keen.Levels.Watch()
+	defer stop()
+
+	j := newJob(env)
+	j.begin()
+	defer func() {
+		if r := recover(); r != nil {
+			keen.Log.Error("panic occurred while executing the command [%s]: %v\n%s", env.Command, r, string(debug.Stack()))
+			if err := keen.Log.DumpCrash(); err != nil {
+				ylog.Errorf("%v\n", err)
+			}
+			j.panicked = true
+			code = j.fail(fmt.Errorf("panic: %v", r))
 		}
+		// 结果文档最后输出，任务结束时的日志不会出现在结果之后
+		doc, c := j.document(code)
+		j.finish(c)
+		code = j.commit(doc, c)
+	}()
+
+	// 过期的恢复保护快照在每次执行命令时清理，不依赖之后是否还有开启保护的恢复
+	if err := Protections.Purge(); err != nil {
+		keen.Log.Warn("failed to purge the expired restore protections: %v", err)
+	}
 
-		keen.Log.Info("start to transform custom application to FCDM specific application")
-		xapps := make([]model.Application, 0, len(apps))
-		for _, app := range apps {
-			xapps = append(xapps, app.ToFCDMApplication())
-		}
-		bs, _ := json.MarshalIndent(xapps, "", "  ")
-		keen.Log.Debug("transform result:\n%s", string(bs))
+	j.bindLogger(pvd)
+	return do(pvd, env, j)
+}
 
-		bs, err = json.Marshal(xapps)
-		if err != nil {
-			keen.Log.Error("failed to marshal the struct: %v", err)
-			return C_ERR_EXIT
-		}
+func do(pvd Provider, env FCDMArgument, j *Job) int {
+	cmd, ok := LookupCommand(env.Command)
+	if !ok {
+		return 0
+	}
 
-		println(string(bs))
-	case model.CMD_APPLICATION_INFO:
-		keen.Log.Info("start to refresh application information")
+	if cmd.ValidConfig {
+		j.Enter(PHASE_VALIDATE)
 		if !ValidateConfig(pvd) {
-			return C_ERR_EXIT
+			return j.fail(errInvalidConfig)
 		}
+	}
 
-		appName := env.ApplicationName
-		app, err := pvd.FindApplication(appName)
+	res, err := cmd.Run(pvd, env, j)
+	if res != nil {
+		j.Enter(PHASE_OUTPUT)
+		bs, err := marshalResult(res)
 		if err != nil {
-			keen.Log.Error("failed to find the specific application [%s]: %v", appName, err)
-			return C_ERR_EXIT
+			keen.Log.Error("failed to marshal the struct: %v", err)
+			return j.fail(err)
 		}
 
-		keen.Log.Info("start to transform custom application to FCDM specific application")
-		xapp := app.ToFCDMApplication()
-		bs, _ := json.MarshalIndent(xapp, "", "  ")
-		keen.Log.Debug("transform result:\n%s", string(bs))
+		j.result = res
+		j.output(bs)
+	}
+	if err != nil {
+		return j.fail(err)
+	}
 
-		bs, err = json.Marshal(xapp)
-		if err != nil {
-			keen.Log.Error("failed to marshal the struct: %v", err)
-			return C_ERR_EXIT
-		}
+	return 0
+}
 
-		println(string(bs))
-	case model.CMD_BACKUP:
-		keen.Log.Info("start to backup the application")
-		if !ValidateConfig(pvd) {
-			return C_ERR_EXIT
-		}
+func discover(pvd Provider, env FCDMArgument, j *Job) (any, error) {
+	keen.Log.Info("start to discover applications")
+	j.Enter(PHASE_DISCOVER)
+	apps, err := pvd.DiscoverApplications()
+	if err != nil {
+		keen.Log.Error("failed to discover applications in the target host: %v", err)
+		return nil, err
+	}
 
-		keen.Log.Info("start to find the specific application")
-		appName := env.ApplicationName
-		app, err := pvd.FindApplication(appName)
-		if err != nil {
-			keen.Log.Error("failed to find the specific application [%s]: %v", appName, err)
-			return C_ERR_EXIT
-		}
-		keen.Log.Info("find the specific application completely")
-
-		bkType, _ := strconv.Atoi(env.BackupType)
-		keen.Log.Trace("current backup type: [%s]", bkType)
-		switch bkType {
-		case model.BACKUP_TYPE_ALL:
-			keen.Log.Info("start to backup all of the application")
-			img, err := app.BackupAll()
-			if err != nil {
-				keen.Log.Error("failed to backup all of the application: %v", err)
-				return C_ERR_EXIT
-			}
+	keen.Log.Info("start to transform custom application to FCDM specific application")
+	j.Enter(PHASE_TRANSFORM)
+	xapps := make([]model.Application, 0, len(apps))
+	for _, app := range apps {
+		j.bindLogger(app)
+		xapps = append(xapps, app.ToFCDMApplication())
+	}
+	bs, _ := json.MarshalIndent(xapps, "", "  ")
+	keen.Log.Debug("transform result:\n%s", string(bs))
 
-			bs, _ := json.MarshalIndent(img, "", "  ")
-			keen.Log.Debug("image information:\n%s", string(bs))
+	diff, err := Discoveries.Track(apps)
+	if err != nil {
+		keen.Log.Warn("failed to track the changes of discovered applications: %v", err)
+	} else {
+		bs, _ = json.MarshalIndent(diff, "", "  ")
+		keen.Log.Debug("changes since the last discovery:\n%s", string(bs))
+		if !diff.Empty() {
+			keen.Log.Info("applications changed since the last discovery: added %d, removed %d, changed %d", len(diff.Added), len(diff.Removed), len(diff.Changed))
+			j.Notify(EVENT_DISCOVERY_CHANGED, diff)
+		}
+	}
 
-			bs, err = json.Marshal(img)
-			if err != nil {
-				keen.Log.Error("failed to marshal the struct: %v", err)
-				return C_ERR_EXIT
-			}
+	return xapps, nil
+}
 
-			println(string(bs))
-		case model.BACKUP_TYPE_DB:
-			keen.Log.Info("start to only backup data of the application")
-			img, err := app.BackupDataOnly()
-			if err != nil {
-				keen.Log.Error("failed to only backup data of the application: %v", err)
-				return C_ERR_EXIT
-			}
+// findApplication 查找参数中指定的应用
+func findApplication(pvd Provider, env FCDMArgument, j *Job) (BackupApplication, error) {
+	j.Enter(PHASE_FIND)
+	appName := env.ApplicationName
+	app, err := pvd.FindApplication(appName)
+	if err != nil {
+		keen.Log.Error("failed to find the specific application [%s]: %v", appName, err)
+		return nil, err
+	}
+	j.bindLogger(app)
+	return app, nil
+}
 
-			bs, _ := json.MarshalIndent(img, "", "  ")
-			keen.Log.Debug("image information:\n%s", string(bs))
+// parseBackupImage 从元数据文件中解析镜像
+func parseBackupImage(pvd Provider, j *Job) (BackupImage, error) {
+	keen.Log.Info("start to parse the image from meta file")
+	j.Enter(PHASE_PARSE)
+	img, err := pvd.ParseBackupImage()
+	if err != nil {
+		keen.Log.Error("failed to parse the backup image: %v", err)
+		return nil, err
+	}
 
-			bs, err = json.Marshal(img)
-			if err != nil {
-				keen.Log.Error("failed to marshal the struct: %v", err)
-				return C_ERR_EXIT
-			}
+	bs, _ := json.MarshalIndent(img, "", "  ")
+	keen.Log.Info("parse result:\n%s", string(bs))
+	return img, nil
+}
 
-			println(string(bs))
-		case model.BACKUP_TYPE_LOG:
-			keen.Log.Info("start to only backup log of the application")
-			img, err := app.BackupAll()
-			if err != nil {
-				keen.Log.Error("failed to only backup log of the application: %v", err)
-				return C_ERR_EXIT
-			}
+func refresh(pvd Provider, env FCDMArgument, j *Job) (any, error) {
+	keen.Log.Info("start to refresh application information")
+	app, err := findApplication(pvd, env, j)
+	if err != nil {
+		return nil, err
+	}
 
-			bs, _ := json.MarshalIndent(img, "", "  ")
-			keen.Log.Debug("image information:\n%s", string(bs))
+	keen.Log.Info("start to transform custom application to FCDM specific application")
+	j.Enter(PHASE_TRANSFORM)
+	xapp := app.ToFCDMApplication()
+	bs, _ := json.MarshalIndent(xapp, "", "  ")
+	keen.Log.Debug("transform result:\n%s", string(bs))
 
-			bs, err = json.Marshal(img)
-			if err != nil {
-				keen.Log.Error("failed to marshal the struct: %v", err)
-				return C_ERR_EXIT
-			}
+	return xapp, nil
+}
 
-			println(string(bs))
+func backup(pvd Provider, env FCDMArgument, j *Job) (any, error) {
+	keen.Log.Info("start to backup the application")
+	keen.Log.Info("start to find the specific application")
+	app, err := findApplication(pvd, env, j)
+	if err != nil {
+		return nil, err
+	}
+	keen.Log.Info("find the specific application completely")
+
+	bkType, _ := strconv.Atoi(env.BackupType)
+	keen.Log.Trace("current backup type: [%d]", bkType)
+	j.Enter(PHASE_BACKUP)
+	var img BackupImage
+	switch bkType {
+	case model.BACKUP_TYPE_ALL:
+		keen.Log.Info("start to backup all of the application")
+		img, err = app.BackupAll()
+		if err != nil {
+			keen.Log.Error("failed to backup all of the application: %v", err)
+			return nil, err
 		}
-	case model.CMD_RESTORE:
-		keen.Log.Info("start to restore the backup iamge to application")
-		if !ValidateConfig(pvd) {
-			return C_ERR_EXIT
+	case model.BACKUP_TYPE_DB:
+		keen.Log.Info("start to only backup data of the application")
+		img, err = app.BackupDataOnly()
+		if err != nil {
+			keen.Log.Error("failed to only backup data of the application: %v", err)
+			return nil, err
 		}
-
-		keen.Log.Info("start to parse the image from meta file")
-		img, err := pvd.ParseBackupImage()
+	case model.BACKUP_TYPE_LOG:
+		keen.Log.Info("start to only backup log of the application")
+		img, err = app.BackupLogOnly()
 		if err != nil {
-			keen.Log.Error("failed to parse the backup image: %v", err)
-			return C_ERR_EXIT
+			keen.Log.Error("failed to only backup log of the application: %v", err)
+			return nil, err
 		}
+	default:
+		return nil, nil
+	}
 
-		bs, _ := json.MarshalIndent(img, "", "  ")
-		keen.Log.Info("parse result:\n%s", string(bs))
+	bs, _ := json.MarshalIndent(img, "", "  ")
+	keen.Log.Debug("image information:\n%s", string(bs))
 
-		keen.Log.Info("start to find the specific application")
-		appName := env.ApplicationName
-		app, err := pvd.FindApplication(appName)
-		if err != nil {
-			keen.Log.Error("failed to find the specific application [%s]: %v", appName, err)
-			return C_ERR_EXIT
-		}
+	return img, nil
+}
 
-		keen.Log.Info("start to restore the backup image")
-		err = app.Restore(img)
-		if err != nil {
-			keen.Log.Error("failed to restore the backup image: %v", err)
-			return C_ERR_EXIT
-		}
-		keen.Log.Info("restore the backup image completely")
-	case model.CMD_MOUNT:
-		keen.Log.Info("start to mount the backup iamge to application")
-		if !ValidateConfig(pvd) {
-			return C_ERR_EXIT
-		}
+func restore(pvd Provider, env FCDMArgument, j *Job) (any, error) {
+	keen.Log.Info("start to restore the backup iamge to application")
+	img, err := parseBackupImage(pvd, j)
+	if err != nil {
+		return nil, err
+	}
+
+	keen.Log.Info("start to find the specific application")
+	app, err := findApplication(pvd, env, j)
+	if err != nil {
+		return nil, err
+	}
 
-		keen.Log.Info("start to parse the image from meta file")
-		img, err := pvd.ParseBackupImage()
+	var prot *Protection
+	if Protections.Enabled() {
+		keen.Log.Info("start to protect the data of the application")
+		j.Enter(PHASE_PROTECT)
+		prot, err = Protections.Protect(app, env.JobID)
 		if err != nil {
-			keen.Log.Error("failed to parse the backup image: %v", err)
-			return C_ERR_EXIT
+			keen.Log.Error("failed to protect the data of the application: %v", err)
+			return nil, err
 		}
+	}
 
-		bs, _ := json.MarshalIndent(img, "", "  ")
-		keen.Log.Info("parse result:\n%s", string(bs))
+	// 恢复失败或者发生panic时都需要回滚，panic在回滚之后继续交给Do处理
+	restored := false
+	if prot != nil {
+		defer func() {
+			j.protection = &prot.Report
+			if restored {
+				return
+			}
+			keen.Log.Info("start to roll back the data of the application")
+			j.Enter(PHASE_ROLLBACK)
+			if rerr := prot.Rollback(); rerr != nil {
+				keen.Log.Error("failed to roll back the data of the application: %v", rerr)
+			} else {
+				keen.Log.Info("roll back the data of the application completely")
+			}
+		}()
+	}
 
-		keen.Log.Info("start to find the specific application")
-		appName := env.ApplicationName
-		app, err := pvd.FindApplication(appName)
-		if err != nil {
-			keen.Log.Error("failed to find the specific application [%s]: %v", appName, err)
-			return C_ERR_EXIT
+	keen.Log.Info("start to restore the backup image")
+	j.Enter(PHASE_RESTORE)
+	err = app.Restore(img)
+	if err != nil {
+		keen.Log.Error("failed to restore the backup image: %v", err)
+		return nil, err
+	}
+	restored = true
+	keen.Log.Info("restore the backup image completely")
+
+	if prot != nil {
+		if err := prot.Release(); err != nil {
+			keen.Log.Warn("failed to release the protection of the application: %v", err)
 		}
+	}
+	return nil, nil
+}
 
-		keen.Log.Info("start to mount the backup image")
-		err = app.Mount(img)
-		if err != nil {
-			keen.Log.Error("failed to mount the backup image: %v", err)
-			return C_ERR_EXIT
+func mount(pvd Provider, env FCDMArgument, j *Job) (any, error) {
+	keen.Log.Info("start to mount the backup iamge to application")
+	img, err := parseBackupImage(pvd, j)
+	if err != nil {
+		return nil, err
+	}
+
+	keen.Log.Info("start to find the specific application")
+	app, err := findApplication(pvd, env, j)
+	if err != nil {
+		return nil, err
+	}
+
+	keen.Log.Info("start to mount the backup image")
+	j.Enter(PHASE_MOUNT)
+	appName := env.ApplicationName
+	if rec, ok, err := Mounts.Find(appName, img.Meta()); err != nil {
+		keen.Log.Warn("failed to query the mount registry: %v", err)
+	} else if ok {
+		if rec.live(app, img) {
+			keen.Log.Info("the backup image has been mounted by job [%s] at %s, skip mounting", rec.JobID, rec.Time.Format(util.COMMON_TIME_FMT))
+			return nil, nil
 		}
-		keen.Log.Info("mount the backup image completely")
-	case model.CMD_UMOUNT:
-		keen.Log.Info("start to unmount the backup iamge to application")
-		if !ValidateConfig(pvd) {
-			return C_ERR_EXIT
+		keen.Log.Warn("the mount registered by job [%s] at %s is stale, mount the backup image again", rec.JobID, rec.Time.Format(util.COMMON_TIME_FMT))
+		if err := Mounts.Remove(appName, img.Meta()); err != nil {
+			keen.Log.Warn("failed to unregister the stale mount: %v", err)
 		}
+	}
 
-		keen.Log.Info("start to parse the image from meta file")
-		img, err := pvd.ParseBackupImage()
-		if err != nil {
-			keen.Log.Error("failed to parse the backup image: %v", err)
-			return C_ERR_EXIT
-		}
+	err = app.Mount(img)
+	if err != nil {
+		keen.Log.Error("failed to mount the backup image: %v", err)
+		return nil, err
+	}
+	err = Mounts.Add(MountRecord{img.Meta(), appName, env.JobID, time.Now(), env.VolumeInformation})
+	if err != nil {
+		keen.Log.Warn("failed to register the mount: %v", err)
+	}
+	keen.Log.Info("mount the backup image completely")
+	return nil, nil
+}
 
-		bs, _ := json.MarshalIndent(img, "", "  ")
-		keen.Log.Info("parse result:\n%s", string(bs))
+func umount(pvd Provider, env FCDMArgument, j *Job) (any, error) {
+	keen.Log.Info("start to unmount the backup iamge to application")
+	img, err := parseBackupImage(pvd, j)
+	if err != nil {
+		return nil, err
+	}
 
-		keen.Log.Info("start to find the specific application")
-		appName := env.ApplicationName
-		app, err := pvd.FindApplication(appName)
-		if err != nil {
-			keen.Log.Error("failed to find the specific application [%s]: %v", appName, err)
-			return C_ERR_EXIT
-		}
+	keen.Log.Info("start to find the specific application")
+	app, err := findApplication(pvd, env, j)
+	if err != nil {
+		return nil, err
+	}
 
-		keen.Log.Info("start to unmount the backup image")
-		err = app.UnMount(img)
-		if err != nil {
-			keen.Log.Error("failed to unmount the backup image: %v", err)
-			return C_ERR_EXIT
-		}
-		keen.Log.Info("unmount the backup image completely")
-	case model.CMD_PLUGIN_INFO:
-		if !ValidateConfig(pvd) {
-			return C_ERR_EXIT
-		}
+	keen.Log.Info("start to unmount the backup image")
+	j.Enter(PHASE_UMOUNT)
+	// 登记表中的记录只作为参考，没有记录的挂载（例如登记失败）也需要卸载
+	appName := env.ApplicationName
+	if _, ok, err := Mounts.Find(appName, img.Meta()); err != nil {
+		keen.Log.Warn("failed to query the mount registry: %v", err)
+	} else if !ok {
+		keen.Log.Info("the backup image is not registered as mounted, unmount it anyway")
+	}
 
-		println(pvd.PlugInfo())
+	err = app.UnMount(img)
+	if err != nil {
+		keen.Log.Error("failed to unmount the backup image: %v", err)
+		return nil, err
+	}
+	err = Mounts.Remove(appName, img.Meta())
+	if err != nil {
+		keen.Log.Warn("failed to unregister the mount: %v", err)
 	}
+	keen.Log.Info("unmount the backup image completely")
+	return nil, nil
+}
 
-	return 0
+func pluginInfo(pvd Provider, env FCDMArgument, j *Job) (any, error) {
+	return json.RawMessage(pvd.PlugInfo()), nil
 }
 
 // Pre 处理环境变量中配置的有效性
@@ -295,16 +397,51 @@ func Pre() (FCDMArgument, bool) {
 	return env, true
 }
 
-// ProviderLogger 一般Provider的Logger配置，控制台打印INFO级别以上日志，文件日志打印TRACE级别以上日志。文件日志为7天删除+按照命令类型归档
+// 提供者日志的级别，登记在keen.Levels中，可以通过KEEN_LOG_LEVEL_PROVIDER_CONSOLE等环境变量、控制文件或者SIGHUP调整
+var (
+	ProviderConsoleLevel  = ylog.NewLevelVar(ylog.INFO)  // ProviderLogger和ProviderRingLogger的控制台日志，名称为provider_console
+	ProviderFileLevel     = ylog.NewLevelVar(ylog.TRACE) // ProviderLogger的文件日志，名称为provider_file
+	ProviderRingFileLevel = ylog.NewLevelVar(ylog.INFO)  // ProviderRingLogger的文件日志，名称为provider_ring_file
+)
+
+func init() {
+	for name, v := range map[string]*ylog.LevelVar{
+		"provider_console":   ProviderConsoleLevel,
+		"provider_file":      ProviderFileLevel,
+		"provider_ring_file": ProviderRingFileLevel,
+	} {
+		if err := keen.Levels.Register(name, v); err != nil {
+			ylog.Errorf("failed to load the log level of %s: %v\n", name, err)
+		}
+	}
+}
+
+// ProviderLogger 一般Provider的Logger配置，控制台默认打印INFO级别以上日志，文件日志默认打印TRACE级别以上日志，级别见ProviderConsoleLevel等。文件日志为7天删除+按照命令类型归档，
+// 写入失败时重新打开文件重试一次，连续失败10次之后停用，每分钟尝试恢复一次
 func ProviderLogger(logPath, fileName string) ylog.Logger {
-	var logger ylog.Logger
-	console := ylog.NewConsoleWriter(func(i int8) bool { return i >= ylog.INFO }, true)
-	file, err := ylog.NewFileWriter(logPath, fileName, func(i int8) bool { return i >= ylog.TRACE }, 7*24*time.Hour, SimpleArch)
+	return providerLogger(logPath, fileName, ProviderFileLevel)
+}
+
+// ProviderRingLogger 与ProviderLogger相同，但是文件日志默认只打印INFO级别以上日志，所有级别的最近ringSize条日志保留在内存中，
+// 在Fatal、Do中发生panic或者调用DumpCrash时写入日志文件旁边的崩溃文件（日志文件名称加上ylog.CRASH_FILE_EXT）
+func ProviderRingLogger(logPath, fileName string, ringSize int) ylog.Logger {
+	ring := ylog.NewRingWriter(ringSize, filepath.Join(logPath, fileName+ylog.CRASH_FILE_EXT))
+	return providerLogger(logPath, fileName, ProviderRingFileLevel, ring)
+}
+
+func providerLogger(logPath, fileName string, fileLevel *ylog.LevelVar, extra ...ylog.LogWriter) ylog.Logger {
+	console := ylog.NewConsoleWriter(ProviderConsoleLevel.Enabled, true)
+	writers := []ylog.LogWriter{console}
+	file, err := ylog.NewFileWriter(logPath, fileName, fileLevel.Enabled, 7*24*time.Hour, SimpleArch)
 	if err != nil {
 		ylog.Errorf("failed to create file logger: %v", err)
-		logger = ylog.NewLogger(console)
 	} else {
-		logger = ylog.NewLogger(console, file)
+		writers = append(writers, ylog.NewFailoverWriter(file, ylog.FailoverOptions{
+			Retries:      1,
+			Reopen:       true,
+			DisableAfter: 10,
+			RecoverAfter: time.Minute,
+		}))
 	}
-	return logger
+	return ylog.NewLogger(append(writers, extra...)...)
 }
@@ -0,0 +1,162 @@
+package pvd
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen"
+	"gitea.fcdm.top/lixuan/keen/ylog"
+)
+
+const (
+	PHASE_VALIDATE  = "validate"
+	PHASE_DISCOVER  = "discover"
+	PHASE_FIND      = "find"
+	PHASE_TRANSFORM = "transform"
+	PHASE_BACKUP    = "backup"
+	PHASE_PARSE     = "parse"
+	PHASE_PROTECT   = "protect"
+	PHASE_RESTORE   = "restore"
+	PHASE_ROLLBACK  = "rollback"
+	PHASE_MOUNT     = "mount"
+	PHASE_UMOUNT    = "umount"
+	PHASE_OUTPUT    = "output"
+)
+
+var errInvalidConfig = errors.New("failed to validate the configuration")
+
+// Job 一次命令执行的生命周期，记录当前阶段、各阶段耗时、结果和错误，并发送生命周期事件
+type Job struct {
+	env        FCDMArgument
+	phase      string
+	result     any
+	doc        []byte
+	err        error
+	panicked   bool
+	start      time.Time
+	end        time.Time
+	phaseStart time.Time
+	durations  map[string]time.Duration
+	protection *ProtectionReport
+	log        ylog.Logger
+}
+
+func newJob(env FCDMArgument) *Job {
+	return &Job{
+		env:       env,
+		durations: make(map[string]time.Duration),
+		log:       JobLogger(keen.Log, env),
+	}
+}
+
+func (j *Job) event(typ string) Event {
+	e := Event{
+		Type:        typ,
+		Time:        time.Now(),
+		JobID:       j.env.JobID,
+		Command:     j.env.Command,
+		Application: j.env.ApplicationName,
+		Phase:       j.phase,
+	}
+	if j.err != nil {
+		e.Error = j.err.Error()
+	}
+	return e
+}
+
+func (j *Job) begin() {
+	j.start = time.Now()
+	Notify.Emit(j.event(EVENT_JOB_STARTED))
+}
+
+// closePhase 累计当前阶段的耗时
+func (j *Job) closePhase(now time.Time) {
+	if j.phase != "" {
+		j.durations[j.phase] += now.Sub(j.phaseStart)
+	}
+}
+
+// Enter 进入新的执行阶段
+func (j *Job) Enter(phase string) {
+	now := time.Now()
+	j.closePhase(now)
+	j.phase = phase
+	j.phaseStart = now
+	Notify.Emit(j.event(EVENT_PHASE_CHANGED))
+}
+
+// Notify 发送任务执行过程中产生的其他事件
+func (j *Job) Notify(typ string, result any) {
+	e := j.event(typ)
+	e.Result = result
+	Notify.Emit(e)
+}
+
+func (j *Job) elapsed() time.Duration {
+	return j.end.Sub(j.start)
+}
+
+// errorClass 失败任务的错误分类，即失败时所处的阶段
+func (j *Job) errorClass() string {
+	if j.panicked {
+		return "panic"
+	}
+	if j.phase == "" {
+		return "unknown"
+	}
+	return j.phase
+}
+
+// fail 记录任务失败的原因，返回错误退出码
+func (j *Job) fail(err error) int {
+	j.err = err
+	return C_ERR_EXIT
+}
+
+// output 记录命令的结果文档，在任务结束时统一输出
+func (j *Job) output(doc []byte) {
+	j.doc = doc
+}
+
+// document 生成唯一的结果文档，命令没有产生结果或者结果不是合法的json时使用Outcome，返回文档和最终的退出码
+func (j *Job) document(code int) ([]byte, int) {
+	doc := j.doc
+	if doc != nil && !json.Valid(doc) {
+		keen.Log.Error("the result document is not a valid json:\n%s", string(doc))
+		code = j.fail(errInvalidResult)
+		doc = nil
+	}
+	if doc == nil {
+		o := Outcome{Code: code, Protection: j.protection}
+		if j.err != nil {
+			o.Error = j.err.Error()
+		}
+		doc, _ = json.Marshal(o)
+	}
+	return doc, code
+}
+
+// commit 输出结果文档，在finish之后调用，保证结果是输出中的最后一个文档
+func (j *Job) commit(doc []byte, code int) int {
+	if err := Results.WriteResult(doc); err != nil {
+		keen.Log.Error("failed to write the result: %v", err)
+		return j.fail(err)
+	}
+	return code
+}
+
+func (j *Job) finish(code int) {
+	j.end = time.Now()
+	j.closePhase(j.end)
+	if err := Metrics.record(j, code); err != nil {
+		keen.Log.Warn("failed to record the metrics of job [%s]: %v", j.env.JobID, err)
+	}
+
+	e := j.event(EVENT_JOB_FINISHED)
+	e.Code = code
+	if code == 0 {
+		e.Result = j.result
+	}
+	Notify.Emit(e)
+}
@@ -0,0 +1,42 @@
+package pvd
+
+import (
+	"gitea.fcdm.top/lixuan/keen/ylog"
+)
+
+const (
+	LOG_FIELD_JOB_ID   = "job_id"
+	LOG_FIELD_COMMAND  = "command"
+	LOG_FIELD_APP      = "app"
+	LOG_FIELD_JOB_STEP = "job_step"
+)
+
+// LoggerReceiver 需要任务范围Logger的Provider或应用，Do在执行命令之前为Provider设置，
+// 查找到应用之后为应用设置，实现者可以用它代替全局的keen.Log
+type LoggerReceiver interface {
+	SetLogger(log ylog.Logger)
+}
+
+// JobLogger 在base的基础上绑定任务ID、命令、应用名称和任务阶段，与base共享输出目标，为空的应用名称和任务阶段不绑定
+func JobLogger(base ylog.Logger, env FCDMArgument) ylog.Logger {
+	kv := []any{LOG_FIELD_JOB_ID, env.JobID, LOG_FIELD_COMMAND, env.MapCommand()}
+	if env.ApplicationName != "" {
+		kv = append(kv, LOG_FIELD_APP, env.ApplicationName)
+	}
+	if env.JobStep != "" {
+		kv = append(kv, LOG_FIELD_JOB_STEP, env.JobStep)
+	}
+	return base.With(kv...)
+}
+
+// Logger 返回任务范围的Logger，供注册的命令使用
+func (j *Job) Logger() ylog.Logger {
+	return j.log
+}
+
+// bindLogger v实现LoggerReceiver时设置任务范围的Logger
+func (j *Job) bindLogger(v any) {
+	if r, ok := v.(LoggerReceiver); ok {
+		r.SetLogger(j.log)
+	}
+}
@@ -0,0 +1,146 @@
+package pvd_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen"
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"gitea.fcdm.top/lixuan/keen/pvd/pvdtest"
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/cnyjp/fcdmpublic/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// loggedProvider 实现LoggerReceiver的Provider，查找到的应用也实现LoggerReceiver
+type loggedProvider struct {
+	*pvdtest.Provider
+	log *ylog.Logger
+	app *loggedApp
+}
+
+func (p *loggedProvider) SetLogger(log ylog.Logger) {
+	p.log = &log
+}
+
+func (p *loggedProvider) FindApplication(appName string) (pvd.BackupApplication, error) {
+	app, err := p.Provider.FindApplication(appName)
+	if err != nil {
+		return nil, err
+	}
+	p.app = &loggedApp{Application: app.(*pvdtest.Application)}
+	return p.app, nil
+}
+
+type loggedApp struct {
+	*pvdtest.Application
+	log *ylog.Logger
+}
+
+func (app *loggedApp) SetLogger(log ylog.Logger) {
+	app.log = &log
+}
+
+func bufferLog(t *testing.T) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	old := keen.Log
+	keen.Log = ylog.NewLogger(ylog.NewConsoleWriterTo(buf, func(i int8) bool { return i >= ylog.TRACE }, false))
+	t.Cleanup(func() { keen.Log = old })
+	return buf
+}
+
+func TestJobLogger(t *testing.T) {
+	buf := bufferLog(t)
+	arg := pvdtest.NewArgument(model.CMD_BACKUP, "orcl")
+	l := pvd.JobLogger(keen.Log, arg)
+	l.InfoKV("backup started", "size", 10)
+	assert.Contains(t, buf.String(), "- backup started job_id=pvdtest-job command=backup app=orcl job_step="+arg.JobStep+" size=10\n")
+
+	buf.Reset()
+	l = pvd.JobLogger(keen.Log, pvd.FCDMArgument{Command: model.CMD_DISCOVER, JobID: "j2"})
+	l.Info("discover")
+	assert.Contains(t, buf.String(), "- discover job_id=j2 command=discover\n", "empty application and job step are not bound")
+}
+
+func TestLoggerReceiver(t *testing.T) {
+	pvdtest.TempState(t)
+	buf := bufferLog(t)
+	p := &loggedProvider{Provider: pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"))}
+
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_BACKUP, "orcl")).Code)
+	if assert.NotNil(t, p.log, "the provider should receive the job logger") {
+		p.log.Warn("from provider")
+	}
+	if assert.NotNil(t, p.app) && assert.NotNil(t, p.app.log, "the application should receive the job logger") {
+		p.app.log.Warn("from application")
+	}
+	assert.Regexp(t, `- from provider job_id=pvdtest-job command=backup app=orcl`, buf.String())
+	assert.Regexp(t, `- from application job_id=pvdtest-job command=backup app=orcl`, buf.String())
+}
+
+func TestPanicDumpsCrash(t *testing.T) {
+	pvdtest.TempState(t)
+	dir := t.TempDir()
+	name := pvd.GenLogName("discover", "pvdtest-job")
+	old := keen.Log
+	keen.Log = pvd.ProviderRingLogger(dir, name, 100)
+	t.Cleanup(func() {
+		keen.Log.Clean()
+		keen.Log = old
+	})
+
+	keen.Log.Trace("detail before the job")
+	p := panicProvider{pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"))}
+	assert.Equal(t, pvd.C_ERR_EXIT, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_DISCOVER, "")).Code)
+
+	// TRACE级别的日志只在崩溃文件中
+	bs, err := os.ReadFile(filepath.Join(dir, name+ylog.CRASH_FILE_EXT))
+	assert.NoError(t, err)
+	assert.Contains(t, string(bs), "detail before the job")
+	assert.Contains(t, string(bs), "panic occurred while executing the command")
+	bs, err = os.ReadFile(filepath.Join(dir, name))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(bs), "detail before the job")
+	assert.Contains(t, string(bs), "panic occurred while executing the command")
+
+	// 崩溃文件可以按照任务查询
+	res, err := pvd.QueryLogs(dir, pvd.LogQuery{JobID: "pvdtest-job", MinLevel: ylog.ERROR})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, res)
+}
+
+func TestProviderLoggerLevels(t *testing.T) {
+	dir := t.TempDir()
+	name := pvd.GenLogName("discover", "pvdtest-job")
+	logger := pvd.ProviderLogger(dir, name)
+	t.Cleanup(logger.Clean)
+	// 环境变量恢复之后重新加载
+	t.Cleanup(func() { keen.Levels.Reload() })
+
+	logger.Trace("trace by default")
+	t.Setenv(keen.ENV_LOG_LEVEL+"_PROVIDER_FILE", "warn")
+	assert.NoError(t, keen.Levels.Reload())
+	assert.Equal(t, int8(ylog.WARN), pvd.ProviderFileLevel.Level())
+	logger.Info("hidden info")
+	logger.Warn("shown warn")
+
+	bs, err := os.ReadFile(filepath.Join(dir, name))
+	assert.NoError(t, err)
+	assert.Contains(t, string(bs), "trace by default")
+	assert.NotContains(t, string(bs), "hidden info")
+	assert.Contains(t, string(bs), "shown warn")
+
+	// 控制文件同样生效
+	file := filepath.Join(t.TempDir(), "levels")
+	assert.NoError(t, os.WriteFile(file, []byte("provider_file=trace\n"), 0600))
+	old := keen.Levels.File
+	keen.Levels.File = file
+	t.Cleanup(func() { keen.Levels.File = old })
+	assert.NoError(t, keen.Levels.Reload())
+	logger.Trace("trace again")
+	bs, err = os.ReadFile(filepath.Join(dir, name))
+	assert.NoError(t, err)
+	assert.Contains(t, string(bs), "trace again")
+}
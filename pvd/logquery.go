@@ -0,0 +1,99 @@
+package pvd
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+)
+
+// logFileReg GenLogName生成的文件名称，包括压缩之后的文件，不依赖当前进程注册的命令
+var logFileReg = regexp.MustCompile(`^([a-z0-9]+)_(.+)_(\d{14})\.log(\.[a-z0-9]+)?$`)
+
+// ParseLogName 从GenLogName生成的文件名称中解析命令的日志名称和任务ID
+func ParseLogName(name string) (cmd, jobId string, ok bool) {
+	gs := logFileReg.FindStringSubmatch(filepath.Base(name))
+	if gs == nil {
+		return "", "", false
+	}
+	return gs[1], gs[2], true
+}
+
+// LogQuery 提供者日志的查询条件，零值匹配所有日志
+type LogQuery struct {
+	JobID    string
+	Command  string // 命令名称或者命令的日志名称
+	MinLevel int8
+	Since    time.Time // 包含
+	Until    time.Time // 不包含
+	Caller   string    // 调用者 文件:行号 包含的内容
+}
+
+// command 命令名称对应的日志名称，没有注册时原样返回
+func (q LogQuery) command() string {
+	if c, ok := LookupCommand(q.Command); ok {
+		return c.LogName
+	}
+	return q.Command
+}
+
+// MatchFile 根据文件名称判断文件中是否可能有匹配的日志，不是GenLogName生成的文件（例如打包文件）总是需要读取
+func (q LogQuery) MatchFile(name string) bool {
+	cmd, jobId, ok := ParseLogName(name)
+	if !ok {
+		return true
+	}
+	return (q.JobID == "" || q.JobID == jobId) && (q.Command == "" || q.command() == cmd)
+}
+
+// Match 判断日志是否满足条件，任务ID和命令优先使用日志中的字段，没有字段时使用日志所在文件的名称
+func (q LogQuery) Match(r ylog.Record) bool {
+	if r.Level < q.MinLevel {
+		return false
+	}
+	if !q.Since.IsZero() && r.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !r.Time.Before(q.Until) {
+		return false
+	}
+	if q.Caller != "" && !strings.Contains(r.File+":"+strconv.Itoa(r.Line), q.Caller) {
+		return false
+	}
+	if q.JobID == "" && q.Command == "" {
+		return true
+	}
+
+	fcmd, fjob, _ := ParseLogName(r.Source)
+	if q.JobID != "" {
+		v, ok := r.Field(LOG_FIELD_JOB_ID)
+		if !ok {
+			v = fjob
+		}
+		if v != q.JobID {
+			return false
+		}
+	}
+	if q.Command != "" {
+		v, ok := r.Field(LOG_FIELD_COMMAND)
+		if !ok {
+			v = fcmd
+		}
+		if v != q.command() {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryLogs 查询日志目录和归档目录中满足条件的日志，按照时间合并
+func QueryLogs(logDir string, q LogQuery) ([]ylog.Record, error) {
+	files, err := ylog.LogFiles(logDir, q.MatchFile)
+	if err != nil {
+		return nil, err
+	}
+	return ylog.ReadLogs(files, q.Match)
+}
@@ -0,0 +1,70 @@
+package pvd_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/cnyjp/fcdmpublic/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogName(t *testing.T) {
+	cmd, job, ok := pvd.ParseLogName(pvd.GenLogName("mount", "job_1"))
+	assert.True(t, ok)
+	assert.Equal(t, "mount", cmd)
+	assert.Equal(t, "job_1", job)
+
+	cmd, job, ok = pvd.ParseLogName("/logs/mount/backup_j2_20240301100000.log.gz")
+	assert.True(t, ok)
+	assert.Equal(t, "backup", cmd)
+	assert.Equal(t, "j2", job)
+
+	_, _, ok = pvd.ParseLogName("mount_202403.tar.gz")
+	assert.False(t, ok)
+}
+
+func TestQueryLogs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	// 旧的日志没有任务字段，只能从文件名称判断
+	write("mount_j1_20240301100000.log",
+		"[2024-03-01 10:00:00] [INFO ] [pvd/idea.go:10] - mount start\n"+
+			"[2024-03-01 10:00:05] [ERROR] [pvd/mount.go:20] - mount failed\n")
+	write("mount_j2_20240301100001.log",
+		"[2024-03-01 10:00:01] [INFO ] [pvd/idea.go:10] - other job\n")
+	write("listmount_j4_20240301100006.log",
+		"[2024-03-01 10:00:06] [INFO ] [pvd/mount.go:30] - listed\n")
+	// 同一个文件中的日志通过字段区分任务
+	write("shared.log",
+		"[2024-03-01 10:00:02] [WARN ] [pvd/idea.go:11] - slow job_id=j1 command=mount\n"+
+			"[2024-03-01 10:00:03] [WARN ] [pvd/idea.go:11] - slow job_id=j3 command=backup\n")
+
+	texts := func(q pvd.LogQuery) []string {
+		res, err := pvd.QueryLogs(dir, q)
+		assert.NoError(t, err)
+		ts := make([]string, 0)
+		for _, r := range res {
+			ts = append(ts, r.Text)
+		}
+		return ts
+	}
+
+	assert.Equal(t, []string{"mount start", "other job", "slow", "slow", "mount failed", "listed"}, texts(pvd.LogQuery{}))
+	assert.Equal(t, []string{"mount start", "slow", "mount failed"}, texts(pvd.LogQuery{JobID: "j1"}))
+	assert.Equal(t, []string{"slow"}, texts(pvd.LogQuery{JobID: "j1", MinLevel: ylog.WARN, Caller: "idea.go"}))
+	// 命令名称和日志名称都可以
+	assert.Equal(t, []string{"mount start", "other job", "slow", "mount failed"}, texts(pvd.LogQuery{Command: model.CMD_MOUNT}))
+	assert.Equal(t, []string{"listed"}, texts(pvd.LogQuery{Command: pvd.CMD_LIST_MOUNTS}))
+	assert.Equal(t, []string{"listed"}, texts(pvd.LogQuery{Command: "listmount"}))
+	assert.Equal(t, []string{"other job", "slow"}, texts(pvd.LogQuery{
+		Since: time.Date(2024, 3, 1, 10, 0, 1, 0, time.Local),
+		Until: time.Date(2024, 3, 1, 10, 0, 3, 0, time.Local),
+	}))
+	assert.Equal(t, []string{"mount failed"}, texts(pvd.LogQuery{Caller: "mount.go:20"}))
+}
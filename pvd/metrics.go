@@ -0,0 +1,213 @@
+package pvd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gitea.fcdm.top/lixuan/keen"
+	"gitea.fcdm.top/lixuan/keen/util"
+)
+
+const (
+	ENV_METRICS_DIR = "KEEN_METRICS_DIR"
+)
+
+// Metrics 任务指标的默认记录器，KEEN_METRICS_DIR为空时不记录
+var Metrics = NewMetricsWriter(os.Getenv(ENV_METRICS_DIR))
+
+type metricDesc struct {
+	typ  string
+	help string
+}
+
+// 计数器与文件中已有的值累加，仪表直接覆盖已有的值
+var metricDescs = map[string]metricDesc{
+	"keen_job_runs_total":                     {"counter", "Number of provider command runs by result."},
+	"keen_job_failures_total":                 {"counter", "Number of failed provider command runs by error class."},
+	"keen_job_duration_seconds":               {"gauge", "Duration of the last provider command run."},
+	"keen_job_phase_duration_seconds":         {"gauge", "Duration of each phase in the last provider command run."},
+	"keen_job_last_success_timestamp_seconds": {"gauge", "Unix time of the last successful command run per application."},
+	"keen_backup_bytes_total":                 {"counter", "Bytes written by backups."},
+	"keen_backup_images_total":                {"counter", "Number of backup images produced."},
+	"keen_backup_last_bytes":                  {"gauge", "Bytes written by the last backup."},
+	"keen_backup_throughput_bytes_per_second": {"gauge", "Throughput of the last backup."},
+}
+
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{.*\})?\s+(\S+)$`)
+
+// MetricsWriter 将任务指标以node_exporter textfile的格式原子写入目录下的.prom文件，
+// 写入前读取文件中已有的值进行合并，计数器跨进程累加
+type MetricsWriter struct {
+	Dir      string
+	Provider string
+
+	mu sync.Mutex
+}
+
+// NewMetricsWriter 提供者名称为当前可执行文件的名称，同一目录下每个提供者使用单独的文件
+func NewMetricsWriter(dir string) *MetricsWriter {
+	return &MetricsWriter{
+		Dir:      dir,
+		Provider: ProviderName(),
+	}
+}
+
+// Path .prom文件的路径
+func (m *MetricsWriter) Path() string {
+	return filepath.Join(m.Dir, "keen_"+m.Provider+".prom")
+}
+
+func labelString(labels ...string) string {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], v))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// samples 根据任务执行情况计算本次的指标，键为带标签的指标名称
+func (m *MetricsWriter) samples(j *Job, code int) map[string]float64 {
+	cmd := j.env.MapCommand()
+	app := j.env.ApplicationName
+	res := make(map[string]float64)
+	set := func(name string, v float64, labels ...string) {
+		res[name+labelString(append([]string{"provider", m.Provider}, labels...)...)] = v
+	}
+
+	result := "success"
+	if code != 0 {
+		result = "failure"
+		set("keen_job_failures_total", 1, "command", cmd, "class", j.errorClass())
+	}
+	set("keen_job_runs_total", 1, "command", cmd, "result", result)
+	set("keen_job_duration_seconds", j.elapsed().Seconds(), "command", cmd)
+	for phase, d := range j.durations {
+		set("keen_job_phase_duration_seconds", d.Seconds(), "command", cmd, "phase", phase)
+	}
+
+	if code != 0 {
+		return res
+	}
+	set("keen_job_last_success_timestamp_seconds", float64(j.end.UnixNano())/1e9, "command", cmd, "application", app)
+
+	if img, ok := j.result.(BackupImage); ok && img != nil {
+		bytes := float64(img.ToFCDMBackupImage().ProtectedDataSize)
+		set("keen_backup_bytes_total", bytes, "application", app)
+		set("keen_backup_images_total", 1, "application", app)
+		set("keen_backup_last_bytes", bytes, "application", app)
+		if d := j.durations[PHASE_BACKUP]; d > 0 {
+			set("keen_backup_throughput_bytes_per_second", bytes/d.Seconds(), "application", app)
+		}
+	}
+
+	return res
+}
+
+func (m *MetricsWriter) load() (map[string]float64, error) {
+	res := make(map[string]float64)
+	f, err := os.Open(m.Path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		gs := sampleLine.FindStringSubmatch(line)
+		if gs == nil {
+			continue
+		}
+		v, err := strconv.ParseFloat(gs[3], 64)
+		if err != nil {
+			continue
+		}
+		res[gs[1]+gs[2]] = v
+	}
+
+	return res, scanner.Err()
+}
+
+func metricName(key string) string {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// record 合并本次任务的指标并写入文件，读取、合并和写入期间持有跨进程的锁文件，避免并发的任务丢失计数
+func (m *MetricsWriter) record(j *Job, code int) error {
+	if m == nil || m.Dir == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.Dir, os.ModeDir|0755); err != nil {
+		return err
+	}
+	unlock, err := util.LockFile(m.Path() + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	all, err := m.load()
+	if err != nil {
+		return err
+	}
+	for k, v := range m.samples(j, code) {
+		if metricDescs[metricName(k)].typ == "counter" {
+			all[k] += v
+		} else {
+			all[k] = v
+		}
+	}
+
+	return m.write(all)
+}
+
+func (m *MetricsWriter) write(all map[string]float64) error {
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sb := strings.Builder{}
+	last := ""
+	for _, k := range keys {
+		name := metricName(k)
+		if name != last {
+			if desc, ok := metricDescs[name]; ok {
+				sb.WriteString(fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n", name, desc.help, name, desc.typ))
+			}
+			last = name
+		}
+		sb.WriteString(k + " " + strconv.FormatFloat(all[k], 'g', -1, 64) + "\n")
+	}
+
+	// 临时文件以.tmp结尾，node_exporter不会读到不完整的文件
+	if err := util.WriteFileAtomic(m.Path(), []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+
+	keen.Log.Debug("metrics are written to [%s]", m.Path())
+	return nil
+}
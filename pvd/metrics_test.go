@@ -0,0 +1,91 @@
+package pvd_test
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"gitea.fcdm.top/lixuan/keen/pvd/pvdtest"
+	"github.com/cnyjp/fcdmpublic/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func metricValue(t *testing.T, content, series string) string {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, series+" ") {
+			return strings.TrimPrefix(line, series+" ")
+		}
+	}
+	t.Errorf("series %s is not found in:\n%s", series, content)
+	return ""
+}
+
+func TestMetrics(t *testing.T) {
+	pvdtest.TempState(t)
+	old := pvd.Metrics
+	pvd.Metrics = pvd.NewMetricsWriter(t.TempDir())
+	pvd.Metrics.Provider = "pvdtest"
+	defer func() { pvd.Metrics = old }()
+
+	app := pvdtest.NewApplication("orcl", "oracle", "orcl_data")
+	app.Size = 1024
+	p := pvdtest.NewProvider(app)
+
+	for i := 0; i < 2; i++ {
+		res := pvdtest.Run(p, pvdtest.NewArgument(model.CMD_BACKUP, "orcl"))
+		assert.Equal(t, 0, res.Code, "backup should succeed")
+	}
+	res := pvdtest.Run(p, pvdtest.NewArgument(model.CMD_BACKUP, "missing"))
+	assert.Equal(t, pvd.C_ERR_EXIT, res.Code, "backup should fail")
+
+	bs, err := os.ReadFile(pvd.Metrics.Path())
+	assert.NoError(t, err, "metrics file should be written")
+	content := string(bs)
+
+	assert.Contains(t, content, "# TYPE keen_job_runs_total counter")
+	assert.Equal(t, "2", metricValue(t, content, `keen_job_runs_total{command="backup",provider="pvdtest",result="success"}`))
+	assert.Equal(t, "1", metricValue(t, content, `keen_job_runs_total{command="backup",provider="pvdtest",result="failure"}`))
+	assert.Equal(t, "1", metricValue(t, content, `keen_job_failures_total{class="find",command="backup",provider="pvdtest"}`))
+	assert.Equal(t, "2048", metricValue(t, content, `keen_backup_bytes_total{application="orcl",provider="pvdtest"}`))
+	assert.Equal(t, "2", metricValue(t, content, `keen_backup_images_total{application="orcl",provider="pvdtest"}`))
+	assert.Equal(t, "1024", metricValue(t, content, `keen_backup_last_bytes{application="orcl",provider="pvdtest"}`))
+	metricValue(t, content, `keen_job_last_success_timestamp_seconds{application="orcl",command="backup",provider="pvdtest"}`)
+	metricValue(t, content, `keen_job_phase_duration_seconds{command="backup",phase="backup",provider="pvdtest"}`)
+	assert.NotContains(t, content, `application="missing"`, "failed job should not record success metrics")
+}
+
+// TestMetricsHelperProcess 供TestMetricsProcesses作为独立的提供者进程运行
+func TestMetricsHelperProcess(t *testing.T) {
+	if os.Getenv("KEEN_METRICS_HELPER") == "" {
+		t.Skip("only runs as a helper process")
+	}
+	p := pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"))
+	for i := 0; i < 10; i++ {
+		pvdtest.Run(p, pvdtest.NewArgument(model.CMD_DISCOVER, ""))
+	}
+}
+
+func TestMetricsProcesses(t *testing.T) {
+	dir := t.TempDir()
+	const procs = 4
+
+	cmds := make([]*exec.Cmd, 0, procs)
+	for i := 0; i < procs; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestMetricsHelperProcess$")
+		cmd.Env = append(os.Environ(), "KEEN_METRICS_HELPER=1", pvd.ENV_METRICS_DIR+"="+dir, pvd.ENV_STATE_DIR+"="+t.TempDir())
+		assert.NoError(t, cmd.Start())
+		cmds = append(cmds, cmd)
+	}
+	for _, cmd := range cmds {
+		assert.NoError(t, cmd.Wait())
+	}
+
+	m := pvd.NewMetricsWriter(dir)
+	bs, err := os.ReadFile(m.Path())
+	assert.NoError(t, err, "metrics file should be written")
+	series := `keen_job_runs_total{command="discover",provider="` + m.Provider + `",result="success"}`
+	assert.Equal(t, strconv.Itoa(procs*10), metricValue(t, string(bs), series), "no counter increment should be lost")
+}
@@ -0,0 +1,320 @@
+package pvd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen"
+	"gitea.fcdm.top/lixuan/keen/util"
+)
+
+const (
+	CMD_LIST_MOUNTS  = "keen_list_mounts"
+	CMD_CLEAN_MOUNTS = "keen_clean_mounts"
+)
+
+const (
+	ENV_STATE_DIR    = "KEEN_STATE_DIR"
+	ENV_MOUNT_EXPIRE = "KEEN_MOUNT_EXPIRE"
+	ENV_MOUNT_FORCE  = "KEEN_MOUNT_FORCE" // 非空时清理挂载强制删除应用已经不存在的挂载记录
+)
+
+// Mounts 默认的挂载登记表，位于状态目录下
+var Mounts = NewMountRegistry(filepath.Join(StateDir(), "mounts.json"))
+
+// StateDir 提供者持久化状态的目录，默认为临时目录下的keen目录，可以通过KEEN_STATE_DIR修改
+func StateDir() string {
+	if d := os.Getenv(ENV_STATE_DIR); d != "" {
+		return d
+	}
+	return filepath.Join(os.TempDir(), "keen")
+}
+
+// ImageLoader 可以根据元数据重建备份镜像的Provider，清理挂载时需要通过它得到镜像来卸载
+type ImageLoader interface {
+	LoadBackupImage(meta string) (BackupImage, error)
+}
+
+// MountChecker 可以检查镜像是否仍然挂载的应用，挂载时用于判断登记表中的记录是否仍然有效，
+// 例如主机重启或者在提供者之外卸载之后留下的记录
+type MountChecker interface {
+	IsMounted(backupSet BackupImage) (bool, error)
+}
+
+// MountRecord 一次有效的挂载
+type MountRecord struct {
+	Image       string            `json:"image"` // 镜像的元数据
+	Application string            `json:"application"`
+	JobID       string            `json:"job_id"`
+	Time        time.Time         `json:"time"`
+	Targets     map[string]string `json:"targets"` // 挂载时的卷信息
+}
+
+// MountRegistry 以json文件保存的挂载登记表，多个进程通过锁文件互斥修改
+type MountRegistry struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func NewMountRegistry(path string) *MountRegistry {
+	return &MountRegistry{Path: path}
+}
+
+// lock 获取进程内的互斥锁和跨进程的锁文件
+func (r *MountRegistry) lock() (func(), error) {
+	r.mu.Lock()
+	unlock, err := util.LockFile(r.Path + ".lock")
+	if err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		r.mu.Unlock()
+	}, nil
+}
+
+func (r *MountRegistry) load() ([]MountRecord, error) {
+	res := make([]MountRecord, 0)
+	bs, err := os.ReadFile(r.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+	if len(bs) == 0 {
+		return res, nil
+	}
+
+	err = json.Unmarshal(bs, &res)
+	return res, err
+}
+
+func (r *MountRegistry) save(recs []MountRecord) error {
+	bs, err := json.MarshalIndent(recs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFileAtomic(r.Path, bs, 0600)
+}
+
+// List 返回所有有效的挂载
+func (r *MountRegistry) List() ([]MountRecord, error) {
+	unlock, err := r.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return r.load()
+}
+
+// Find 查找应用上指定镜像的挂载
+func (r *MountRegistry) Find(app, image string) (MountRecord, bool, error) {
+	recs, err := r.List()
+	if err != nil {
+		return MountRecord{}, false, err
+	}
+
+	for _, rec := range recs {
+		if rec.Application == app && rec.Image == image {
+			return rec, true, nil
+		}
+	}
+	return MountRecord{}, false, nil
+}
+
+// Add 登记挂载，同一应用上的同一镜像只保留最新的记录
+func (r *MountRegistry) Add(rec MountRecord) error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	recs, err := r.load()
+	if err != nil {
+		return err
+	}
+
+	res := make([]MountRecord, 0, len(recs)+1)
+	for _, o := range recs {
+		if o.Application != rec.Application || o.Image != rec.Image {
+			res = append(res, o)
+		}
+	}
+	return r.save(append(res, rec))
+}
+
+// Remove 删除挂载记录，记录不存在时不做任何操作
+func (r *MountRegistry) Remove(app, image string) error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	recs, err := r.load()
+	if err != nil {
+		return err
+	}
+
+	res := make([]MountRecord, 0, len(recs))
+	for _, o := range recs {
+		if o.Application != app || o.Image != image {
+			res = append(res, o)
+		}
+	}
+	if len(res) == len(recs) {
+		return nil
+	}
+	return r.save(res)
+}
+
+// MountCleanFailure 清理失败的挂载
+type MountCleanFailure struct {
+	MountRecord
+	Error string `json:"error"`
+}
+
+// MountCleanReport 清理挂载的结果
+type MountCleanReport struct {
+	Cleaned []MountRecord       `json:"cleaned"`
+	Failed  []MountCleanFailure `json:"failed"`
+	Kept    []MountRecord       `json:"kept"`
+}
+
+// mountExpire 挂载的有效期，超过有效期的挂载会被清理，为0时不过期
+func mountExpire() time.Duration {
+	v := os.Getenv(ENV_MOUNT_EXPIRE)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		keen.Log.Warn("the mount expiration [%s] is illegal: %v", v, err)
+		return 0
+	}
+	return d
+}
+
+// orphaned 判断挂载是否已经失去对应的挂载目标
+func (rec MountRecord) orphaned() bool {
+	for _, p := range rec.Targets {
+		if !util.PathExists(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// live 判断登记的挂载是否仍然有效：挂载目标都存在，并且应用实现MountChecker时镜像仍然挂载
+func (rec MountRecord) live(app BackupApplication, img BackupImage) bool {
+	if rec.orphaned() {
+		return false
+	}
+	if mc, ok := app.(MountChecker); ok {
+		mounted, err := mc.IsMounted(img)
+		if err != nil {
+			keen.Log.Warn("failed to check whether the backup image is mounted: %v", err)
+			return false
+		}
+		return mounted
+	}
+	return true
+}
+
+// CleanMounts 清理孤立的和过期的挂载，对每个挂载调用应用的UnMount。
+// 查找应用失败的挂载作为失败保留在登记表中，force为true时只删除记录
+func CleanMounts(pvd Provider, reg *MountRegistry, expire time.Duration, force bool) (MountCleanReport, error) {
+	report := MountCleanReport{
+		Cleaned: make([]MountRecord, 0),
+		Failed:  make([]MountCleanFailure, 0),
+		Kept:    make([]MountRecord, 0),
+	}
+
+	recs, err := reg.List()
+	if err != nil {
+		return report, err
+	}
+
+	fail := func(rec MountRecord, err error) {
+		keen.Log.Error("failed to clean the mount of [%s] by job [%s]: %v", rec.Application, rec.JobID, err)
+		report.Failed = append(report.Failed, MountCleanFailure{rec, err.Error()})
+	}
+
+	for _, rec := range recs {
+		app, ferr := pvd.FindApplication(rec.Application)
+		expired := expire > 0 && time.Since(rec.Time) > expire
+		if ferr == nil && !expired && !rec.orphaned() {
+			report.Kept = append(report.Kept, rec)
+			continue
+		}
+
+		if ferr != nil {
+			if !force {
+				fail(rec, ferr)
+				continue
+			}
+			keen.Log.Warn("force to remove the mount of [%s] by job [%s] whose application is not found: %v", rec.Application, rec.JobID, ferr)
+		} else {
+			keen.Log.Info("start to unmount the image of [%s] mounted by job [%s] at %s", rec.Application, rec.JobID, rec.Time.Format(util.COMMON_TIME_FMT))
+			loader, ok := pvd.(ImageLoader)
+			if !ok {
+				fail(rec, errors.New("the provider can not load the backup image from the meta data"))
+				continue
+			}
+			img, err := loader.LoadBackupImage(rec.Image)
+			if err != nil {
+				fail(rec, err)
+				continue
+			}
+			if err := app.UnMount(img); err != nil {
+				fail(rec, err)
+				continue
+			}
+		}
+
+		if err := reg.Remove(rec.Application, rec.Image); err != nil {
+			fail(rec, err)
+			continue
+		}
+		report.Cleaned = append(report.Cleaned, rec)
+	}
+
+	return report, nil
+}
+
+func listMounts(pvd Provider, env FCDMArgument, j *Job) (any, error) {
+	keen.Log.Info("start to list the mounts")
+	recs, err := Mounts.List()
+	if err != nil {
+		keen.Log.Error("failed to read the mount registry: %v", err)
+		return nil, err
+	}
+	return recs, nil
+}
+
+func cleanMounts(pvd Provider, env FCDMArgument, j *Job) (any, error) {
+	keen.Log.Info("start to clean the orphaned and expired mounts")
+	j.Enter(PHASE_UMOUNT)
+	report, err := CleanMounts(pvd, Mounts, mountExpire(), os.Getenv(ENV_MOUNT_FORCE) != "")
+	if err != nil {
+		keen.Log.Error("failed to clean the mounts: %v", err)
+		return nil, err
+	}
+	keen.Log.Info("clean the mounts completely: cleaned %d, failed %d, kept %d", len(report.Cleaned), len(report.Failed), len(report.Kept))
+
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("failed to clean %d mounts", len(report.Failed))
+	}
+	return report, nil
+}
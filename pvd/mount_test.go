@@ -0,0 +1,166 @@
+package pvd_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"gitea.fcdm.top/lixuan/keen/pvd/pvdtest"
+	"github.com/cnyjp/fcdmpublic/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func count(calls []string, call string) int {
+	n := 0
+	for _, c := range calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+func TestMountIdempotent(t *testing.T) {
+	pvdtest.TempState(t)
+	p := pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"))
+	app := p.Apps[0]
+
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_BACKUP, "orcl")).Code)
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_MOUNT, "orcl")).Code, "mount should succeed")
+	}
+	assert.Equal(t, 1, count(app.Calls(), "Mount"), "image should be mounted once")
+
+	recs, err := pvd.Mounts.List()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 1, "mount should be registered")
+	assert.Equal(t, "orcl", recs[0].Application)
+	assert.Equal(t, pvdtest.JOB_ID, recs[0].JobID)
+
+	var listed []pvd.MountRecord
+	res := pvdtest.Run(p, pvdtest.NewArgument(pvd.CMD_LIST_MOUNTS, ""))
+	assert.Equal(t, 0, res.Code, "list should succeed")
+	assert.NoError(t, res.Decode(&listed))
+	assert.Len(t, listed, 1, "mount should be listed")
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_UMOUNT, "orcl")).Code, "unmount should succeed")
+	}
+	assert.Equal(t, 2, count(app.Calls(), "UnMount"), "unmount should be attempted even without a record")
+
+	recs, err = pvd.Mounts.List()
+	assert.NoError(t, err)
+	assert.Empty(t, recs, "mount should be unregistered")
+}
+
+func TestUnmountWithoutRecord(t *testing.T) {
+	pvdtest.TempState(t)
+	p := pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"))
+	app := p.Apps[0]
+
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_BACKUP, "orcl")).Code)
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_MOUNT, "orcl")).Code)
+	img := p.Images()[0]
+	// 登记失败或者登记表之前的挂载没有记录
+	assert.NoError(t, pvd.Mounts.Remove("orcl", img.Meta()))
+
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_UMOUNT, "orcl")).Code, "unmount should succeed")
+	assert.Equal(t, 1, count(app.Calls(), "UnMount"), "image should be unmounted")
+	assert.False(t, app.Mounted(img), "image should not be mounted")
+}
+
+func TestMountStaleRecord(t *testing.T) {
+	pvdtest.TempState(t)
+	p := pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"))
+	app := p.Apps[0]
+
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_BACKUP, "orcl")).Code)
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_MOUNT, "orcl")).Code)
+	img := p.Images()[0]
+
+	// 在提供者之外卸载，登记表中留下过期的记录
+	assert.NoError(t, app.UnMount(img))
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_MOUNT, "orcl")).Code, "mount should succeed")
+	assert.Equal(t, 2, count(app.Calls(), "Mount"), "image should be mounted again")
+	assert.True(t, app.Mounted(img), "image should be mounted")
+
+	// 挂载目标已经不存在的记录同样是过期的
+	recs, err := pvd.Mounts.List()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 1)
+	rec := recs[0]
+	rec.Targets = map[string]string{"orcl_data": filepath.Join(t.TempDir(), "missing")}
+	assert.NoError(t, pvd.Mounts.Add(rec))
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_MOUNT, "orcl")).Code, "mount should succeed")
+	assert.Equal(t, 3, count(app.Calls(), "Mount"), "orphaned record should not skip mounting")
+
+	recs, err = pvd.Mounts.List()
+	assert.NoError(t, err)
+	assert.Len(t, recs, 1, "stale records should be replaced")
+	assert.NotContains(t, recs[0].Targets, "orcl_data")
+}
+
+func TestCleanMounts(t *testing.T) {
+	pvdtest.TempState(t)
+	p := pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"), pvdtest.NewApplication("mysql", "mysql", "mysql_data"))
+
+	// orcl的挂载目标已经不存在，mysql的挂载仍然有效
+	target := filepath.Join(t.TempDir(), "target")
+	assert.NoError(t, os.Mkdir(target, 0700))
+	for _, name := range []string{"orcl", "mysql"} {
+		assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_BACKUP, name)).Code)
+		arg := pvdtest.NewArgument(model.CMD_MOUNT, name)
+		arg.VolumeInformation = map[string]string{model.FCDM_EV_VOLUME_PREFIX + name: filepath.Join(target, name)}
+		assert.NoError(t, os.Mkdir(filepath.Join(target, name), 0700))
+		assert.Equal(t, 0, pvdtest.Run(p, arg).Code, "mount should succeed")
+	}
+	assert.NoError(t, os.Remove(filepath.Join(target, "orcl")))
+
+	var report pvd.MountCleanReport
+	res := pvdtest.Run(p, pvdtest.NewArgument(pvd.CMD_CLEAN_MOUNTS, ""))
+	assert.Equal(t, 0, res.Code, "clean should succeed")
+	assert.NoError(t, res.Decode(&report))
+	assert.Len(t, report.Cleaned, 1, "orphaned mount should be cleaned")
+	assert.Equal(t, "orcl", report.Cleaned[0].Application)
+	assert.Len(t, report.Kept, 1, "valid mount should be kept")
+	assert.Equal(t, 1, count(p.Apps[0].Calls(), "UnMount"), "orphaned mount should be unmounted")
+
+	// 过期的挂载也会被清理
+	report, err := pvd.CleanMounts(p, pvd.Mounts, time.Nanosecond, false)
+	assert.NoError(t, err)
+	assert.Len(t, report.Cleaned, 1, "expired mount should be cleaned")
+	assert.Equal(t, "mysql", report.Cleaned[0].Application)
+	assert.Equal(t, 1, count(p.Apps[1].Calls(), "UnMount"), "expired mount should be unmounted")
+
+	recs, err := pvd.Mounts.List()
+	assert.NoError(t, err)
+	assert.Empty(t, recs, "all mounts should be unregistered")
+}
+
+func TestCleanMountsMissingApplication(t *testing.T) {
+	pvdtest.TempState(t)
+	p := pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"))
+	rec := pvd.MountRecord{Image: "{}", Application: "gone", JobID: "j1", Time: time.Now()}
+	assert.NoError(t, pvd.Mounts.Add(rec))
+
+	report, err := pvd.CleanMounts(p, pvd.Mounts, 0, false)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Cleaned)
+	if assert.Len(t, report.Failed, 1, "the mount should fail when the application is not found") {
+		assert.Equal(t, "gone", report.Failed[0].Application)
+	}
+	_, ok, err := pvd.Mounts.Find("gone", "{}")
+	assert.NoError(t, err)
+	assert.True(t, ok, "the record should be kept")
+
+	// 强制删除记录
+	report, err = pvd.CleanMounts(p, pvd.Mounts, 0, true)
+	assert.NoError(t, err)
+	assert.Len(t, report.Cleaned, 1)
+	assert.Empty(t, report.Failed)
+	_, ok, err = pvd.Mounts.Find("gone", "{}")
+	assert.NoError(t, err)
+	assert.False(t, ok, "the record should be removed")
+}
@@ -0,0 +1,330 @@
+package pvd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen"
+	"gitea.fcdm.top/lixuan/keen/util"
+)
+
+const (
+	EVENT_JOB_STARTED   = "job_started"
+	EVENT_PHASE_CHANGED = "phase_changed"
+	EVENT_JOB_FINISHED  = "job_finished"
+)
+
+const (
+	ENV_NOTIFY_URL     = "KEEN_NOTIFY_URL"
+	ENV_NOTIFY_SPOOL   = "KEEN_NOTIFY_SPOOL"
+	ENV_NOTIFY_RETRIES = "KEEN_NOTIFY_RETRIES"
+	ENV_NOTIFY_COMMAND = "KEEN_NOTIFY_COMMAND"
+)
+
+// Notify 任务生命周期事件的默认通知器，根据环境变量配置接收端
+var Notify = NewNotifierFromEnv()
+
+// Event 任务生命周期事件
+type Event struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	JobID       string    `json:"job_id"`
+	Command     string    `json:"command"`
+	Application string    `json:"application,omitempty"`
+	Phase       string    `json:"phase,omitempty"`
+	Code        int       `json:"code"`
+	Error       string    `json:"error,omitempty"`
+	Result      any       `json:"result,omitempty"`
+}
+
+// Sink 事件的接收端
+type Sink interface {
+	Notify(Event) error
+}
+
+// Notifier 将事件依次发送到所有接收端，接收端的错误只记录日志，不影响任务执行
+type Notifier struct {
+	mu    sync.Mutex
+	sinks []Sink
+}
+
+func NewNotifier(sinks ...Sink) *Notifier {
+	return &Notifier{sinks: sinks}
+}
+
+// NewNotifierFromEnv 根据环境变量创建通知器：KEEN_NOTIFY_URL配置HTTP接收端，KEEN_NOTIFY_SPOOL为其积压文件，
+// KEEN_NOTIFY_RETRIES为其重试次数，KEEN_NOTIFY_COMMAND配置本地命令接收端
+func NewNotifierFromEnv() *Notifier {
+	n := NewNotifier()
+	if url := os.Getenv(ENV_NOTIFY_URL); url != "" {
+		s := NewHTTPSink(url, os.Getenv(ENV_NOTIFY_SPOOL))
+		if v := os.Getenv(ENV_NOTIFY_RETRIES); v != "" {
+			if r, err := strconv.Atoi(v); err == nil {
+				s.Retries = r
+			}
+		}
+		n.AddSink(s)
+	}
+	if cmd := os.Getenv(ENV_NOTIFY_COMMAND); cmd != "" {
+		n.AddSink(NewCommandSink(cmd))
+	}
+	return n
+}
+
+func (n *Notifier) AddSink(sinks ...Sink) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sinks = append(n.sinks, sinks...)
+}
+
+func (n *Notifier) Emit(e Event) {
+	n.mu.Lock()
+	sinks := append([]Sink(nil), n.sinks...)
+	n.mu.Unlock()
+
+	for _, s := range sinks {
+		if err := s.Notify(e); err != nil {
+			keen.Log.Warn("failed to send the event [%s] of job [%s]: %v", e.Type, e.JobID, err)
+		}
+	}
+}
+
+var errPermanent = errors.New("the endpoint rejects the event")
+
+// HTTPSink 以json格式POST事件，失败时按间隔重试，重试仍失败则追加到本地积压文件，下次发送前先投递积压的事件
+type HTTPSink struct {
+	URL      string
+	Spool    string
+	Retries  int
+	Interval time.Duration
+	Client   *http.Client
+
+	mu   sync.Mutex
+	down bool
+}
+
+// NewHTTPSink spool为空时积压文件位于临时目录
+func NewHTTPSink(url, spool string) *HTTPSink {
+	if spool == "" {
+		spool = filepath.Join(os.TempDir(), "keen_notify.spool")
+	}
+	return &HTTPSink{
+		URL:      url,
+		Spool:    spool,
+		Retries:  3,
+		Interval: time.Second,
+		Client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (s *HTTPSink) Notify(e Event) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 同一进程中端点已经不可用时直接积压，避免每个事件都等待重试
+	if s.down {
+		return s.spool(bs)
+	}
+
+	if err := s.drain(); err != nil {
+		s.down = true
+		if serr := s.spool(bs); serr != nil {
+			return serr
+		}
+		return err
+	}
+
+	err = s.post(bs)
+	if err != nil && !errors.Is(err, errPermanent) {
+		s.down = true
+		if serr := s.spool(bs); serr != nil {
+			return serr
+		}
+	}
+	return err
+}
+
+func (s *HTTPSink) post(bs []byte) error {
+	var err error
+	for i := 0; i <= s.Retries; i++ {
+		if i > 0 {
+			time.Sleep(s.Interval)
+		}
+
+		var resp *http.Response
+		resp, err = s.Client.Post(s.URL, "application/json", bytes.NewReader(bs))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+			return fmt.Errorf("%w: %s", errPermanent, resp.Status)
+		default:
+			err = fmt.Errorf("unexpected response status: %s", resp.Status)
+		}
+	}
+
+	return err
+}
+
+func (s *HTTPSink) spool(bs []byte) error {
+	unlock, err := util.LockFile(s.Spool + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.OpenFile(s.Spool, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(bs, '\n'))
+	return err
+}
+
+// readSpool 读取积压的事件，调用者需要持有锁文件
+func (s *HTTPSink) readSpool() ([][]byte, error) {
+	f, err := os.Open(s.Spool)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := make([][]byte, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// claim 在锁文件的保护下取走全部积压的事件，投递期间其他进程可以继续积压新的事件
+func (s *HTTPSink) claim() ([][]byte, error) {
+	unlock, err := util.LockFile(s.Spool + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	lines, err := s.readSpool()
+	if err != nil || len(lines) == 0 {
+		return nil, err
+	}
+	return lines, os.Remove(s.Spool)
+}
+
+// requeue 将未投递的事件放回积压文件，排在投递期间新积压的事件之前
+func (s *HTTPSink) requeue(lines [][]byte) error {
+	unlock, err := util.LockFile(s.Spool + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	newer, err := s.readSpool()
+	if err != nil {
+		return err
+	}
+	lines = append(lines, newer...)
+	return util.WriteFileAtomic(s.Spool, append(bytes.Join(lines, []byte{'\n'}), '\n'), 0600)
+}
+
+// drain 按顺序投递积压的事件，遇到失败时保留剩余的事件，被端点拒绝的事件直接丢弃
+func (s *HTTPSink) drain() error {
+	lines, err := s.claim()
+	if err != nil {
+		return err
+	}
+
+	var perr error
+	sent := 0
+	for _, l := range lines {
+		if err := s.post(l); err != nil {
+			if !errors.Is(err, errPermanent) {
+				perr = err
+				break
+			}
+			keen.Log.Warn("drop the spooled event: %v", err)
+		}
+		sent++
+	}
+
+	if sent == len(lines) {
+		return nil
+	}
+	if err := s.requeue(lines[sent:]); err != nil {
+		return err
+	}
+	return perr
+}
+
+// CommandSink 对每个事件执行本地命令，事件以json格式写入标准输入，主要字段同时设置为KEEN_EVENT_*环境变量
+type CommandSink struct {
+	Path    string
+	Args    []string
+	Timeout time.Duration
+}
+
+func NewCommandSink(path string, args ...string) *CommandSink {
+	return &CommandSink{
+		Path:    path,
+		Args:    args,
+		Timeout: 30 * time.Second,
+	}
+}
+
+func (s *CommandSink) Notify(e Event) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, s.Path, s.Args...)
+	cmd.Stdin = bytes.NewReader(bs)
+	cmd.Env = append(os.Environ(),
+		"KEEN_EVENT_TYPE="+e.Type,
+		"KEEN_EVENT_JOB_ID="+e.JobID,
+		"KEEN_EVENT_COMMAND="+e.Command,
+		"KEEN_EVENT_APPLICATION="+e.Application,
+		"KEEN_EVENT_PHASE="+e.Phase,
+		"KEEN_EVENT_CODE="+strconv.Itoa(e.Code),
+		"KEEN_EVENT_ERROR="+e.Error,
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("hook [%s] failed: %v, output: %s", s.Path, err, string(out))
+	}
+	keen.Log.Trace("hook [%s] output: %s", s.Path, string(out))
+	return nil
+}
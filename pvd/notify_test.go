@@ -0,0 +1,221 @@
+package pvd_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"gitea.fcdm.top/lixuan/keen/pvd/pvdtest"
+	"github.com/cnyjp/fcdmpublic/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type eventServer struct {
+	mu     sync.Mutex
+	down   bool
+	status []int
+	events []pvd.Event
+}
+
+func (s *eventServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if len(s.status) > 0 {
+		st := s.status[0]
+		s.status = s.status[1:]
+		w.WriteHeader(st)
+		return
+	}
+
+	var e pvd.Event
+	json.NewDecoder(r.Body).Decode(&e)
+	s.events = append(s.events, e)
+}
+
+func (s *eventServer) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *eventServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]string, 0, len(s.events))
+	for _, e := range s.events {
+		res = append(res, e.JobID+":"+e.Type+":"+e.Phase)
+	}
+	return res
+}
+
+func newHTTPSink(url, spool string) *pvd.HTTPSink {
+	s := pvd.NewHTTPSink(url, spool)
+	s.Retries = 2
+	s.Interval = 10 * time.Millisecond
+	return s
+}
+
+func TestHTTPSinkRetry(t *testing.T) {
+	es := &eventServer{status: []int{http.StatusInternalServerError, http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(es)
+	defer srv.Close()
+
+	sink := newHTTPSink(srv.URL, filepath.Join(t.TempDir(), "notify.spool"))
+	err := sink.Notify(pvd.Event{Type: pvd.EVENT_JOB_STARTED, JobID: "job1"})
+	assert.NoError(t, err, "event should be delivered after retries")
+	assert.Equal(t, []string{"job1:" + pvd.EVENT_JOB_STARTED + ":"}, es.received())
+	assert.NoFileExists(t, sink.Spool, "nothing should be spooled")
+}
+
+func TestHTTPSinkRejected(t *testing.T) {
+	es := &eventServer{status: []int{http.StatusBadRequest}}
+	srv := httptest.NewServer(es)
+	defer srv.Close()
+
+	sink := newHTTPSink(srv.URL, filepath.Join(t.TempDir(), "notify.spool"))
+	assert.Error(t, sink.Notify(pvd.Event{Type: pvd.EVENT_JOB_STARTED, JobID: "job1"}), "event should be rejected")
+	assert.NoFileExists(t, sink.Spool, "rejected event should not be spooled")
+}
+
+func TestHTTPSinkSpool(t *testing.T) {
+	es := &eventServer{down: true}
+	srv := httptest.NewServer(es)
+	defer srv.Close()
+	spool := filepath.Join(t.TempDir(), "notify.spool")
+
+	sink := newHTTPSink(srv.URL, spool)
+	assert.Error(t, sink.Notify(pvd.Event{Type: pvd.EVENT_JOB_STARTED, JobID: "job1"}), "endpoint is down")
+	assert.NoError(t, sink.Notify(pvd.Event{Type: pvd.EVENT_JOB_FINISHED, JobID: "job1"}), "event should be spooled directly")
+
+	bs, err := os.ReadFile(spool)
+	assert.NoError(t, err, "events should be spooled")
+	assert.Equal(t, 2, strings.Count(string(bs), "\n"), "two events should be spooled")
+
+	// 下一个进程中端点已经恢复，积压的事件先于新事件投递
+	es.setDown(false)
+	sink = newHTTPSink(srv.URL, spool)
+	assert.NoError(t, sink.Notify(pvd.Event{Type: pvd.EVENT_JOB_STARTED, JobID: "job2"}))
+	assert.Equal(t, []string{
+		"job1:" + pvd.EVENT_JOB_STARTED + ":",
+		"job1:" + pvd.EVENT_JOB_FINISHED + ":",
+		"job2:" + pvd.EVENT_JOB_STARTED + ":",
+	}, es.received())
+	assert.NoFileExists(t, spool, "spool should be drained")
+}
+
+func TestHTTPSinkSpoolDuringDrain(t *testing.T) {
+	es := &eventServer{}
+	started, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+		es.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	spool := filepath.Join(t.TempDir(), "notify.spool")
+
+	down := newHTTPSink("http://127.0.0.1:1", spool)
+	down.Retries = 0
+	assert.Error(t, down.Notify(pvd.Event{Type: pvd.EVENT_JOB_STARTED, JobID: "job1"}))
+
+	done := make(chan error)
+	go func() {
+		done <- newHTTPSink(srv.URL, spool).Notify(pvd.Event{Type: pvd.EVENT_JOB_STARTED, JobID: "job2"})
+	}()
+
+	// 另一个进程在投递积压事件期间积压的新事件不能丢失
+	<-started
+	assert.NoError(t, down.Notify(pvd.Event{Type: pvd.EVENT_JOB_FINISHED, JobID: "job1"}))
+	close(release)
+	assert.NoError(t, <-done)
+
+	bs, err := os.ReadFile(spool)
+	assert.NoError(t, err, "the event spooled during draining should be kept")
+	assert.Contains(t, string(bs), `"job_id":"job1"`)
+	assert.Equal(t, 1, strings.Count(string(bs), "\n"))
+	assert.Equal(t, []string{
+		"job1:" + pvd.EVENT_JOB_STARTED + ":",
+		"job2:" + pvd.EVENT_JOB_STARTED + ":",
+	}, es.received())
+}
+
+func TestCommandSink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook script requires sh")
+	}
+
+	out := filepath.Join(t.TempDir(), "hook.out")
+	sink := pvd.NewCommandSink("sh", "-c", `printf "%s " "$KEEN_EVENT_TYPE" "$KEEN_EVENT_CODE" > "$0"; cat >> "$0"`, out)
+	err := sink.Notify(pvd.Event{Type: pvd.EVENT_JOB_FINISHED, JobID: "job1", Code: pvd.C_ERR_EXIT})
+	assert.NoError(t, err, "hook should succeed")
+
+	bs, err := os.ReadFile(out)
+	assert.NoError(t, err, "hook should write its output")
+	segs := strings.SplitN(string(bs), " ", 3)
+	assert.Equal(t, pvd.EVENT_JOB_FINISHED, segs[0])
+	assert.Equal(t, "1", segs[1])
+
+	var e pvd.Event
+	assert.NoError(t, json.Unmarshal([]byte(segs[2]), &e), "event should be written to stdin")
+	assert.Equal(t, "job1", e.JobID)
+
+	sink = pvd.NewCommandSink("sh", "-c", "exit 3")
+	assert.Error(t, sink.Notify(pvd.Event{Type: pvd.EVENT_JOB_STARTED}), "failed hook should return error")
+}
+
+func TestJobEvents(t *testing.T) {
+	pvdtest.TempState(t)
+	es := &eventServer{}
+	srv := httptest.NewServer(es)
+	defer srv.Close()
+
+	old := pvd.Notify
+	pvd.Notify = pvd.NewNotifier(newHTTPSink(srv.URL, filepath.Join(t.TempDir(), "notify.spool")))
+	defer func() { pvd.Notify = old }()
+
+	p := pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"))
+	res := pvdtest.Run(p, pvdtest.NewArgument(model.CMD_BACKUP, "orcl"))
+	assert.Equal(t, 0, res.Code, "backup should succeed")
+	assert.Equal(t, []string{
+		pvdtest.JOB_ID + ":" + pvd.EVENT_JOB_STARTED + ":",
+		pvdtest.JOB_ID + ":" + pvd.EVENT_PHASE_CHANGED + ":" + pvd.PHASE_VALIDATE,
+		pvdtest.JOB_ID + ":" + pvd.EVENT_PHASE_CHANGED + ":" + pvd.PHASE_FIND,
+		pvdtest.JOB_ID + ":" + pvd.EVENT_PHASE_CHANGED + ":" + pvd.PHASE_BACKUP,
+		pvdtest.JOB_ID + ":" + pvd.EVENT_PHASE_CHANGED + ":" + pvd.PHASE_OUTPUT,
+		pvdtest.JOB_ID + ":" + pvd.EVENT_JOB_FINISHED + ":" + pvd.PHASE_OUTPUT,
+	}, es.received())
+
+	es.mu.Lock()
+	fin := es.events[len(es.events)-1]
+	es.events = nil
+	es.mu.Unlock()
+	assert.Equal(t, 0, fin.Code)
+	assert.NotNil(t, fin.Result, "finished event should carry the result")
+
+	res = pvdtest.Run(p, pvdtest.NewArgument(model.CMD_APPLICATION_INFO, "missing"))
+	assert.Equal(t, pvd.C_ERR_EXIT, res.Code, "refresh should fail")
+
+	es.mu.Lock()
+	fin = es.events[len(es.events)-1]
+	es.mu.Unlock()
+	assert.Equal(t, pvd.EVENT_JOB_FINISHED, fin.Type)
+	assert.Equal(t, pvd.PHASE_FIND, fin.Phase, "failed phase should be reported")
+	assert.Equal(t, pvd.C_ERR_EXIT, fin.Code)
+	assert.Contains(t, fin.Error, "missing")
+}
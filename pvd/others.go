@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -20,7 +21,8 @@ const (
 	C_ERR_EXIT = 1
 )
 
-var LogNameReg = regexp.MustCompile(`(backup|restore|mount|umount|discover|refresh|illegal|pluginfo)_(.+)_(\d{14})\.log$`)
+// LogNameReg 日志文件名称的正则表达式，由已注册命令的日志名称生成
+var LogNameReg *regexp.Regexp
 
 var SimpleArch ylog.Archive = func(fn string) (bool, string) {
 	matches := LogNameReg.FindAllStringSubmatch(fn, -1)
@@ -39,6 +41,12 @@ func GenLogName(cmd, jobId string) string {
 	return fmt.Sprintf("%s_%s_%s.log", cmd, jobId, util.LocalFormat(util.SERIAL_FMT, time.Now()))
 }
 
+// ProviderName 提供者的名称，即当前可执行文件去掉扩展名之后的名称
+func ProviderName() string {
+	exe := filepath.Base(os.Args[0])
+	return strings.TrimSuffix(exe, filepath.Ext(exe))
+}
+
 // PluginInfoJson 将plugininfo的Config结构转换为json字符串，如果转换失败则返回空字符串结果
 func PluginInfoJson(conf model.PluginConfig) string {
 	bs, err := json.MarshalIndent(conf, "", "  ")
@@ -53,7 +61,7 @@ func PluginInfoJson(conf model.PluginConfig) string {
 // LegalCaller 判断provider的调用者是否合法，根据进程树上是否存在fcdmconnector进程来判断
 func LegalCaller() bool {
 	pid := strconv.Itoa(os.Getpid())
-	keen.Log.Debug("the ID of current process", pid)
+	keen.Log.Debug("the ID of current process: %s", pid)
 	for {
 		proc, err := util.QueryProcess(pid)
 		if err != nil {
@@ -0,0 +1,367 @@
+package pvd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen"
+	"gitea.fcdm.top/lixuan/keen/util"
+)
+
+const (
+	ENV_RESTORE_PROTECT           = "KEEN_RESTORE_PROTECT"
+	ENV_RESTORE_PROTECT_RETENTION = "KEEN_RESTORE_PROTECT_RETENTION"
+)
+
+const (
+	PROTECT_OFF  = ""
+	PROTECT_COPY = "copy"
+	PROTECT_MOVE = "move"
+)
+
+const (
+	PROTECTION_PROTECTED       = "protected"
+	PROTECTION_RETAINED        = "retained"
+	PROTECTION_REMOVED         = "removed"
+	PROTECTION_ROLLED_BACK     = "rolled_back"
+	PROTECTION_ROLLBACK_FAILED = "rollback_failed"
+)
+
+const (
+	protectCopyGroups = 4
+	protectCopyBuffer = int(util.MB)
+	protectBatch      = 256
+)
+
+// Protections 默认的恢复保护配置，根据环境变量选择保护方式，默认不保护
+var Protections = NewProtectorFromEnv()
+
+// ProtectedApplication 实现了此接口的应用在恢复之前会保护DataPaths返回的路径，恢复失败时自动回滚
+type ProtectedApplication interface {
+	DataPaths() ([]string, error) // 恢复时会被覆盖的文件或目录
+}
+
+// ProtectedPath 被保护的路径及其快照，路径在恢复前不存在时快照为空
+type ProtectedPath struct {
+	Path     string `json:"path"`
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// ProtectionReport 恢复保护的结果，作为Outcome的一部分输出
+type ProtectionReport struct {
+	Mode   string          `json:"mode"`
+	State  string          `json:"state"`
+	Paths  []ProtectedPath `json:"paths"`
+	Expire *time.Time      `json:"expire,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Protector 恢复前保护应用数据的方式。copy模式将数据复制到同目录下的快照中，move模式将数据重命名为快照；
+// 恢复成功后快照保留Retention时长，保留记录位于Dir目录下，过期的快照在之后执行任意命令时清理
+type Protector struct {
+	Mode      string
+	Retention time.Duration
+	Dir       string
+}
+
+type protectConfig struct {
+	Mode      string        `env:"KEEN_RESTORE_PROTECT"`
+	Retention time.Duration `env:"KEEN_RESTORE_PROTECT_RETENTION" default:"24h"`
+}
+
+// NewProtectorFromEnv KEEN_RESTORE_PROTECT为copy或move时开启保护，KEEN_RESTORE_PROTECT_RETENTION为快照的保留时长，默认24h
+func NewProtectorFromEnv() *Protector {
+	var conf protectConfig
+	if err := util.BindEnv(&conf, os.Environ()); err != nil {
+		keen.Log.Warn("failed to parse the restore protection configuration: %v", err)
+	}
+	if conf.Mode != PROTECT_OFF && conf.Mode != PROTECT_COPY && conf.Mode != PROTECT_MOVE {
+		keen.Log.Warn("unknown restore protection mode [%s], protection is disabled", conf.Mode)
+		conf.Mode = PROTECT_OFF
+	}
+	return &Protector{
+		Mode:      conf.Mode,
+		Retention: conf.Retention,
+		Dir:       filepath.Join(StateDir(), "protect"),
+	}
+}
+
+func (p *Protector) Enabled() bool {
+	return p.Mode == PROTECT_COPY || p.Mode == PROTECT_MOVE
+}
+
+// Protection 一次恢复的保护状态
+type Protection struct {
+	JobID  string           `json:"job_id"`
+	Time   time.Time        `json:"time"`
+	Report ProtectionReport `json:"report"`
+
+	p *Protector
+}
+
+// protectID 由任务ID和保护时间组成的标识，用于快照和保留记录的名称
+func protectID(jobID string, t time.Time) string {
+	id := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, jobID)
+	return id + "-" + util.LocalFormat(util.SERIAL_FMT, t)
+}
+
+// snapshotName 快照与原路径位于同一目录，保证move模式和回滚时可以直接重命名
+func snapshotName(path, jobID string, t time.Time) string {
+	return path + ".keen-protect-" + protectID(jobID, t)
+}
+
+// Protect 在恢复之前保护应用的数据路径，未开启保护或应用不支持时返回nil
+func (p *Protector) Protect(app BackupApplication, jobID string) (*Protection, error) {
+	papp, ok := app.(ProtectedApplication)
+	if !p.Enabled() || !ok {
+		return nil, nil
+	}
+
+	paths, err := papp.DataPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	prot := &Protection{
+		JobID:  jobID,
+		Time:   time.Now(),
+		Report: ProtectionReport{Mode: p.Mode, Paths: make([]ProtectedPath, 0, len(paths))},
+		p:      p,
+	}
+	for _, path := range paths {
+		pp := ProtectedPath{Path: filepath.Clean(path)}
+		if _, err := os.Lstat(pp.Path); err != nil {
+			if !os.IsNotExist(err) {
+				prot.discard()
+				return nil, err
+			}
+			prot.Report.Paths = append(prot.Report.Paths, pp)
+			continue
+		}
+
+		pp.Snapshot = snapshotName(pp.Path, jobID, prot.Time)
+		keen.Log.Info("protect [%s] by %s to [%s]", pp.Path, p.Mode, pp.Snapshot)
+		if p.Mode == PROTECT_MOVE {
+			err = os.Rename(pp.Path, pp.Snapshot)
+		} else {
+			err = copyTree(pp.Path, pp.Snapshot)
+		}
+		if err != nil {
+			os.RemoveAll(pp.Snapshot)
+			prot.discard()
+			return nil, fmt.Errorf("failed to protect [%s]: %w", pp.Path, err)
+		}
+		prot.Report.Paths = append(prot.Report.Paths, pp)
+	}
+
+	prot.Report.State = PROTECTION_PROTECTED
+	return prot, nil
+}
+
+// discard 保护失败时撤销已经完成的保护，move模式需要将快照移回原路径
+func (prot *Protection) discard() {
+	for _, pp := range prot.Report.Paths {
+		if pp.Snapshot == "" {
+			continue
+		}
+		var err error
+		if prot.p.Mode == PROTECT_MOVE {
+			err = os.Rename(pp.Snapshot, pp.Path)
+		} else {
+			err = os.RemoveAll(pp.Snapshot)
+		}
+		if err != nil {
+			keen.Log.Error("failed to discard the protection of [%s]: %v", pp.Path, err)
+		}
+	}
+}
+
+// Rollback 恢复失败后删除被部分覆盖的数据，并将快照移回原路径
+func (prot *Protection) Rollback() error {
+	eg := util.NewErrGroup()
+	for i := len(prot.Report.Paths) - 1; i >= 0; i-- {
+		pp := prot.Report.Paths[i]
+		if err := os.RemoveAll(pp.Path); err != nil {
+			eg.AddErrs(err)
+			continue
+		}
+		if pp.Snapshot == "" {
+			continue
+		}
+		if err := os.Rename(pp.Snapshot, pp.Path); err != nil {
+			eg.AddErrs(err)
+		}
+	}
+
+	if err := eg.Err(); err != nil {
+		prot.Report.State = PROTECTION_ROLLBACK_FAILED
+		prot.Report.Error = err.Error()
+		return err
+	}
+	prot.Report.State = PROTECTION_ROLLED_BACK
+	return nil
+}
+
+// Release 恢复成功后保留快照直到过期，保留时长不大于0时立即删除快照
+func (prot *Protection) Release() error {
+	if prot.p.Retention <= 0 {
+		prot.Report.State = PROTECTION_REMOVED
+		return prot.remove()
+	}
+
+	expire := prot.Time.Add(prot.p.Retention)
+	prot.Report.Expire = &expire
+	prot.Report.State = PROTECTION_RETAINED
+
+	if err := os.MkdirAll(prot.p.Dir, os.ModeDir|0700); err != nil {
+		return err
+	}
+	bs, err := json.Marshal(prot)
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(filepath.Join(prot.p.Dir, protectID(prot.JobID, prot.Time)+".json"), bs, 0600)
+}
+
+func (prot *Protection) remove() error {
+	eg := util.NewErrGroup()
+	for _, pp := range prot.Report.Paths {
+		if pp.Snapshot == "" {
+			continue
+		}
+		if err := os.RemoveAll(pp.Snapshot); err != nil {
+			eg.AddErrs(err)
+		}
+	}
+	return eg.Err()
+}
+
+// Purge 删除过期的快照及其保留记录
+func (p *Protector) Purge() error {
+	matches, err := filepath.Glob(filepath.Join(p.Dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	eg := util.NewErrGroup()
+	now := time.Now()
+	for _, m := range matches {
+		bs, err := os.ReadFile(m)
+		if err != nil {
+			eg.AddErrs(err)
+			continue
+		}
+		var prot Protection
+		if err := json.Unmarshal(bs, &prot); err != nil {
+			eg.AddErrs(fmt.Errorf("%s: %w", m, err))
+			continue
+		}
+		if prot.Report.Expire == nil || now.Before(*prot.Report.Expire) {
+			continue
+		}
+
+		keen.Log.Info("purge the expired restore protection of job [%s]", prot.JobID)
+		if err := prot.remove(); err != nil {
+			eg.AddErrs(err)
+			continue
+		}
+		if err := os.Remove(m); err != nil {
+			eg.AddErrs(err)
+		}
+	}
+	return eg.Err()
+}
+
+// copyTree 复制文件或目录，保留权限、所有者、修改时间和符号链接，普通文件通过util的拷贝任务并发复制。
+// 快照回滚时会直接替换原路径，所以属性必须与原数据一致
+func copyTree(src, dst string) error {
+	type entry struct {
+		path string
+		info fs.FileInfo
+	}
+	dirs := make([]entry, 0)
+	files := make([]entry, 0)
+	tasks := make([]*util.CopyTask, 0)
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			dirs = append(dirs, entry{target, info})
+			return os.MkdirAll(target, os.ModeDir|0700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			return util.CopyOwner(target, info)
+		case d.Type().IsRegular():
+			files = append(files, entry{target, info})
+			tasks = append(tasks, util.NewCopyTask(path, target))
+			return nil
+		default:
+			keen.Log.Warn("skip the special file [%s] while protecting", path)
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(tasks); i += protectBatch {
+		end := i + protectBatch
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+		if err := util.ExecCopyTasks(tasks[i:end], protectCopyGroups, protectCopyBuffer); err != nil {
+			return err
+		}
+	}
+
+	// 目录的修改时间在其中的文件全部创建之后设置，从最深的目录开始
+	restore := func(e entry) error {
+		if err := util.CopyOwner(e.path, e.info); err != nil {
+			return err
+		}
+		if err := os.Chmod(e.path, e.info.Mode().Perm()); err != nil {
+			return err
+		}
+		return os.Chtimes(e.path, e.info.ModTime(), e.info.ModTime())
+	}
+	for _, f := range files {
+		if err := restore(f); err != nil {
+			return err
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := restore(dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
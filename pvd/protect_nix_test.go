@@ -0,0 +1,41 @@
+//go:build linux || darwin || aix
+// +build linux darwin aix
+
+package pvd_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"gitea.fcdm.top/lixuan/keen/pvd/pvdtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreRollbackKeepsOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of files requires root")
+	}
+	pvdtest.TempState(t)
+	pvd.Protections.Mode = pvd.PROTECT_COPY
+	p, app, data, _ := protectedApp(t)
+	paths := []string{data, filepath.Join(data, "datafile"), filepath.Join(data, "sub"), filepath.Join(data, "link")}
+	for _, path := range paths {
+		assert.NoError(t, os.Lchown(path, 1234, 2345))
+	}
+	app.OnRestore = func(pvd.BackupImage) error {
+		return errors.New("restore interrupted")
+	}
+
+	code, _ := restoreOutcome(t, p)
+	assert.Equal(t, pvd.C_ERR_EXIT, code)
+	for _, path := range paths {
+		fi, err := os.Lstat(path)
+		assert.NoError(t, err)
+		st := fi.Sys().(*syscall.Stat_t)
+		assert.Equal(t, []uint32{1234, 2345}, []uint32{st.Uid, st.Gid}, "owner of [%s] should be kept", path)
+	}
+}
@@ -0,0 +1,206 @@
+package pvd_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"gitea.fcdm.top/lixuan/keen/pvd/pvdtest"
+	"github.com/cnyjp/fcdmpublic/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// protectedApp 创建数据目录包含一个文件和一个子目录的应用，以及恢复前不存在的路径
+func protectedApp(t *testing.T) (*pvdtest.Provider, *pvdtest.Application, string, string) {
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	assert.NoError(t, os.MkdirAll(filepath.Join(data, "sub"), 0750))
+	assert.NoError(t, os.WriteFile(filepath.Join(data, "datafile"), []byte("original"), 0640))
+	assert.NoError(t, os.WriteFile(filepath.Join(data, "sub", "ctl"), []byte("control"), 0600))
+	assert.NoError(t, os.Symlink("datafile", filepath.Join(data, "link")))
+	fresh := filepath.Join(dir, "fresh")
+
+	app := pvdtest.NewApplication("orcl", "oracle", "orcl_data")
+	app.Paths = []string{data, fresh}
+	p := pvdtest.NewProvider(app)
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_BACKUP, "orcl")).Code)
+	return p, app, data, fresh
+}
+
+func snapshots(t *testing.T, path string) []string {
+	matches, err := filepath.Glob(path + ".keen-protect-*")
+	assert.NoError(t, err)
+	return matches
+}
+
+func restoreOutcome(t *testing.T, p pvd.Provider) (int, pvd.Outcome) {
+	var o pvd.Outcome
+	res := pvdtest.Run(p, pvdtest.NewArgument(model.CMD_RESTORE, "orcl"))
+	assert.NoError(t, res.Decode(&o))
+	return res.Code, o
+}
+
+func TestRestoreRollback(t *testing.T) {
+	for _, mode := range []string{pvd.PROTECT_COPY, pvd.PROTECT_MOVE} {
+		t.Run(mode, func(t *testing.T) {
+			pvdtest.TempState(t)
+			pvd.Protections.Mode = mode
+			p, app, data, fresh := protectedApp(t)
+			app.OnRestore = func(pvd.BackupImage) error {
+				os.MkdirAll(data, 0750)
+				os.WriteFile(filepath.Join(data, "datafile"), []byte("half"), 0640)
+				os.MkdirAll(fresh, 0750)
+				return errors.New("restore interrupted")
+			}
+
+			code, o := restoreOutcome(t, p)
+			assert.Equal(t, pvd.C_ERR_EXIT, code)
+			assert.Equal(t, "restore interrupted", o.Error)
+			if assert.NotNil(t, o.Protection) {
+				assert.Equal(t, mode, o.Protection.Mode)
+				assert.Equal(t, pvd.PROTECTION_ROLLED_BACK, o.Protection.State)
+				assert.Len(t, o.Protection.Paths, 2)
+				assert.Empty(t, o.Protection.Paths[1].Snapshot, "missing path has no snapshot")
+			}
+
+			bs, err := os.ReadFile(filepath.Join(data, "datafile"))
+			assert.NoError(t, err)
+			assert.Equal(t, "original", string(bs))
+			bs, err = os.ReadFile(filepath.Join(data, "sub", "ctl"))
+			assert.NoError(t, err)
+			assert.Equal(t, "control", string(bs))
+			link, err := os.Readlink(filepath.Join(data, "link"))
+			assert.NoError(t, err)
+			assert.Equal(t, "datafile", link)
+			fi, err := os.Stat(filepath.Join(data, "sub"))
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(0750), fi.Mode().Perm())
+			assert.NoDirExists(t, fresh, "path created by the restore should be removed")
+			assert.Empty(t, snapshots(t, data))
+		})
+	}
+}
+
+func TestRestoreRetention(t *testing.T) {
+	pvdtest.TempState(t)
+	pvd.Protections.Mode = pvd.PROTECT_COPY
+	pvd.Protections.Retention = time.Hour
+	p, app, data, _ := protectedApp(t)
+
+	code, o := restoreOutcome(t, p)
+	assert.Equal(t, 0, code)
+	assert.Len(t, app.Restored(), 1)
+	if assert.NotNil(t, o.Protection) {
+		assert.Equal(t, pvd.PROTECTION_RETAINED, o.Protection.State)
+		assert.NotNil(t, o.Protection.Expire)
+	}
+	snaps := snapshots(t, data)
+	assert.Len(t, snaps, 1)
+	assert.FileExists(t, filepath.Join(snaps[0], "sub", "ctl"))
+
+	assert.NoError(t, pvd.Protections.Purge())
+	assert.Len(t, snapshots(t, data), 1, "unexpired snapshot should be kept")
+
+	records, _ := filepath.Glob(filepath.Join(pvd.Protections.Dir, "*.json"))
+	assert.Len(t, records, 1)
+	var prot pvd.Protection
+	bs, _ := os.ReadFile(records[0])
+	assert.NoError(t, json.Unmarshal(bs, &prot))
+	expired := time.Now().Add(-time.Minute)
+	prot.Report.Expire = &expired
+	bs, _ = json.Marshal(prot)
+	assert.NoError(t, os.WriteFile(records[0], bs, 0600))
+
+	assert.NoError(t, pvd.Protections.Purge())
+	assert.Empty(t, snapshots(t, data), "expired snapshot should be purged")
+	records, _ = filepath.Glob(filepath.Join(pvd.Protections.Dir, "*.json"))
+	assert.Empty(t, records)
+}
+
+func TestRestoreWithoutRetention(t *testing.T) {
+	pvdtest.TempState(t)
+	pvd.Protections.Mode = pvd.PROTECT_MOVE
+	pvd.Protections.Retention = 0
+	p, _, data, _ := protectedApp(t)
+
+	code, o := restoreOutcome(t, p)
+	assert.Equal(t, 0, code)
+	if assert.NotNil(t, o.Protection) {
+		assert.Equal(t, pvd.PROTECTION_REMOVED, o.Protection.State)
+	}
+	assert.Empty(t, snapshots(t, data))
+}
+
+func TestRestoreWithoutProtection(t *testing.T) {
+	pvdtest.TempState(t)
+	pvd.Protections.Mode = pvd.PROTECT_OFF
+	p, app, _, _ := protectedApp(t)
+
+	code, o := restoreOutcome(t, p)
+	assert.Equal(t, 0, code)
+	assert.Nil(t, o.Protection)
+	assert.Equal(t, 0, count(app.Calls(), "DataPaths"))
+}
+
+func TestRestoreRollbackAfterPanic(t *testing.T) {
+	pvdtest.TempState(t)
+	pvd.Protections.Mode = pvd.PROTECT_MOVE
+	p, app, data, _ := protectedApp(t)
+	app.OnRestore = func(pvd.BackupImage) error {
+		os.MkdirAll(data, 0750)
+		os.WriteFile(filepath.Join(data, "datafile"), []byte("half"), 0640)
+		panic("restore crashed")
+	}
+
+	code, o := restoreOutcome(t, p)
+	assert.Equal(t, pvd.C_ERR_EXIT, code)
+	if assert.NotNil(t, o.Protection) {
+		assert.Equal(t, pvd.PROTECTION_ROLLED_BACK, o.Protection.State)
+	}
+	bs, err := os.ReadFile(filepath.Join(data, "datafile"))
+	assert.NoError(t, err)
+	assert.Equal(t, "original", string(bs), "data should be rolled back after a panic")
+	assert.Empty(t, snapshots(t, data))
+}
+
+func TestRestoreRollbackKeepsModTime(t *testing.T) {
+	pvdtest.TempState(t)
+	pvd.Protections.Mode = pvd.PROTECT_COPY
+	p, app, data, _ := protectedApp(t)
+	old := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	for _, path := range []string{filepath.Join(data, "datafile"), filepath.Join(data, "sub"), data} {
+		assert.NoError(t, os.Chtimes(path, old, old))
+	}
+	app.OnRestore = func(pvd.BackupImage) error {
+		return errors.New("restore interrupted")
+	}
+
+	code, _ := restoreOutcome(t, p)
+	assert.Equal(t, pvd.C_ERR_EXIT, code)
+	for _, path := range []string{filepath.Join(data, "datafile"), filepath.Join(data, "sub"), data} {
+		fi, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.True(t, old.Equal(fi.ModTime()), "modification time of [%s] should be kept: %v", path, fi.ModTime())
+	}
+}
+
+func TestPurgeWithoutProtectedRestore(t *testing.T) {
+	pvdtest.TempState(t)
+	pvd.Protections.Mode = pvd.PROTECT_COPY
+	pvd.Protections.Retention = time.Nanosecond
+	p, _, data, _ := protectedApp(t)
+
+	code, _ := restoreOutcome(t, p)
+	assert.Equal(t, 0, code)
+	assert.Len(t, snapshots(t, data), 1)
+
+	// 之后不再有开启保护的恢复，其他命令也会清理过期的快照
+	pvd.Protections.Mode = pvd.PROTECT_OFF
+	time.Sleep(time.Millisecond)
+	assert.Equal(t, 0, pvdtest.Run(p, pvdtest.NewArgument(model.CMD_DISCOVER, "")).Code)
+	assert.Empty(t, snapshots(t, data), "expired snapshot should be purged")
+}
@@ -160,3 +160,25 @@ func TestLogName(t *testing.T) {
 	assert.True(t, r1, "failed to match name of log file")
 	assert.Equal(t, "backup", r2, "failed to find the pattern of submatch")
 }
+
+func TestParseFCDMArgument(t *testing.T) {
+	arg, err := pvd.ParseFCDMArgument([]string{
+		model.FCDM_EV_COMMAND + "=" + model.CMD_BACKUP,
+		model.FCDM_EV_MAINJOB_ID + "=42",
+		model.FCDM_EV_AD_PREFIX + "password=cGFzcw==",
+		model.FCDM_EV_IMAGE_AD_PREFIX + "dsn=user=keen host=db",
+		model.FCDM_EV_VOLUME_PREFIX + "data=/mnt/data",
+		model.FCDM_EV_VOLUME_IDENTITY_PREFIX + "data=vol-1",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, model.CMD_BACKUP, arg.Command)
+	assert.Equal(t, "42", arg.JobID)
+
+	v, _ := arg.GetConfig("password", false, nil)
+	assert.Equal(t, "cGFzcw==", v)
+	v, _ = arg.GetImgConfig("dsn", false, nil)
+	assert.Equal(t, "user=keen host=db", v)
+	assert.Equal(t, "/mnt/data", arg.GetVolume("data"))
+	assert.Equal(t, map[string]string{model.FCDM_EV_VOLUME_IDENTITY_PREFIX + "data": "vol-1"}, arg.VolsIdentityInformation)
+	assert.Len(t, arg.VolumeInformation, 1)
+}
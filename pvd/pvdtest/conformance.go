@@ -0,0 +1,234 @@
+package pvdtest
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"github.com/cnyjp/fcdmpublic/model"
+)
+
+// Conformance 一致性测试集的配置
+type Conformance struct {
+	// NewProvider 每个子测试都会创建新的Provider
+	NewProvider func() pvd.Provider
+	// Argument 构造指定命令的参数，为nil时使用NewArgument
+	Argument func(cmd, appName string) pvd.FCDMArgument
+	// BackupTypes 需要验证的备份类型，为空时验证全部三种备份类型
+	BackupTypes []int
+	// MissingApp 主机上不存在的应用名称
+	MissingApp string
+}
+
+func (c Conformance) argument(cmd, appName string) pvd.FCDMArgument {
+	if c.Argument != nil {
+		return c.Argument(cmd, appName)
+	}
+	return NewArgument(cmd, appName)
+}
+
+func (c Conformance) backupTypes() []int {
+	if len(c.BackupTypes) > 0 {
+		return c.BackupTypes
+	}
+	return []int{model.BACKUP_TYPE_ALL, model.BACKUP_TYPE_DB, model.BACKUP_TYPE_LOG}
+}
+
+func (c Conformance) discover(t *testing.T, p pvd.Provider) []pvd.BackupApplication {
+	t.Helper()
+	apps, err := p.DiscoverApplications()
+	if err != nil {
+		t.Fatalf("DiscoverApplications: %v", err)
+	}
+	return apps
+}
+
+// jsonEqual 判断两个值经过json序列化之后是否相等
+func jsonEqual(t *testing.T, want, got any) bool {
+	t.Helper()
+	norm := func(v any) any {
+		var bs []byte
+		switch x := v.(type) {
+		case string:
+			bs = []byte(x)
+		default:
+			var err error
+			bs, err = json.Marshal(v)
+			if err != nil {
+				t.Fatalf("failed to marshal %T: %v", v, err)
+			}
+		}
+		var res any
+		if err := json.Unmarshal(bs, &res); err != nil {
+			t.Fatalf("invalid json document %q: %v", string(bs), err)
+		}
+		return res
+	}
+	return reflect.DeepEqual(norm(want), norm(got))
+}
+
+// TestProvider 对Provider实现运行一致性测试
+func TestProvider(t *testing.T, c Conformance) {
+	if c.NewProvider == nil {
+		t.Fatal("Conformance.NewProvider is required")
+	}
+	TempState(t)
+	if c.MissingApp == "" {
+		c.MissingApp = "pvdtest-missing-application"
+	}
+
+	t.Run("ValidConfig", func(t *testing.T) {
+		if !c.NewProvider().ValidConfig() {
+			t.Error("ValidConfig returns false for the synthetic argument")
+		}
+	})
+
+	t.Run("Discover", func(t *testing.T) {
+		p := c.NewProvider()
+		res := Run(p, c.argument(model.CMD_DISCOVER, ""))
+		if res.Code != 0 {
+			t.Fatalf("discover exits with code %d", res.Code)
+		}
+
+		var xapps []model.Application
+		if err := res.Decode(&xapps); err != nil {
+			t.Fatalf("discover output is not a list of model.Application: %v", err)
+		}
+
+		apps := c.discover(t, p)
+		if len(apps) != len(xapps) {
+			t.Fatalf("discover outputs %d applications, DiscoverApplications returns %d", len(xapps), len(apps))
+		}
+		for i, app := range apps {
+			if !jsonEqual(t, app.ToFCDMApplication(), xapps[i]) {
+				t.Errorf("application [%s] does not round-trip through the discover output", xapps[i].Name)
+			}
+		}
+	})
+
+	t.Run("UniqueNames", func(t *testing.T) {
+		apps := c.discover(t, c.NewProvider())
+		names := make(map[string]struct{})
+		vols := make(map[string]string)
+		for _, app := range apps {
+			xn := app.GenFCDMApplicationName()
+			key := xn.SecondlyType + "/" + xn.Name
+			if _, ok := names[key]; ok {
+				t.Errorf("duplicated application name [%s]", key)
+			}
+			names[key] = struct{}{}
+
+			if xa := app.ToFCDMApplication(); xa.Name != xn.Name || xa.SecondlyType != xn.SecondlyType {
+				t.Errorf("ToFCDMApplication name [%s/%s] differs from GenFCDMApplicationName [%s]", xa.SecondlyType, xa.Name, key)
+			}
+
+			for _, v := range app.GenFCDMApplicationVolumesName() {
+				if owner, ok := vols[v]; ok {
+					t.Errorf("volume name [%s] of [%s] is already used by [%s]", v, key, owner)
+				}
+				vols[v] = key
+			}
+
+			cfgs := make(map[string]struct{})
+			for _, k := range app.AppConfigurationList() {
+				if _, ok := cfgs[k]; ok {
+					t.Errorf("duplicated configuration key [%s] of [%s]", k, key)
+				}
+				cfgs[k] = struct{}{}
+			}
+		}
+	})
+
+	t.Run("FindApplication", func(t *testing.T) {
+		p := c.NewProvider()
+		for _, app := range c.discover(t, p) {
+			name := app.GenFCDMApplicationName().Name
+			found, err := p.FindApplication(name)
+			if err != nil {
+				t.Errorf("failed to find the discovered application [%s]: %v", name, err)
+				continue
+			}
+			if !jsonEqual(t, app.ToFCDMApplication(), found.ToFCDMApplication()) {
+				t.Errorf("found application [%s] differs from the discovered one", name)
+			}
+		}
+
+		if _, err := p.FindApplication(c.MissingApp); err == nil {
+			t.Errorf("FindApplication returns no error for the missing application [%s]", c.MissingApp)
+		}
+		res := Run(p, c.argument(model.CMD_APPLICATION_INFO, c.MissingApp))
+		if res.Code == 0 {
+			t.Errorf("refresh of the missing application [%s] exits with code 0", c.MissingApp)
+		}
+		var o pvd.Outcome
+		if err := res.Decode(&o); err != nil || o.Code != res.Code || o.Error == "" {
+			t.Errorf("failed refresh outputs no well-formed outcome: %s", res.Stdout)
+		}
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		p := c.NewProvider()
+		for _, app := range c.discover(t, p) {
+			name := app.GenFCDMApplicationName().Name
+			res := Run(p, c.argument(model.CMD_APPLICATION_INFO, name))
+			if res.Code != 0 {
+				t.Errorf("refresh of [%s] exits with code %d", name, res.Code)
+				continue
+			}
+
+			var xapp model.Application
+			if err := res.Decode(&xapp); err != nil {
+				t.Errorf("refresh output of [%s] is not a model.Application: %v", name, err)
+				continue
+			}
+			if !jsonEqual(t, app.ToFCDMApplication(), xapp) {
+				t.Errorf("refresh output of [%s] differs from ToFCDMApplication", name)
+			}
+		}
+	})
+
+	t.Run("Backup", func(t *testing.T) {
+		for _, bt := range c.backupTypes() {
+			t.Run(strconv.Itoa(bt), func(t *testing.T) {
+				p := c.NewProvider()
+				apps := c.discover(t, p)
+				if len(apps) == 0 {
+					t.Skip("no application discovered")
+				}
+				name := apps[0].GenFCDMApplicationName().Name
+
+				arg := c.argument(model.CMD_BACKUP, name)
+				arg.BackupType = strconv.Itoa(bt)
+				res := Run(p, arg)
+				if res.Code != 0 {
+					t.Fatalf("backup of [%s] exits with code %d", name, res.Code)
+				}
+				if res.Document() == "" {
+					t.Fatalf("backup of [%s] outputs no image", name)
+				}
+
+				img, err := p.ParseBackupImage()
+				if err != nil {
+					t.Fatalf("failed to parse the backup image: %v", err)
+				}
+				if !jsonEqual(t, res.Document(), img) {
+					t.Errorf("backup output does not parse back through ParseBackupImage:\noutput: %s\nparsed: %s", res.Document(), img.Meta())
+				}
+			})
+		}
+	})
+
+	t.Run("PluginInfo", func(t *testing.T) {
+		res := Run(c.NewProvider(), c.argument(model.CMD_PLUGIN_INFO, ""))
+		if res.Code != 0 {
+			t.Fatalf("plugin info exits with code %d", res.Code)
+		}
+
+		var conf model.PluginConfig
+		if err := res.Decode(&conf); err != nil {
+			t.Errorf("plugin info output is not a model.PluginConfig: %v", err)
+		}
+	})
+}
@@ -0,0 +1,316 @@
+// Package pvdtest 提供Provider实现的测试工具：内存中的Provider、BackupApplication和BackupImage伪实现，
+// 运行pvd.Do并捕获结果输出的辅助函数，以及可复用的一致性测试集
+package pvdtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"github.com/cnyjp/fcdmpublic/model"
+)
+
+var (
+	ErrNoImage     = errors.New("no backup image has been produced")
+	ErrAppNotFound = errors.New("application not found")
+)
+
+// Image BackupImage的内存实现
+type Image struct {
+	ID         string               `json:"id"`
+	AppName    string               `json:"app_name"`
+	BackupType int                  `json:"backup_type"`
+	Size       int64                `json:"size"`
+	Configs    []model.ConfigConfig `json:"configs,omitempty"`
+}
+
+func (img *Image) Meta() string {
+	bs, _ := json.Marshal(img)
+	return string(bs)
+}
+
+func (img *Image) ToFCDMBackupImage() model.BackupResponse {
+	return model.BackupResponse{
+		ProtectedDataSize: img.Size,
+		Configs:           img.Configs,
+	}
+}
+
+// Application BackupApplication的内存实现，Errs中按方法名称注入错误，例如"BackupAll"。
+// Paths为DataPaths返回的数据路径，OnRestore不为nil时在Restore中调用，用于模拟恢复对数据路径的修改
+type Application struct {
+	Name         string
+	SecondlyType string
+	Volumes      []string
+	Size         int64
+	ConfigKeys   []string
+	Errs         map[string]error
+	Paths        []string
+	OnRestore    func(img pvd.BackupImage) error
+
+	mu       sync.Mutex
+	calls    []string
+	mounted  map[string]bool
+	restored []string
+	provider *Provider
+}
+
+func NewApplication(name, secondlyType string, volumes ...string) *Application {
+	return &Application{
+		Name:         name,
+		SecondlyType: secondlyType,
+		Volumes:      volumes,
+		Errs:         make(map[string]error),
+		mounted:      make(map[string]bool),
+	}
+}
+
+// Calls 按调用顺序返回被调用过的方法名称
+func (app *Application) Calls() []string {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return append([]string(nil), app.calls...)
+}
+
+// Mounted 镜像当前是否挂载到应用上
+func (app *Application) Mounted(img pvd.BackupImage) bool {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.mounted[img.Meta()]
+}
+
+// Restored 按顺序返回已恢复镜像的元数据
+func (app *Application) Restored() []string {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return append([]string(nil), app.restored...)
+}
+
+func (app *Application) call(name string) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	app.calls = append(app.calls, name)
+	return app.Errs[name]
+}
+
+func (app *Application) backup(name string, backupType int) (pvd.BackupImage, error) {
+	if err := app.call(name); err != nil {
+		return nil, err
+	}
+
+	img := &Image{
+		AppName:    app.Name,
+		BackupType: backupType,
+		Size:       app.Size,
+	}
+	if app.provider != nil {
+		app.provider.addImage(img)
+	} else {
+		img.ID = fmt.Sprintf("%s-%d", app.Name, len(app.Calls()))
+	}
+	return img, nil
+}
+
+func (app *Application) BackupAll() (pvd.BackupImage, error) {
+	return app.backup("BackupAll", model.BACKUP_TYPE_ALL)
+}
+
+func (app *Application) BackupDataOnly() (pvd.BackupImage, error) {
+	return app.backup("BackupDataOnly", model.BACKUP_TYPE_DB)
+}
+
+func (app *Application) BackupLogOnly() (pvd.BackupImage, error) {
+	return app.backup("BackupLogOnly", model.BACKUP_TYPE_LOG)
+}
+
+func (app *Application) AppType() string {
+	return app.SecondlyType
+}
+
+func (app *Application) AppConfigurationList() []string {
+	return app.ConfigKeys
+}
+
+func (app *Application) DataPaths() ([]string, error) {
+	if err := app.call("DataPaths"); err != nil {
+		return nil, err
+	}
+	return app.Paths, nil
+}
+
+func (app *Application) Restore(backupSet pvd.BackupImage) error {
+	if err := app.call("Restore"); err != nil {
+		return err
+	}
+	if app.OnRestore != nil {
+		if err := app.OnRestore(backupSet); err != nil {
+			return err
+		}
+	}
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	app.restored = append(app.restored, backupSet.Meta())
+	return nil
+}
+
+func (app *Application) Mount(backupSet pvd.BackupImage) error {
+	if err := app.call("Mount"); err != nil {
+		return err
+	}
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	app.mounted[backupSet.Meta()] = true
+	return nil
+}
+
+// IsMounted 实现pvd.MountChecker
+func (app *Application) IsMounted(backupSet pvd.BackupImage) (bool, error) {
+	if err := app.call("IsMounted"); err != nil {
+		return false, err
+	}
+	return app.Mounted(backupSet), nil
+}
+
+func (app *Application) UnMount(backupSet pvd.BackupImage) error {
+	if err := app.call("UnMount"); err != nil {
+		return err
+	}
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	delete(app.mounted, backupSet.Meta())
+	return nil
+}
+
+func (app *Application) GenFCDMApplicationName() model.Application {
+	return model.Application{
+		Name:         app.Name,
+		SecondlyType: app.SecondlyType,
+	}
+}
+
+func (app *Application) GenFCDMApplicationVolumesName() []string {
+	return app.Volumes
+}
+
+func (app *Application) ToFCDMApplication() model.Application {
+	xapp := app.GenFCDMApplicationName()
+	xapp.DisplayName = app.Name
+	xapp.Size = app.Size
+	xapp.Available = true
+	for _, v := range app.Volumes {
+		xapp.Volumes = append(xapp.Volumes, model.Volume{
+			Name:      v,
+			Identity:  v,
+			StageType: model.STAGE_TYPE_FILESYSTEM,
+			Size:      app.Size,
+		})
+	}
+	return xapp
+}
+
+func (app *Application) Refresh() {
+	app.call("Refresh")
+}
+
+// Provider Provider的内存实现，Errs中按方法名称注入错误，例如"DiscoverApplications"
+type Provider struct {
+	Apps    []*Application
+	Invalid bool
+	Info    model.PluginConfig
+	Errs    map[string]error
+
+	mu     sync.Mutex
+	images []*Image
+}
+
+func NewProvider(apps ...*Application) *Provider {
+	p := &Provider{
+		Apps: apps,
+		Info: model.PluginConfig{PEName: "pvdtest"},
+		Errs: make(map[string]error),
+	}
+	for _, app := range apps {
+		app.provider = p
+	}
+	return p
+}
+
+// Images 按产生顺序返回所有备份镜像
+func (p *Provider) Images() []*Image {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Image(nil), p.images...)
+}
+
+func (p *Provider) addImage(img *Image) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	img.ID = fmt.Sprintf("%s-%d", img.AppName, len(p.images))
+	p.images = append(p.images, img)
+}
+
+func (p *Provider) ValidConfig() bool {
+	return !p.Invalid
+}
+
+// ParseBackupImage 返回最近一次备份产生的镜像，等同于从卷上的元数据文件解析镜像
+func (p *Provider) ParseBackupImage() (pvd.BackupImage, error) {
+	if err := p.Errs["ParseBackupImage"]; err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.images) == 0 {
+		return nil, ErrNoImage
+	}
+	return p.images[len(p.images)-1], nil
+}
+
+// LoadBackupImage 根据元数据查找已经产生的镜像
+func (p *Provider) LoadBackupImage(meta string) (pvd.BackupImage, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, img := range p.images {
+		if img.Meta() == meta {
+			return img, nil
+		}
+	}
+	return nil, ErrNoImage
+}
+
+func (p *Provider) DiscoverApplications() ([]pvd.BackupApplication, error) {
+	if err := p.Errs["DiscoverApplications"]; err != nil {
+		return nil, err
+	}
+
+	res := make([]pvd.BackupApplication, 0, len(p.Apps))
+	for _, app := range p.Apps {
+		res = append(res, app)
+	}
+	return res, nil
+}
+
+func (p *Provider) FindApplication(appName string) (pvd.BackupApplication, error) {
+	if err := p.Errs["FindApplication"]; err != nil {
+		return nil, err
+	}
+
+	for _, app := range p.Apps {
+		if app.Name == appName {
+			return app, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrAppNotFound, appName)
+}
+
+func (p *Provider) HandleLang(lang *pvd.LangPackage) {}
+
+func (p *Provider) PlugInfo() string {
+	return pvd.PluginInfoJson(p.Info)
+}
@@ -0,0 +1,84 @@
+package pvdtest_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"gitea.fcdm.top/lixuan/keen/pvd/pvdtest"
+	"github.com/cnyjp/fcdmpublic/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func sampleProvider() *pvdtest.Provider {
+	return pvdtest.NewProvider(
+		pvdtest.NewApplication("orcl", "oracle", "orcl_data", "orcl_log"),
+		pvdtest.NewApplication("mysql", "mysql", "mysql_data"),
+	)
+}
+
+func TestConformance(t *testing.T) {
+	pvdtest.TestProvider(t, pvdtest.Conformance{
+		NewProvider: func() pvd.Provider { return sampleProvider() },
+	})
+}
+
+func TestBackupType(t *testing.T) {
+	calls := map[int]string{
+		model.BACKUP_TYPE_ALL: "BackupAll",
+		model.BACKUP_TYPE_DB:  "BackupDataOnly",
+		model.BACKUP_TYPE_LOG: "BackupLogOnly",
+	}
+
+	for bt, call := range calls {
+		p := sampleProvider()
+		arg := pvdtest.NewArgument(model.CMD_BACKUP, "orcl")
+		arg.BackupType = strconv.Itoa(bt)
+
+		res := pvdtest.Run(p, arg)
+		assert.Equal(t, 0, res.Code, "backup should succeed")
+		assert.Equal(t, []string{call}, p.Apps[0].Calls(), "wrong backup method")
+
+		var img pvdtest.Image
+		assert.NoError(t, res.Decode(&img), "backup output should be an image")
+		assert.Equal(t, bt, img.BackupType, "wrong backup type of the image")
+	}
+}
+
+func TestMountAndRestore(t *testing.T) {
+	pvdtest.TempState(t)
+	p := sampleProvider()
+	app := p.Apps[0]
+
+	res := pvdtest.Run(p, pvdtest.NewArgument(model.CMD_BACKUP, "orcl"))
+	assert.Equal(t, 0, res.Code, "backup should succeed")
+	img := p.Images()[0]
+
+	res = pvdtest.Run(p, pvdtest.NewArgument(model.CMD_MOUNT, "orcl"))
+	assert.Equal(t, 0, res.Code, "mount should succeed")
+	assert.True(t, app.Mounted(img), "image should be mounted")
+
+	res = pvdtest.Run(p, pvdtest.NewArgument(model.CMD_UMOUNT, "orcl"))
+	assert.Equal(t, 0, res.Code, "unmount should succeed")
+	assert.False(t, app.Mounted(img), "image should be unmounted")
+
+	res = pvdtest.Run(p, pvdtest.NewArgument(model.CMD_RESTORE, "orcl"))
+	assert.Equal(t, 0, res.Code, "restore should succeed")
+	assert.Equal(t, []string{img.Meta()}, app.Restored(), "image should be restored")
+}
+
+func TestInjectedError(t *testing.T) {
+	p := sampleProvider()
+	p.Apps[0].Errs["BackupAll"] = errors.New("disk is full")
+
+	res := pvdtest.Run(p, pvdtest.NewArgument(model.CMD_BACKUP, "orcl"))
+	assert.Equal(t, pvd.C_ERR_EXIT, res.Code, "backup should fail")
+	var o pvd.Outcome
+	assert.NoError(t, res.Decode(&o), "failed backup should output the outcome")
+	assert.Equal(t, pvd.Outcome{Code: pvd.C_ERR_EXIT, Error: "disk is full"}, o)
+
+	p.Invalid = true
+	res = pvdtest.Run(p, pvdtest.NewArgument(model.CMD_DISCOVER, ""))
+	assert.Equal(t, pvd.C_ERR_EXIT, res.Code, "invalid configuration should fail")
+}
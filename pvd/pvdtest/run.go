@@ -0,0 +1,89 @@
+package pvdtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"github.com/cnyjp/fcdmpublic/model"
+)
+
+const (
+	JOB_ID = "pvdtest-job"
+	VOLUME = "pvdtest_volume"
+)
+
+// Result 一次pvd.Do调用的结果，Stdout为结果输出的全部内容
+type Result struct {
+	Code   int
+	Stdout string
+}
+
+// Document 返回结果输出的最后一个非空行，FCDM以此作为命令结果
+func (r Result) Document() string {
+	lines := strings.Split(strings.TrimRight(r.Stdout, "\r\n"), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if l := strings.TrimSpace(lines[i]); l != "" {
+			return l
+		}
+	}
+	return ""
+}
+
+// Decode 将结果文档以json格式解析到v
+func (r Result) Decode(v any) error {
+	doc := r.Document()
+	if doc == "" {
+		return fmt.Errorf("no result document in output (exit code %d)", r.Code)
+	}
+	return json.Unmarshal([]byte(doc), v)
+}
+
+// NewArgument 构造合成的FCDMArgument，卷信息指向临时目录，备份类型为全备份
+func NewArgument(cmd, appName string) pvd.FCDMArgument {
+	return pvd.FCDMArgument{
+		Command:                 cmd,
+		ApplicationName:         appName,
+		Configs:                 make(map[string]string),
+		ImageConfigs:            make(map[string]string),
+		VolumeInformation:       map[string]string{model.FCDM_EV_VOLUME_PREFIX + VOLUME: os.TempDir()},
+		VolsIdentityInformation: map[string]string{model.FCDM_EV_VOLUME_IDENTITY_PREFIX + VOLUME: VOLUME},
+		BackupType:              strconv.Itoa(model.BACKUP_TYPE_ALL),
+		JobStep:                 model.JOB_STEP_NORMAL,
+		JobType:                 model.JOB_TYPE_BACKUP,
+		JobID:                   JOB_ID,
+	}
+}
+
+// TempState 测试期间将pvd的挂载登记表、发现结果和恢复保护记录放到测试的临时目录中，测试结束后恢复
+func TempState(t testing.TB) {
+	dir := t.TempDir()
+	oldMounts, oldDiscoveries, oldProtections := pvd.Mounts, pvd.Discoveries, pvd.Protections
+	pvd.Mounts = pvd.NewMountRegistry(filepath.Join(dir, "mounts.json"))
+	pvd.Discoveries = pvd.NewDiscoveryTracker(filepath.Join(dir, "discovery"))
+	pvd.Protections = &pvd.Protector{
+		Mode:      oldProtections.Mode,
+		Retention: oldProtections.Retention,
+		Dir:       filepath.Join(dir, "protect"),
+	}
+	t.Cleanup(func() {
+		pvd.Mounts, pvd.Discoveries, pvd.Protections = oldMounts, oldDiscoveries, oldProtections
+	})
+}
+
+// Run 执行pvd.Do并捕获结果输出和退出码，运行期间替换pvd.Results，不能并发调用
+func Run(p pvd.Provider, arg pvd.FCDMArgument) Result {
+	buf := new(bytes.Buffer)
+	old := pvd.Results
+	pvd.Results = &pvd.StdoutResultWriter{Out: buf}
+	defer func() { pvd.Results = old }()
+
+	code := pvd.Do(p, arg)
+	return Result{code, buf.String()}
+}
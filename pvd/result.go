@@ -0,0 +1,110 @@
+package pvd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gitea.fcdm.top/lixuan/keen/util"
+)
+
+const (
+	ENV_RESULT_FILE   = "KEEN_RESULT_FILE"
+	ENV_RESULT_FRAMED = "KEEN_RESULT_FRAMED"
+)
+
+const (
+	RESULT_BEGIN = "KEEN_RESULT_BEGIN"
+	RESULT_END   = "KEEN_RESULT_END"
+)
+
+var errInvalidResult = errors.New("the result document is not a valid json")
+
+// Results 命令结果的默认输出方式，根据环境变量选择
+var Results = NewResultWriterFromEnv()
+
+// ResultWriter 命令结果文档的输出方式，每次调用Do只会写入一个结果文档
+type ResultWriter interface {
+	WriteResult(doc []byte) error
+}
+
+// Outcome 没有业务结果的命令（恢复、挂载、卸载）以及没有产生结果就失败的命令输出的结果文档，
+// 开启恢复保护时Protection为保护的结果
+type Outcome struct {
+	Code       int               `json:"code"`
+	Error      string            `json:"error,omitempty"`
+	Protection *ProtectionReport `json:"protection,omitempty"`
+}
+
+// NewResultWriterFromEnv KEEN_RESULT_FILE不为空时将结果写入该文件，KEEN_RESULT_FRAMED不为空时在标准输出中用开始和结束标记包围结果，
+// 否则结果作为单行json写入标准输出
+func NewResultWriterFromEnv() ResultWriter {
+	if p := os.Getenv(ENV_RESULT_FILE); p != "" {
+		return &FileResultWriter{Path: p}
+	}
+	if os.Getenv(ENV_RESULT_FRAMED) != "" {
+		return &FramedResultWriter{Out: os.Stdout}
+	}
+	return &StdoutResultWriter{Out: os.Stdout}
+}
+
+func compact(doc []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := json.Compact(buf, doc); err != nil {
+		return nil, errInvalidResult
+	}
+	return buf.Bytes(), nil
+}
+
+// StdoutResultWriter 结果作为单行json输出，FCDM读取最后一行输出作为命令结果
+type StdoutResultWriter struct {
+	Out io.Writer
+}
+
+func (w *StdoutResultWriter) WriteResult(doc []byte) error {
+	bs, err := compact(doc)
+	if err != nil {
+		return err
+	}
+	_, err = w.Out.Write(append(bs, '\n'))
+	return err
+}
+
+// FramedResultWriter 结果前后分别输出开始和结束标记所在的行，调用者可以从混杂了其他内容的输出中截取结果
+type FramedResultWriter struct {
+	Out io.Writer
+}
+
+func (w *FramedResultWriter) WriteResult(doc []byte) error {
+	bs, err := compact(doc)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(RESULT_BEGIN + "\n")
+	buf.Write(bs)
+	buf.WriteString("\n" + RESULT_END + "\n")
+	_, err = w.Out.Write(buf.Bytes())
+	return err
+}
+
+// FileResultWriter 结果写入指定的文件，先写临时文件再重命名，读取者不会看到不完整的结果
+type FileResultWriter struct {
+	Path string
+}
+
+func (w *FileResultWriter) WriteResult(doc []byte) error {
+	bs, err := compact(doc)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(w.Path), os.ModeDir|0700); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(w.Path, bs, 0600)
+}
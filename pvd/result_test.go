@@ -0,0 +1,116 @@
+package pvd_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen"
+	"gitea.fcdm.top/lixuan/keen/pvd"
+	"gitea.fcdm.top/lixuan/keen/pvd/pvdtest"
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/cnyjp/fcdmpublic/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type panicProvider struct {
+	*pvdtest.Provider
+}
+
+func (p panicProvider) DiscoverApplications() ([]pvd.BackupApplication, error) {
+	panic("unexpected nil pointer")
+}
+
+func TestResultWriters(t *testing.T) {
+	doc := []byte("{\n  \"name\": \"orcl\"\n}")
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, (&pvd.StdoutResultWriter{Out: buf}).WriteResult(doc))
+	assert.Equal(t, "{\"name\":\"orcl\"}\n", buf.String(), "result should be a single line")
+
+	buf.Reset()
+	assert.NoError(t, (&pvd.FramedResultWriter{Out: buf}).WriteResult(doc))
+	assert.Equal(t, pvd.RESULT_BEGIN+"\n{\"name\":\"orcl\"}\n"+pvd.RESULT_END+"\n", buf.String())
+
+	path := filepath.Join(t.TempDir(), "result", "result.json")
+	assert.NoError(t, (&pvd.FileResultWriter{Path: path}).WriteResult(doc))
+	bs, err := os.ReadFile(path)
+	assert.NoError(t, err, "result file should be written")
+	assert.Equal(t, "{\"name\":\"orcl\"}", string(bs))
+
+	buf.Reset()
+	assert.Error(t, (&pvd.StdoutResultWriter{Out: buf}).WriteResult([]byte("not json")), "invalid document should be rejected")
+	assert.Empty(t, buf.String())
+}
+
+func TestResultFromEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.json")
+	t.Setenv(pvd.ENV_RESULT_FILE, path)
+	assert.Equal(t, &pvd.FileResultWriter{Path: path}, pvd.NewResultWriterFromEnv())
+
+	t.Setenv(pvd.ENV_RESULT_FILE, "")
+	t.Setenv(pvd.ENV_RESULT_FRAMED, "1")
+	assert.IsType(t, &pvd.FramedResultWriter{}, pvd.NewResultWriterFromEnv())
+}
+
+func TestExactlyOneResult(t *testing.T) {
+	pvdtest.TempState(t)
+	p := pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"))
+
+	cases := []struct {
+		pvd  pvd.Provider
+		arg  pvd.FCDMArgument
+		code int
+	}{
+		{p, pvdtest.NewArgument(model.CMD_DISCOVER, ""), 0},
+		{p, pvdtest.NewArgument(model.CMD_BACKUP, "orcl"), 0},
+		{p, pvdtest.NewArgument(model.CMD_RESTORE, "orcl"), 0},
+		{p, pvdtest.NewArgument(model.CMD_RESTORE, "missing"), pvd.C_ERR_EXIT},
+		{panicProvider{p}, pvdtest.NewArgument(model.CMD_DISCOVER, ""), pvd.C_ERR_EXIT},
+	}
+
+	for _, c := range cases {
+		res := pvdtest.Run(c.pvd, c.arg)
+		assert.Equal(t, c.code, res.Code, "unexpected exit code of [%s]", c.arg.Command)
+		assert.Equal(t, 1, strings.Count(res.Stdout, "\n"), "exactly one result should be written for [%s]", c.arg.Command)
+
+		if c.arg.Command == model.CMD_RESTORE || c.code != 0 {
+			var o pvd.Outcome
+			assert.NoError(t, res.Decode(&o), "outcome should be written for [%s]", c.arg.Command)
+			assert.Equal(t, c.code, o.Code)
+		}
+	}
+
+	res := pvdtest.Run(panicProvider{p}, pvdtest.NewArgument(model.CMD_DISCOVER, ""))
+	var o pvd.Outcome
+	assert.NoError(t, res.Decode(&o))
+	assert.Contains(t, o.Error, "unexpected nil pointer", "panic should be reported")
+}
+
+// TestResultIsLastLine 日志和结果共用标准输出时，结果必须是最后一行
+func TestResultIsLastLine(t *testing.T) {
+	pvdtest.TempState(t)
+	stdout := new(bytes.Buffer)
+	oldLog, oldResults, oldMetrics := keen.Log, pvd.Results, pvd.Metrics
+	keen.Log = ylog.NewLogger(ylog.NewConsoleWriterTo(stdout, func(i int8) bool { return i >= ylog.TRACE }, false))
+	pvd.Results = &pvd.StdoutResultWriter{Out: stdout}
+	pvd.Metrics = pvd.NewMetricsWriter(t.TempDir())
+	defer func() { keen.Log, pvd.Results, pvd.Metrics = oldLog, oldResults, oldMetrics }()
+
+	p := pvdtest.NewProvider(pvdtest.NewApplication("orcl", "oracle", "orcl_data"))
+	for _, arg := range []pvd.FCDMArgument{
+		pvdtest.NewArgument(model.CMD_DISCOVER, ""),
+		pvdtest.NewArgument(model.CMD_BACKUP, "missing"),
+	} {
+		stdout.Reset()
+		pvd.Do(p, arg)
+
+		out := strings.TrimRight(stdout.String(), "\n")
+		assert.Contains(t, out, "metrics are written to", "logs should be written to the same output")
+		last := out[strings.LastIndex(out, "\n")+1:]
+		assert.True(t, json.Valid([]byte(last)), "the last line should be the result document: %q", last)
+	}
+}
@@ -0,0 +1,253 @@
+package util
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrEnvRequired    = errors.New("required environment variable is not set")
+	ErrEnvUnsupported = errors.New("unsupported field type")
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	fileModeType        = reflect.TypeOf(fs.FileMode(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// EnvError 环境变量绑定到结构体字段时的错误
+type EnvError struct {
+	Field string
+	Var   string
+	Err   error
+}
+
+func (e *EnvError) Error() string {
+	return fmt.Sprintf("environment variable [%s] -> field [%s]: %v", e.Var, e.Field, e.Err)
+}
+
+func (e *EnvError) Unwrap() error {
+	return e.Err
+}
+
+type envTag struct {
+	name     string
+	prefix   bool
+	trim     bool
+	required bool
+	sep      string
+	excludes []string
+}
+
+func parseEnvTag(tag string) envTag {
+	segs := strings.Split(tag, ",")
+	res := envTag{name: segs[0], sep: ","}
+	for _, opt := range segs[1:] {
+		switch {
+		case opt == "prefix":
+			res.prefix = true
+		case opt == "trim":
+			res.trim = true
+		case opt == "required":
+			res.required = true
+		case strings.HasPrefix(opt, "exclude="):
+			res.excludes = append(res.excludes, strings.TrimPrefix(opt, "exclude="))
+		case strings.HasPrefix(opt, "sep="):
+			res.sep = strings.TrimPrefix(opt, "sep=")
+		}
+	}
+	return res
+}
+
+// ParseEnviron 将os.Environ()格式的环境变量转换为map，值中的=会被保留，Windows上以=开头的特殊变量也能正确处理
+func ParseEnviron(environ []string) map[string]string {
+	res := make(map[string]string, len(environ))
+	for _, line := range environ {
+		i := strings.Index(line, "=")
+		if i == 0 {
+			i = strings.Index(line[1:], "=") + 1
+		}
+		if i <= 0 {
+			continue
+		}
+		res[line[:i]] = line[i+1:]
+	}
+	return res
+}
+
+// BindEnv 根据结构体字段的标签将环境变量绑定到结构体，v必须是结构体指针。
+//
+// 标签格式为 `env:"NAME,选项..."`，default标签提供未设置或为空时的默认值，支持的选项：
+//   - required 环境变量未设置或为空时报错
+//   - prefix 字段为map，收集所有以NAME为前缀的环境变量，键为完整的变量名
+//   - trim 与prefix一起使用，键去掉前缀
+//   - exclude=PREFIX 与prefix一起使用，忽略以PREFIX为前缀的变量，可以指定多次
+//   - sep=SEP 切片元素的分隔符，默认为逗号
+//
+// 支持字符串、布尔、整数、浮点数、time.Duration、fs.FileMode、encoding.TextUnmarshaler以及它们的切片，
+// 整数可以使用0x、0o等前缀；fs.FileMode总是按照八进制解析，0640和640相同，十进制的416会被当作0416，包含8或9时报错。
+// 没有env标签的结构体字段会递归绑定。所有字段的错误汇总为*ErrGroup返回
+func BindEnv(v any, environ []string) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("BindEnv requires a non-nil pointer to struct, got %T", v)
+	}
+
+	eg := NewErrGroup()
+	bindStruct(rv.Elem(), ParseEnviron(environ), eg)
+	return eg.Err()
+}
+
+func bindStruct(rv reflect.Value, env map[string]string, eg *ErrGroup) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		fv := rv.Field(i)
+		if !fv.CanSet() {
+			continue
+		}
+
+		raw, ok := f.Tag.Lookup("env")
+		if !ok {
+			if f.Type.Kind() == reflect.Struct && !reflect.PointerTo(f.Type).Implements(textUnmarshalerType) {
+				bindStruct(fv, env, eg)
+			}
+			continue
+		}
+		if raw == "-" {
+			continue
+		}
+
+		tag := parseEnvTag(raw)
+		if tag.prefix {
+			if err := bindPrefix(fv, tag, env); err != nil {
+				eg.AddErrs(&EnvError{f.Name, tag.name + "*", err})
+			}
+			continue
+		}
+
+		s := env[tag.name]
+		if s == "" {
+			if def, ok := f.Tag.Lookup("default"); ok {
+				s = def
+			} else if tag.required {
+				eg.AddErrs(&EnvError{f.Name, tag.name, ErrEnvRequired})
+				continue
+			} else {
+				continue
+			}
+		}
+
+		if err := setEnvValue(fv, s, tag.sep); err != nil {
+			eg.AddErrs(&EnvError{f.Name, tag.name, err})
+		}
+	}
+}
+
+func bindPrefix(fv reflect.Value, tag envTag, env map[string]string) error {
+	t := fv.Type()
+	if t.Kind() != reflect.Map || t.Key().Kind() != reflect.String {
+		return fmt.Errorf("%w: prefix requires a map with string keys, got %s", ErrEnvUnsupported, t)
+	}
+
+	m := reflect.MakeMap(t)
+	eg := NewErrGroup()
+out:
+	for k, v := range env {
+		if !strings.HasPrefix(k, tag.name) {
+			continue
+		}
+		for _, ex := range tag.excludes {
+			if strings.HasPrefix(k, ex) {
+				continue out
+			}
+		}
+
+		ev := reflect.New(t.Elem()).Elem()
+		if err := setEnvValue(ev, v, tag.sep); err != nil {
+			eg.AddErrs(fmt.Errorf("%s: %w", k, err))
+			continue
+		}
+		if tag.trim {
+			k = strings.TrimPrefix(k, tag.name)
+		}
+		m.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), ev)
+	}
+
+	fv.Set(m)
+	return eg.Err()
+}
+
+func setEnvValue(fv reflect.Value, s string, sep string) error {
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+	if fv.Type() == fileModeType {
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(s, "0o"), "0O"), 8, 32)
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 0, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 0, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Slice:
+		segs := strings.Split(s, sep)
+		sl := reflect.MakeSlice(fv.Type(), 0, len(segs))
+		for _, seg := range segs {
+			ev := reflect.New(fv.Type().Elem()).Elem()
+			if err := setEnvValue(ev, strings.TrimSpace(seg), sep); err != nil {
+				return err
+			}
+			sl = reflect.Append(sl, ev)
+		}
+		fv.Set(sl)
+	default:
+		return fmt.Errorf("%w: %s", ErrEnvUnsupported, fv.Type())
+	}
+
+	return nil
+}
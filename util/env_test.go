@@ -0,0 +1,133 @@
+package util_test
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/util"
+	"github.com/stretchr/testify/assert"
+)
+
+type envNested struct {
+	Timeout time.Duration `env:"TEST_TIMEOUT" default:"5s"`
+}
+
+type envSample struct {
+	Name    string            `env:"TEST_NAME,required"`
+	Token   string            `env:"TEST_TOKEN"`
+	Port    int               `env:"TEST_PORT" default:"8080"`
+	Ratio   float64           `env:"TEST_RATIO"`
+	Debug   bool              `env:"TEST_DEBUG"`
+	Hosts   []string          `env:"TEST_HOSTS"`
+	Codes   []uint16          `env:"TEST_CODES,sep=;"`
+	IP      net.IP            `env:"TEST_IP"`
+	Opts    map[string]string `env:"TEST_OPT_,prefix,exclude=TEST_OPT_SECRET_"`
+	Trimmed map[string]int    `env:"TEST_NUM_,prefix,trim"`
+	Ignored string            `env:"-"`
+	Nested  envNested
+	hidden  string `env:"TEST_NAME"`
+}
+
+func TestParseEnviron(t *testing.T) {
+	env := util.ParseEnviron([]string{"A=1", "B=x=y==", "C=", "=C:=C:\\work", "broken"})
+	assert.Equal(t, map[string]string{"A": "1", "B": "x=y==", "C": "", "=C:": "C:\\work"}, env)
+}
+
+func TestBindEnv(t *testing.T) {
+	var s envSample
+	err := util.BindEnv(&s, []string{
+		"TEST_NAME=keen",
+		"TEST_TOKEN=dGVzdA==",
+		"TEST_RATIO=0.5",
+		"TEST_DEBUG=true",
+		"TEST_HOSTS=a, b,c",
+		"TEST_CODES=200;404",
+		"TEST_IP=10.0.0.1",
+		"TEST_OPT_A=1",
+		"TEST_OPT_B=x=y",
+		"TEST_OPT_SECRET_C=3",
+		"TEST_NUM_X=7",
+		"TEST_TIMEOUT=1m",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "keen", s.Name)
+	assert.Equal(t, "dGVzdA==", s.Token)
+	assert.Equal(t, 8080, s.Port)
+	assert.Equal(t, 0.5, s.Ratio)
+	assert.True(t, s.Debug)
+	assert.Equal(t, []string{"a", "b", "c"}, s.Hosts)
+	assert.Equal(t, []uint16{200, 404}, s.Codes)
+	assert.Equal(t, "10.0.0.1", s.IP.String())
+	assert.Equal(t, map[string]string{"TEST_OPT_A": "1", "TEST_OPT_B": "x=y"}, s.Opts)
+	assert.Equal(t, map[string]int{"X": 7}, s.Trimmed)
+	assert.Equal(t, time.Minute, s.Nested.Timeout)
+	assert.Empty(t, s.hidden)
+}
+
+func TestBindEnvFileMode(t *testing.T) {
+	type modes struct {
+		Mode os.FileMode   `env:"TEST_MODE"`
+		Dir  fs.FileMode   `env:"TEST_DIR_MODE" default:"750"`
+		All  []os.FileMode `env:"TEST_MODES"`
+	}
+	var m modes
+	assert.Nil(t, util.BindEnv(&m, []string{"TEST_MODE=0640", "TEST_MODES=640,0o640,0640"}))
+	assert.Equal(t, os.FileMode(0640), m.Mode)
+	assert.Equal(t, fs.FileMode(0750), m.Dir, "modes without the leading zero are octal")
+	assert.Equal(t, []os.FileMode{0640, 0640, 0640}, m.All)
+
+	// 十进制的值按照八进制解析
+	m = modes{}
+	assert.Nil(t, util.BindEnv(&m, []string{"TEST_MODE=416"}))
+	assert.Equal(t, os.FileMode(0416), m.Mode)
+	err := util.BindEnv(&m, []string{"TEST_MODE=0x1a0"})
+	assert.Error(t, err)
+	err = util.BindEnv(&m, []string{"TEST_MODE=680"})
+	assert.Error(t, err, "8 is not an octal digit")
+}
+
+func TestBindEnvDefaults(t *testing.T) {
+	var s envSample
+	err := util.BindEnv(&s, []string{"TEST_NAME=keen"})
+	assert.Nil(t, err)
+	assert.Equal(t, 8080, s.Port)
+	assert.Equal(t, 5*time.Second, s.Nested.Timeout)
+	assert.NotNil(t, s.Opts)
+	assert.Empty(t, s.Opts)
+}
+
+func TestBindEnvErrors(t *testing.T) {
+	var s envSample
+	err := util.BindEnv(&s, []string{
+		"TEST_PORT=http",
+		"TEST_DEBUG=maybe",
+		"TEST_NUM_X=seven",
+		"TEST_TIMEOUT=10",
+	})
+
+	var eg *util.ErrGroup
+	if !errors.As(err, &eg) {
+		t.Fatalf("expected *util.ErrGroup, got %v", err)
+	}
+	errs := eg.Errors()
+	assert.Len(t, errs, 5)
+
+	fields := make([]string, 0, len(errs))
+	for _, e := range errs {
+		var ee *util.EnvError
+		if assert.True(t, errors.As(e, &ee)) {
+			fields = append(fields, ee.Field)
+		}
+	}
+	assert.Equal(t, []string{"Name", "Port", "Debug", "Trimmed", "Timeout"}, fields)
+
+	var ee *util.EnvError
+	errors.As(errs[0], &ee)
+	assert.ErrorIs(t, ee, util.ErrEnvRequired)
+
+	assert.NotNil(t, util.BindEnv(s, nil))
+}
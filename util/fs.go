@@ -9,12 +9,20 @@ import (
 	"os"
 	"path/filepath"
 	"sync/atomic"
+	"time"
 
 	"gitea.fcdm.top/lixuan/keen"
+	"gitea.fcdm.top/lixuan/keen/internal/lockfile"
 )
 
 type FileSize int64
 
+const (
+	LOCK_RETRY_INTERVAL = 50 * time.Millisecond
+	LOCK_TIMEOUT        = 10 * time.Second
+	LOCK_STALE          = time.Minute
+)
+
 const (
 	B          FileSize = 1
 	KB         FileSize = 1024
@@ -34,6 +42,48 @@ func PathExists(path string) bool {
 	return true
 }
 
+// WriteFileAtomic 先写入同一目录下的临时文件再重命名为path，读取者不会看到不完整的文件。
+// 临时文件的名称是唯一的，多个进程同时写入时以最后一次重命名为准
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// LockFile 以独占方式创建锁文件实现跨进程互斥，返回释放锁的函数。
+// 等待超过LOCK_TIMEOUT时返回错误，超过LOCK_STALE未释放的锁视为持有进程已经退出。
+// 锁文件中记录持有者的令牌，释放锁时不会删除其他进程接管之后的锁
+func LockFile(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModeDir|0700); err != nil {
+		return nil, err
+	}
+
+	return lockfile.Lock(path, lockfile.Options{
+		Retry:   LOCK_RETRY_INTERVAL,
+		Timeout: LOCK_TIMEOUT,
+		Stale:   LOCK_STALE,
+		OnStale: func(path string) {
+			keen.Log.Warn("remove the stale lock file [%s]", path)
+		},
+	})
+}
+
 func IsDir(path string) bool {
 	fi, err := os.Stat(path)
 	if err != nil {
@@ -141,7 +191,7 @@ func SetupCopyTasks(tasks []*CopyTask) error {
 			}()
 
 			if t.Src == "" || t.Dst == "" {
-				errCh <- fmt.Errorf("source file (%s) or destination file (%s) is invalid", task.Src, task.Dst)
+				errCh <- fmt.Errorf("source file (%s) or destination file (%s) is invalid", t.Src, t.Dst)
 				return
 			}
 
@@ -195,6 +245,62 @@ func SetupCopyTasks(tasks []*CopyTask) error {
 	return nil
 }
 
+// Exec 执行已经初始化的拷贝任务，完成后关闭源文件和目标文件，失败时删除目标文件
+func (t *CopyTask) Exec(buf []byte) error {
+	if t.sFile == nil || t.dFile == nil {
+		return fmt.Errorf("copy task (%s -> %s) is not setup", t.Src, t.Dst)
+	}
+
+	_, err := io.CopyBuffer(t.dFile, t.sFile, buf)
+	if err == nil {
+		err = t.dFile.Sync()
+	}
+	if err != nil {
+		if cerr := t.Clean(); cerr != nil {
+			keen.Log.Error("failed to clean the copy task (%s -> %s): %v", t.Src, t.Dst, cerr)
+		}
+		return err
+	}
+
+	t.sFile.Close()
+	err = t.dFile.Close()
+	t.sFile, t.dFile = nil, nil
+	return err
+}
+
+// ExecCopyTasks 初始化拷贝任务并按照文件大小分成最多maxGroups组并发执行，buffer为每组使用的缓冲区大小
+func ExecCopyTasks(tasks []*CopyTask, maxGroups uint, buffer int) error {
+	if err := SetupCopyTasks(tasks); err != nil {
+		return err
+	}
+
+	gtasks := SplitTasksToNGroups(tasks, maxGroups)
+	errCh := make(chan error, len(gtasks))
+	for _, gt := range gtasks {
+		go func(gt GroupCopyTask) {
+			buf := make([]byte, buffer)
+			for i, t := range gt.Tasks {
+				if err := t.Exec(buf); err != nil {
+					for _, rest := range gt.Tasks[i+1:] {
+						rest.Clean()
+					}
+					errCh <- fmt.Errorf("%v: %w", t, err)
+					return
+				}
+			}
+			errCh <- nil
+		}(gt)
+	}
+
+	eg := NewErrGroup()
+	for range gtasks {
+		if err := <-errCh; err != nil {
+			eg.AddErrs(err)
+		}
+	}
+	return eg.Err()
+}
+
 type GroupCopyTask struct {
 	SizeSum FileSize
 	Tasks   []*CopyTask
@@ -246,7 +352,7 @@ func SplitTasksToNGroups(tasks []*CopyTask, maxGroups uint) []GroupCopyTask {
 		t := tasks[0]
 		return []GroupCopyTask{GroupCopyTask{t.Size, []*CopyTask{t}}}
 	} else if tasksNum <= int(maxGroups) {
-		g := make([]GroupCopyTask, 0, tasksNum)
+		g := make([]GroupCopyTask, tasksNum)
 		for i := 0; i < tasksNum; i++ {
 			ct := tasks[i]
 			g[i] = GroupCopyTask{ct.Size, []*CopyTask{ct}}
@@ -4,6 +4,7 @@
 package util
 
 import (
+	"io/fs"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -33,3 +34,19 @@ func AllocateDisk(filePath string, size FileSize) (*os.File, error) {
 
 	return f, nil
 }
+
+// CopyOwner 将dst（不跟随符号链接）的所有者修改为src的所有者，所有者相同时不做修改
+func CopyOwner(dst string, src fs.FileInfo) error {
+	st, ok := src.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	dfi, err := os.Lstat(dst)
+	if err != nil {
+		return err
+	}
+	if dst, ok := dfi.Sys().(*syscall.Stat_t); ok && dst.Uid == st.Uid && dst.Gid == st.Gid {
+		return nil
+	}
+	return os.Lchown(dst, int(st.Uid), int(st.Gid))
+}
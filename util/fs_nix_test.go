@@ -5,13 +5,14 @@ package util_test
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"gitea.fcdm.top/lixuan/keen/util"
 )
 
 func TestAllocateDisk(t *testing.T) {
-	fn := "./xx1"
+	fn := filepath.Join(t.TempDir(), "xx1")
 	f, err := util.AllocateDisk(fn, 1000*util.MB)
 	if err != nil {
 		t.Errorf("failed to create file: %v", err)
@@ -26,11 +27,10 @@ func TestAllocateDisk(t *testing.T) {
 	}
 
 	f.Close()
-	// os.Remove(fn)
 }
 
 func TestAllocateDiskOutOfSpace(t *testing.T) {
-	fn := "./xx2"
+	fn := filepath.Join(t.TempDir(), "xx2")
 	f, err := util.AllocateDisk(fn, 1000*util.GB)
 	if err != nil {
 		if util.IsSpaceNotEnough(err) {
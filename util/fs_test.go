@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
@@ -107,3 +108,50 @@ func TestCopy(t *testing.T) {
 	// 	t.Logf("failed to execute copy task:\n  %v", err)
 	// }
 }
+
+func TestExecCopyTasks(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dst, "sub"), 0700))
+	tasks := make([]*util.CopyTask, 0)
+	for i := 0; i < 6; i++ {
+		name := "file" + strconv.Itoa(i)
+		content := strings.Repeat(name, 1000*(i+1))
+		assert.NoError(t, os.WriteFile(filepath.Join(src, name), []byte(content), 0600))
+		tasks = append(tasks, util.NewCopyTask(filepath.Join(src, name), filepath.Join(dst, "sub", name)))
+	}
+
+	assert.NoError(t, util.ExecCopyTasks(tasks, 4, 512))
+	for i := 0; i < 6; i++ {
+		name := "file" + strconv.Itoa(i)
+		bs, err := os.ReadFile(filepath.Join(dst, "sub", name))
+		assert.NoError(t, err)
+		assert.Equal(t, strings.Repeat(name, 1000*(i+1)), string(bs))
+	}
+
+	err := util.ExecCopyTasks([]*util.CopyTask{util.NewCopyTask(filepath.Join(src, "missing"), filepath.Join(dst, "missing"))}, 4, 512)
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(dst, "missing"))
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "state.json")
+
+	assert.NoError(t, util.WriteFileAtomic(p, []byte("first"), 0600))
+	assert.NoError(t, util.WriteFileAtomic(p, []byte("second"), 0644))
+
+	bs, err := os.ReadFile(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(bs))
+	fi, err := os.Stat(p)
+	assert.NoError(t, err)
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+	}
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary file should be left")
+
+	assert.Error(t, util.WriteFileAtomic(filepath.Join(dir, "missing", "x"), nil, 0600))
+}
@@ -4,6 +4,7 @@
 package util
 
 import (
+	"io/fs"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -33,3 +34,8 @@ func AllocateDisk(filePath string, size FileSize) (*os.File, error) {
 
 	return f, nil
 }
+
+// CopyOwner Windows上文件的所有者和权限由ACL继承，不做修改
+func CopyOwner(dst string, src fs.FileInfo) error {
+	return nil
+}
@@ -397,6 +397,11 @@ func (eg *ErrGroup) AddErrs(errs ...error) {
 	eg.errors = append(eg.errors, errs...)
 }
 
+// Errors 返回收集到的所有错误
+func (eg *ErrGroup) Errors() []error {
+	return eg.errors
+}
+
 func (eg *ErrGroup) IsNil() bool {
 	return len(eg.errors) == 0
 }
@@ -0,0 +1,417 @@
+package ylog
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/internal/lockfile"
+)
+
+// ArchiveMode 归档日志的方式
+type ArchiveMode int
+
+const (
+	ARCHIVE_MOVE     ArchiveMode = iota // 移动到归档目录
+	ARCHIVE_COMPRESS                    // 移动到归档目录并单独压缩
+	ARCHIVE_BUNDLE                      // 按照归档目录和周期打包为tar.gz
+)
+
+const (
+	ARCHIVE_PERIOD_FMT = "20060102"
+	BUNDLE_EXT         = ".tar.gz"
+	ARCHIVE_IDLE       = 10 * time.Minute // 默认超过此时长没有修改的日志才会被压缩或者打包
+)
+
+const (
+	ARCHIVE_LOCK_FILE    = ".keen-archive.lock"
+	ARCHIVE_LOCK_RETRY   = 50 * time.Millisecond
+	ARCHIVE_LOCK_TIMEOUT = 10 * time.Second
+	ARCHIVE_LOCK_STALE   = 10 * time.Minute // 打包较大的日志耗时较长，比一般的锁文件更晚视为失效
+)
+
+// Compressor 单独压缩归档日志的压缩算法。内置的只有gzip，标准库和现有依赖中没有zstd的实现，
+// 需要zstd时基于第三方库实现此接口
+type Compressor interface {
+	Ext() string
+	Compress(w io.Writer) (io.WriteCloser, error)
+}
+
+// GzipCompressor gzip压缩，Level为0时使用默认压缩级别
+type GzipCompressor struct {
+	Level int
+}
+
+func (c GzipCompressor) Ext() string {
+	return ".gz"
+}
+
+func (c GzipCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	if c.Level == 0 {
+		return gzip.NewWriter(w), nil
+	}
+	return gzip.NewWriterLevel(w, c.Level)
+}
+
+// ArchiveOptions 归档的配置，零值表示只移动到归档目录。
+// 其他进程可能仍在写入归档的日志，所以只有轮转日志和超过Idle没有修改的日志会被压缩或者打包，其余的日志在之后的归档中处理
+type ArchiveOptions struct {
+	Mode       ArchiveMode
+	Compressor Compressor               // ARCHIVE_COMPRESS使用的压缩算法，为nil时使用gzip
+	Period     func(t time.Time) string // ARCHIVE_BUNDLE的打包周期，参数为日志的修改时间，为nil时按天打包
+	Idle       time.Duration            // 日志不再写入的判断时长，不大于0时使用ARCHIVE_IDLE
+}
+
+func (o ArchiveOptions) compressor() Compressor {
+	if o.Compressor != nil {
+		return o.Compressor
+	}
+	return GzipCompressor{}
+}
+
+func (o ArchiveOptions) idle() time.Duration {
+	if o.Idle > 0 {
+		return o.Idle
+	}
+	return ARCHIVE_IDLE
+}
+
+func (o ArchiveOptions) period(t time.Time) string {
+	if o.Period != nil {
+		return o.Period(t)
+	}
+	return t.Format(ARCHIVE_PERIOD_FMT)
+}
+
+// archived 归档目录中的文件是否已经是压缩或者打包之后的文件
+func (o ArchiveOptions) archived(name string) bool {
+	return strings.HasSuffix(name, BUNDLE_EXT) || strings.HasSuffix(name, o.compressor().Ext())
+}
+
+func DeleteExpiredLogAndArchive(logDir string, expire time.Duration, archive Archive) {
+	deleteExpiredLogAndArchive(logDir, expire, archive, "", nil, ArchiveOptions{})
+}
+
+// DeleteExpiredLogAndArchiveWith 删除过期日志并按照opts归档
+func DeleteExpiredLogAndArchiveWith(logDir string, expire time.Duration, archive Archive, opts ArchiveOptions) {
+	deleteExpiredLogAndArchive(logDir, expire, archive, "", nil, opts)
+}
+
+// deleteExpiredLogAndArchive 删除日志目录和归档目录中的过期日志，并将日志目录中的日志归档，
+// 压缩或者打包时归档目录中未压缩的日志也会被处理。exclude为正在写入的日志文件，不会被删除或者归档；
+// rotated判断文件是否是已经轮转的日志，可以不等待Idle直接压缩或者打包，为nil时都需要等待。
+// 多个进程共用日志目录，整个过程持有日志目录下的锁文件，以.开头的文件（锁文件和临时文件）会被忽略
+func deleteExpiredLogAndArchive(logDir string, expire time.Duration, archive Archive, exclude string, rotated func(name string) bool, opts ArchiveOptions) {
+	var (
+		isArc bool
+		arcMp map[string][]string = make(map[string][]string)
+	)
+
+	if archive != nil {
+		isArc = true
+	}
+
+	n := time.Now()
+	st := n.Add(-expire)
+	absPath, _ := filepath.Abs(logDir)
+
+	unlock, err := lockFile(filepath.Join(absPath, ARCHIVE_LOCK_FILE))
+	if err != nil {
+		Errorf("failed to lock the log directory [%s] for archiving: %v\n", absPath, err)
+		return
+	}
+	defer unlock()
+
+	Infof("delete expired log files in [%s]: motification datetime <= %s\n", absPath, st.Format(LOG_TIME_FMT))
+	err = filepath.WalkDir(absPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == absPath || path == exclude {
+			return nil
+		}
+
+		parent := filepath.Dir(path)
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			// 只进入一级归档目录
+			if parent == absPath {
+				return nil
+			}
+			return filepath.SkipDir
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if expire != 0 && fi.ModTime().Before(st) {
+			err = os.Remove(path)
+			if err != nil {
+				Errorf("failed to delete log file [%s]: %v\n", path, err)
+			}
+		} else if parent != absPath {
+			if opts.Mode != ARCHIVE_MOVE && !opts.archived(d.Name()) {
+				arcMp[filepath.Base(parent)] = append(arcMp[filepath.Base(parent)], path)
+			}
+		} else {
+			if isArc {
+				if b, dst := archive(d.Name()); b {
+					arcMp[dst] = append(arcMp[dst], path)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		Errorf("error occurred while clean expired log files: %v\n", err)
+	}
+
+	for dir, fs := range arcMp {
+		ndir := filepath.Join(absPath, dir)
+		err := os.Mkdir(ndir, os.ModeDir|0700)
+		if err != nil {
+			if !os.IsExist(err) {
+				Errorf("failed to create archive directory [%s]: %v\n", ndir, err)
+				continue
+			}
+		}
+
+		moved := make([]string, 0, len(fs))
+		for _, f := range fs {
+			np := filepath.Join(ndir, filepath.Base(f))
+			if np != f {
+				err := os.Rename(f, np)
+				if err != nil {
+					Errorf("failed to move the log file from [%s] to [%s]: %v\n", f, np, err)
+					continue
+				}
+			}
+			moved = append(moved, np)
+		}
+
+		// 仍在写入的日志只移动，等到不再写入之后再压缩或者打包
+		settled := make([]string, 0, len(moved))
+		for _, f := range moved {
+			fi, err := os.Stat(f)
+			if err != nil {
+				Errorf("failed to stat the log file [%s]: %v\n", f, err)
+				continue
+			}
+			if (rotated != nil && rotated(filepath.Base(f))) || n.Sub(fi.ModTime()) >= opts.idle() {
+				settled = append(settled, f)
+			}
+		}
+
+		switch opts.Mode {
+		case ARCHIVE_COMPRESS:
+			for _, f := range settled {
+				if err := compressFile(f, opts.compressor()); err != nil {
+					Errorf("failed to compress the log file [%s]: %v\n", f, err)
+				}
+			}
+		case ARCHIVE_BUNDLE:
+			bundles := make(map[string][]string)
+			for _, f := range settled {
+				fi, err := os.Stat(f)
+				if err != nil {
+					Errorf("failed to stat the log file [%s]: %v\n", f, err)
+					continue
+				}
+				b := filepath.Join(ndir, dir+"_"+opts.period(fi.ModTime())+BUNDLE_EXT)
+				bundles[b] = append(bundles[b], f)
+			}
+			for b, files := range bundles {
+				if err := bundleFiles(b, files); err != nil {
+					Errorf("failed to bundle the log files into [%s]: %v\n", b, err)
+				}
+			}
+		}
+	}
+}
+
+// writeAtomic 通过同一目录下名称唯一的临时文件写入目标文件，并将修改时间设置为mod，保证过期判断基于原始日志的时间
+func writeAtomic(dst string, mod time.Time, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chtimes(tmp, mod, mod); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// compressFile 压缩日志文件并删除原文件
+func compressFile(path string, c Compressor) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = writeAtomic(path+c.Ext(), fi.ModTime(), func(w io.Writer) error {
+		cw, err := c.Compress(w)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(cw, src); err != nil {
+			cw.Close()
+			return err
+		}
+		return cw.Close()
+	})
+	if err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
+
+// bundleFiles 将日志文件追加到tar.gz包中并删除原文件，已有的包会被重写，同名的文件以新的为准
+func bundleFiles(bundle string, files []string) error {
+	sort.Strings(files)
+	names := make(map[string]bool)
+	var mod time.Time
+	for _, f := range files {
+		names[filepath.Base(f)] = true
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(mod) {
+			mod = fi.ModTime()
+		}
+	}
+	if fi, err := os.Stat(bundle); err == nil && fi.ModTime().After(mod) {
+		mod = fi.ModTime()
+	}
+
+	err := writeAtomic(bundle, mod, func(w io.Writer) error {
+		gw := gzip.NewWriter(w)
+		tw := tar.NewWriter(gw)
+
+		if err := copyBundle(bundle, tw, names); err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := addToTar(tw, f); err != nil {
+				return err
+			}
+		}
+
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gw.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			Errorf("failed to remove the bundled log file [%s]: %v\n", f, err)
+		}
+	}
+	return nil
+}
+
+// copyBundle 将已有包中不在skip中的文件复制到新的包中
+func copyBundle(bundle string, tw *tar.Writer, skip map[string]bool) error {
+	f, err := os.Open(bundle)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if skip[hdr.Name] {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func addToTar(tw *tar.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// lockFile 锁定日志目录，返回释放锁的函数。
+// 等待超过ARCHIVE_LOCK_TIMEOUT时返回错误，超过ARCHIVE_LOCK_STALE未释放的锁视为持有进程已经退出
+func lockFile(path string) (func(), error) {
+	return lockfile.Lock(path, lockfile.Options{
+		Retry:   ARCHIVE_LOCK_RETRY,
+		Timeout: ARCHIVE_LOCK_TIMEOUT,
+		Stale:   ARCHIVE_LOCK_STALE,
+		OnStale: func(path string) {
+			Errorf("remove the stale lock file [%s]\n", path)
+		},
+	})
+}
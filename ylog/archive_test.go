@@ -0,0 +1,205 @@
+package ylog_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+var cmdArchive ylog.Archive = func(fn string) (bool, string) {
+	if i := strings.Index(fn, "_"); i > 0 {
+		return true, fn[:i]
+	}
+	return false, ""
+}
+
+func writeLog(t *testing.T, path, content string, mod time.Time) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	assert.NoError(t, os.Chtimes(path, mod, mod))
+}
+
+func readTarGz(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	tr := tar.NewReader(gr)
+	res := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return res
+		}
+		assert.NoError(t, err)
+		bs, _ := io.ReadAll(tr)
+		res[hdr.Name] = string(bs)
+	}
+}
+
+func TestArchiveCompress(t *testing.T) {
+	dir := t.TempDir()
+	mod := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeLog(t, filepath.Join(dir, "backup_1.log"), "backup one", mod)
+	writeLog(t, filepath.Join(dir, "backup", "backup_0.log"), "raw from move mode", mod)
+	writeLog(t, filepath.Join(dir, "other.log"), "not archived", mod)
+
+	ylog.DeleteExpiredLogAndArchiveWith(dir, 24*time.Hour, cmdArchive, ylog.ArchiveOptions{Mode: ylog.ARCHIVE_COMPRESS})
+
+	assert.Equal(t, []string{"backup", "other.log"}, listDir(t, dir))
+	assert.Equal(t, []string{"backup_0.log.gz", "backup_1.log.gz"}, listDir(t, filepath.Join(dir, "backup")))
+
+	p := filepath.Join(dir, "backup", "backup_1.log.gz")
+	f, err := os.Open(p)
+	assert.NoError(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	bs, _ := io.ReadAll(gr)
+	assert.Equal(t, "backup one", string(bs))
+	fi, _ := os.Stat(p)
+	assert.True(t, mod.Equal(fi.ModTime()), "modification time should be kept for expiration")
+}
+
+func TestArchiveBundle(t *testing.T) {
+	dir := t.TempDir()
+	day1 := time.Date(2026, 10, 18, 10, 0, 0, 0, time.Local)
+	day2 := day1.Add(24 * time.Hour)
+	writeLog(t, filepath.Join(dir, "backup_1.log"), "one", day1)
+	writeLog(t, filepath.Join(dir, "backup_2.log"), "two", day1.Add(time.Hour))
+	writeLog(t, filepath.Join(dir, "backup_3.log"), "three", day2)
+
+	opts := ylog.ArchiveOptions{Mode: ylog.ARCHIVE_BUNDLE}
+	ylog.DeleteExpiredLogAndArchiveWith(dir, 0, cmdArchive, opts)
+
+	bdir := filepath.Join(dir, "backup")
+	assert.Equal(t, []string{"backup_20261018.tar.gz", "backup_20261019.tar.gz"}, listDir(t, bdir))
+	assert.Equal(t, map[string]string{"backup_1.log": "one", "backup_2.log": "two"}, readTarGz(t, filepath.Join(bdir, "backup_20261018.tar.gz")))
+
+	writeLog(t, filepath.Join(dir, "backup_4.log"), "four", day1.Add(2*time.Hour))
+	writeLog(t, filepath.Join(dir, "backup_1.log"), "one again", day1.Add(3*time.Hour))
+	ylog.DeleteExpiredLogAndArchiveWith(dir, 0, cmdArchive, opts)
+
+	assert.Equal(t, []string{"backup_20261018.tar.gz", "backup_20261019.tar.gz"}, listDir(t, bdir))
+	assert.Equal(t, map[string]string{"backup_1.log": "one again", "backup_2.log": "two", "backup_4.log": "four"}, readTarGz(t, filepath.Join(bdir, "backup_20261018.tar.gz")))
+	fi, _ := os.Stat(filepath.Join(bdir, "backup_20261018.tar.gz"))
+	assert.True(t, day1.Add(3*time.Hour).Equal(fi.ModTime()))
+}
+
+func TestArchiveExpireInArchiveDirectory(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	writeLog(t, filepath.Join(dir, "backup", "backup_1.log.gz"), "old", old)
+	writeLog(t, filepath.Join(dir, "backup", "backup_20240101.tar.gz"), "old", old)
+	writeLog(t, filepath.Join(dir, "backup", "backup_2.log"), "new", time.Now())
+	writeLog(t, filepath.Join(dir, "backup", "nested", "backup_3.log"), "nested", old)
+
+	ylog.DeleteExpiredLogAndArchive(dir, 24*time.Hour, cmdArchive)
+
+	names := listDir(t, filepath.Join(dir, "backup"))
+	sort.Strings(names)
+	assert.Equal(t, []string{"backup_2.log", "nested"}, names)
+	assert.FileExists(t, filepath.Join(dir, "backup", "nested", "backup_3.log"), "only one level of archive directories is visited")
+}
+
+func TestRotateCompressArchive(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{t: time.Date(2024, 1, 2, 15, 0, 0, 0, time.Local)}
+	w, err := ylog.NewRotatingFileWriter(dir, "app_x.log", all, 0, cmdArchive, ylog.RotateOptions{
+		MaxSize: 100,
+		Clock:   clock.now,
+		Archive: ylog.ArchiveOptions{Mode: ylog.ARCHIVE_COMPRESS},
+	})
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+	for i := 0; i < 3; i++ {
+		logger.Info("compressed rotation message %02d", i)
+		clock.add(time.Second)
+	}
+	logger.Clean()
+
+	assert.Equal(t, []string{"app", "app_x.log"}, listDir(t, dir))
+	for _, n := range listDir(t, filepath.Join(dir, "app")) {
+		assert.True(t, strings.HasSuffix(n, ".log.gz"), n)
+	}
+}
+
+func TestArchiveSkipsActiveLogs(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, filepath.Join(dir, "backup_1.log"), "finished", time.Now().Add(-time.Hour))
+	writeLog(t, filepath.Join(dir, "backup_2.log"), "still written by another process", time.Now())
+
+	opts := ylog.ArchiveOptions{Mode: ylog.ARCHIVE_COMPRESS}
+	ylog.DeleteExpiredLogAndArchiveWith(dir, 0, cmdArchive, opts)
+	assert.Equal(t, []string{"backup_1.log.gz", "backup_2.log"}, listDir(t, filepath.Join(dir, "backup")))
+
+	opts.Idle = time.Nanosecond
+	ylog.DeleteExpiredLogAndArchiveWith(dir, 0, cmdArchive, opts)
+	assert.Equal(t, []string{"backup_1.log.gz", "backup_2.log.gz"}, listDir(t, filepath.Join(dir, "backup")))
+}
+
+func TestArchiveBundleConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2026, 10, 18, 10, 0, 0, 0, time.Local)
+	opts := ylog.ArchiveOptions{Mode: ylog.ARCHIVE_BUNDLE}
+
+	// 每个goroutine模拟一个进程，写入自己的日志之后归档，打包时不能丢失其他进程加入的日志
+	var wg sync.WaitGroup
+	staging := t.TempDir()
+	want := make(map[string]string)
+	for i := 0; i < 16; i++ {
+		name := fmt.Sprintf("backup_%02d.log", i)
+		content := strings.Repeat(name, 16*1024)
+		want[name] = content
+		writeLog(t, filepath.Join(staging, name), content, day)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, os.Rename(filepath.Join(staging, name), filepath.Join(dir, name)))
+			ylog.DeleteExpiredLogAndArchiveWith(dir, 0, cmdArchive, opts)
+		}()
+	}
+	wg.Wait()
+	ylog.DeleteExpiredLogAndArchiveWith(dir, 0, cmdArchive, opts)
+
+	assert.Equal(t, []string{"backup"}, listDir(t, dir))
+	assert.Equal(t, []string{"backup_20261018.tar.gz"}, listDir(t, filepath.Join(dir, "backup")), "no temporary files should be left")
+	got := readTarGz(t, filepath.Join(dir, "backup", "backup_20261018.tar.gz"))
+	assert.Len(t, got, len(want))
+	assert.True(t, reflect.DeepEqual(want, got), "bundled log files are lost or broken")
+}
+
+func TestArchiveWaitsForLock(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, filepath.Join(dir, "backup_1.log"), "one", time.Now().Add(-time.Hour))
+	// 其他进程正在归档
+	lock := filepath.Join(dir, ylog.ARCHIVE_LOCK_FILE)
+	assert.NoError(t, os.WriteFile(lock, []byte("1"), 0600))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ylog.DeleteExpiredLogAndArchiveWith(dir, 0, cmdArchive, ylog.ArchiveOptions{Mode: ylog.ARCHIVE_COMPRESS})
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	assert.FileExists(t, filepath.Join(dir, "backup_1.log"), "archiving should wait for the lock")
+	assert.NoError(t, os.Remove(lock))
+	<-done
+
+	assert.Equal(t, []string{"backup"}, listDir(t, dir), "the lock file should be removed")
+	assert.Equal(t, []string{"backup_1.log.gz"}, listDir(t, filepath.Join(dir, "backup")))
+}
@@ -0,0 +1,199 @@
+package ylog
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy 异步写入队列已满时的处理策略
+type OverflowPolicy int
+
+const (
+	OVERFLOW_BLOCK       OverflowPolicy = iota // 阻塞直到队列有空间
+	OVERFLOW_DROP_OLDEST                       // 丢弃队列中最早的日志
+	OVERFLOW_DROP_BELOW                        // 丢弃低于DropBelow级别的日志，其他级别阻塞
+)
+
+const (
+	DEFAULT_ASYNC_QUEUE_SIZE = 4096
+	DEFAULT_ASYNC_BATCH_SIZE = 128
+)
+
+var ErrWriterClosed = errors.New("the log writer is closed")
+
+// AsyncOptions 异步写入的配置，QueueSize和BatchSize不大于0时使用默认值
+type AsyncOptions struct {
+	QueueSize int
+	BatchSize int
+	Policy    OverflowPolicy
+	DropBelow int8
+}
+
+// AsyncWriter 将日志放入有界队列，由后台goroutine批量写入被包装的LogWriter，每批写入之后刷新一次。
+// Flush等待队列中的日志全部写入，Close（以及Logger.Clean、Logger.Fatal）在写入全部日志之后清理被包装的LogWriter
+type AsyncWriter struct {
+	inner LogWriter
+	opts  AsyncOptions
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queue    []LogMessage
+	head     int
+	size     int
+	inflight int
+	closed   bool
+	done     chan struct{}
+	dropped  atomic.Uint64
+	status   writerStatus
+}
+
+func NewAsyncWriter(inner LogWriter, opts AsyncOptions) *AsyncWriter {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DEFAULT_ASYNC_QUEUE_SIZE
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DEFAULT_ASYNC_BATCH_SIZE
+	}
+
+	w := &AsyncWriter{
+		inner: inner,
+		opts:  opts,
+		queue: make([]LogMessage, opts.QueueSize),
+		done:  make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+// Dropped 因为队列已满而丢弃的日志数量
+func (w *AsyncWriter) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+func (w *AsyncWriter) unwrap() LogWriter {
+	return w.inner
+}
+
+// health 后台写入的失败由AsyncWriter记录，被包装的输出目标自己维护健康状态时使用它的状态
+func (w *AsyncWriter) health() WriterHealth {
+	if hr, ok := findHealthReporter(w.inner); ok {
+		return hr.health()
+	}
+	return w.status.health(w.inner)
+}
+
+func (w *AsyncWriter) push(m LogMessage) {
+	w.queue[(w.head+w.size)%len(w.queue)] = m
+	w.size++
+	w.cond.Broadcast()
+}
+
+func (w *AsyncWriter) writeRecord(m LogMessage) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for !w.closed && w.size == len(w.queue) {
+		switch {
+		case w.opts.Policy == OVERFLOW_DROP_OLDEST:
+			w.queue[w.head] = LogMessage{}
+			w.head = (w.head + 1) % len(w.queue)
+			w.size--
+			w.dropped.Add(1)
+		case w.opts.Policy == OVERFLOW_DROP_BELOW && m.level < w.opts.DropBelow:
+			w.dropped.Add(1)
+			return nil
+		default:
+			w.cond.Wait()
+		}
+	}
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	w.push(m)
+	return nil
+}
+
+// run 后台批量写入日志，关闭之后写完队列中剩余的日志再退出
+func (w *AsyncWriter) run() {
+	defer close(w.done)
+	batch := make([]LogMessage, 0, w.opts.BatchSize)
+	for {
+		w.mu.Lock()
+		for w.size == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if w.size == 0 && w.closed {
+			w.mu.Unlock()
+			return
+		}
+
+		for w.size > 0 && len(batch) < w.opts.BatchSize {
+			batch = append(batch, w.queue[w.head])
+			w.queue[w.head] = LogMessage{}
+			w.head = (w.head + 1) % len(w.queue)
+			w.size--
+		}
+		w.inflight = len(batch)
+		w.cond.Broadcast()
+		w.mu.Unlock()
+
+		for _, m := range batch {
+			err := w.inner.writeRecord(m)
+			if _, ok := findHealthReporter(w.inner); !ok {
+				w.status.record(w.inner, err)
+			}
+		}
+		w.inner.flush()
+
+		w.mu.Lock()
+		w.inflight = 0
+		w.cond.Broadcast()
+		w.mu.Unlock()
+		batch = batch[:0]
+	}
+}
+
+// Flush 等待队列中已有的日志全部写入并刷新
+func (w *AsyncWriter) Flush() {
+	w.mu.Lock()
+	for w.size > 0 || w.inflight > 0 {
+		w.cond.Wait()
+	}
+	w.mu.Unlock()
+}
+
+// Close 停止接收日志，写入队列中剩余的日志之后清理被包装的LogWriter，可以重复调用
+func (w *AsyncWriter) Close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		<-w.done
+		return
+	}
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+
+	<-w.done
+	w.inner.clean()
+}
+
+func (w *AsyncWriter) enable(l int8) bool {
+	return w.inner.enable(l)
+}
+
+// Write 等待队列中的日志写入之后，将内容直接写入被包装的LogWriter
+func (w *AsyncWriter) Write(p []byte) (int, error) {
+	w.Flush()
+	return w.inner.Write(p)
+}
+
+// flush 每条日志之后的刷新由后台批量完成，需要等待写入时调用Flush
+func (w *AsyncWriter) flush() {}
+
+func (w *AsyncWriter) clean() {
+	w.Close()
+}
@@ -0,0 +1,176 @@
+package ylog_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+// gateWriter 每次写入都通知entered，并在gate关闭之前阻塞，用于模拟缓慢的输出
+type gateWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	entered chan struct{}
+	gate    chan struct{}
+}
+
+func newGateWriter() *gateWriter {
+	return &gateWriter{entered: make(chan struct{}, 1024), gate: make(chan struct{})}
+}
+
+func (w *gateWriter) Write(p []byte) (int, error) {
+	w.entered <- struct{}{}
+	<-w.gate
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *gateWriter) messages(t *testing.T) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	res := make([]string, 0)
+	for _, l := range decodeLines(t, w.buf.String()) {
+		res = append(res, l.Msg)
+	}
+	return res
+}
+
+func all(i int8) bool { return i >= ylog.TRACE }
+
+func TestAsyncWriterFlush(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := ylog.NewAsyncWriter(ylog.NewJSONWriter(buf, all), ylog.AsyncOptions{QueueSize: 8, BatchSize: 3})
+	logger := ylog.NewLogger(w)
+
+	for i := 0; i < 100; i++ {
+		logger.Info("m%d", i)
+	}
+	w.Flush()
+
+	lines := decodeLines(t, buf.String())
+	assert.Len(t, lines, 100)
+	for i, l := range lines {
+		assert.Equal(t, fmt.Sprintf("m%d", i), l.Msg)
+	}
+	assert.Zero(t, w.Dropped())
+
+	logger.Clean()
+	logger.Info("after close")
+	assert.Len(t, decodeLines(t, buf.String()), 100, "records after close are rejected")
+}
+
+func TestAsyncWriterDropOldest(t *testing.T) {
+	gw := newGateWriter()
+	w := ylog.NewAsyncWriter(ylog.NewJSONWriter(gw, all), ylog.AsyncOptions{QueueSize: 4, BatchSize: 1, Policy: ylog.OVERFLOW_DROP_OLDEST})
+	logger := ylog.NewLogger(w)
+
+	logger.Info("m0")
+	<-gw.entered
+	for i := 1; i < 10; i++ {
+		logger.Info("m%d", i)
+	}
+	close(gw.gate)
+	logger.Clean()
+
+	assert.Equal(t, []string{"m0", "m6", "m7", "m8", "m9"}, gw.messages(t))
+	assert.Equal(t, uint64(5), w.Dropped())
+}
+
+func TestAsyncWriterDropBelow(t *testing.T) {
+	gw := newGateWriter()
+	w := ylog.NewAsyncWriter(ylog.NewJSONWriter(gw, all), ylog.AsyncOptions{QueueSize: 2, BatchSize: 1, Policy: ylog.OVERFLOW_DROP_BELOW, DropBelow: ylog.WARN})
+	logger := ylog.NewLogger(w)
+
+	logger.Info("m0")
+	<-gw.entered
+	logger.Info("m1")
+	logger.Info("m2")
+	logger.Debug("dropped")
+	logger.Info("dropped")
+
+	done := make(chan struct{})
+	go func() {
+		logger.Error("kept")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("record at or above DropBelow should block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(gw.gate)
+	<-done
+	logger.Clean()
+
+	assert.Equal(t, []string{"m0", "m1", "m2", "kept"}, gw.messages(t))
+	assert.Equal(t, uint64(2), w.Dropped())
+}
+
+func TestAsyncWriterBlock(t *testing.T) {
+	gw := newGateWriter()
+	w := ylog.NewAsyncWriter(ylog.NewJSONWriter(gw, all), ylog.AsyncOptions{QueueSize: 1, BatchSize: 1})
+	logger := ylog.NewLogger(w)
+
+	logger.Info("m0")
+	<-gw.entered
+	logger.Info("m1")
+
+	done := make(chan struct{})
+	go func() {
+		logger.Info("m2")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("logging should block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(gw.gate)
+	<-done
+	logger.Clean()
+	assert.Equal(t, []string{"m0", "m1", "m2"}, gw.messages(t))
+	assert.Zero(t, w.Dropped())
+}
+
+func benchmarkLogger(b *testing.B, logger ylog.Logger) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.InfoKV("benchmark message", "idx", i, "job", "42")
+	}
+	b.StopTimer()
+	logger.Clean()
+}
+
+func BenchmarkSyncFileWriter(b *testing.B) {
+	file, err := ylog.NewFileWriter(b.TempDir(), "sync.log", all, 0, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkLogger(b, ylog.NewLogger(file))
+}
+
+func BenchmarkAsyncFileWriter(b *testing.B) {
+	file, err := ylog.NewFileWriter(b.TempDir(), "async.log", all, 0, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkLogger(b, ylog.NewLogger(ylog.NewAsyncWriter(file, ylog.AsyncOptions{})))
+}
+
+func BenchmarkSyncJSONWriter(b *testing.B) {
+	benchmarkLogger(b, ylog.NewLogger(ylog.NewJSONWriter(io.Discard, all)))
+}
+
+func BenchmarkAsyncJSONWriter(b *testing.B) {
+	benchmarkLogger(b, ylog.NewLogger(ylog.NewAsyncWriter(ylog.NewJSONWriter(io.Discard, all), ylog.AsyncOptions{})))
+}
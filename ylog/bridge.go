@@ -0,0 +1,51 @@
+package ylog
+
+import (
+	"io"
+	"log"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// StdWriter 将标准库log.Logger的输出转为ylog的日志记录，每次Write为一条日志，
+// 调用者为调用log包的代码，而不是log包本身
+type StdWriter struct {
+	logger Logger
+	level  int8
+}
+
+// NewStdWriter 创建以level级别写入logger的io.Writer，可以作为log.SetOutput或者第三方库的日志输出
+func NewStdWriter(logger Logger, level int) *StdWriter {
+	return &StdWriter{logger, int8(level)}
+}
+
+// NewStdLogger 创建输出到logger的*log.Logger，时间和调用者由ylog记录，所以不使用log的前缀标志
+func NewStdLogger(logger Logger, level int) *log.Logger {
+	return log.New(NewStdWriter(logger, level), "", 0)
+}
+
+var _ io.Writer = (*StdWriter)(nil)
+
+// stdCaller 跳过log包和StdWriter自身的栈帧，找到调用log包的代码
+func stdCaller() (uintptr, string, int, string) {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, "log.") {
+			return f.PC, f.File, f.Line, f.Function
+		}
+		if !more {
+			return f.PC, f.File, f.Line, f.Function
+		}
+	}
+}
+
+func (w *StdWriter) Write(p []byte) (int, error) {
+	pc, fn, ln, fun := stdCaller()
+	text := strings.TrimRight(string(p), "\r\n")
+	w.logger.log(makeMessage(w.level, time.Now(), pc, fn, ln, fun, text, w.logger.fields, nil))
+	return len(p), nil
+}
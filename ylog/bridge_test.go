@@ -0,0 +1,22 @@
+package ylog_test
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := ylog.NewLogger(ylog.NewConsoleWriterTo(buf, func(i int8) bool { return i >= ylog.INFO }, false))
+	bound := logger.With("lib", "driver")
+
+	std := ylog.NewStdLogger(bound, ylog.WARN)
+	std.Printf("connection reset, retry %d", 3)
+	log.New(ylog.NewStdWriter(logger, ylog.DEBUG), "", 0).Print("filtered by level")
+
+	assert.Regexp(t, `^\[.+\] \[WARN \] \[ylog/bridge_test\.go:\d+\] - connection reset, retry 3 lib=driver\n$`, buf.String())
+}
@@ -0,0 +1,166 @@
+package ylog_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+var levelColors = map[string]string{
+	"TRACE": "\x1b[36m",
+	"DEBUG": "\x1b[32m",
+	"INFO":  "\x1b[37m",
+	"WARN":  "\x1b[33m",
+	"ERROR": "\x1b[31m",
+}
+
+func TestConcurrentConsoleWriterColor(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := ylog.NewLogger(ylog.NewConsoleWriterTo(buf, all, true))
+
+	const goroutines, count = 10, 100
+	wg := sync.WaitGroup{}
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				switch (i + j) % 5 {
+				case 0:
+					logger.Trace("goroutine %d message %d", i, j)
+				case 1:
+					logger.Debug("goroutine %d message %d", i, j)
+				case 2:
+					logger.Info("goroutine %d message %d", i, j)
+				case 3:
+					logger.Warn("goroutine %d message %d", i, j)
+				case 4:
+					logger.Error("goroutine %d message %d", i, j)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 标准输出是终端时color会在换行之后写入重置序列
+	reg := regexp.MustCompile(`^(?:\x1b\[0m)?(\x1b\[\d+m)\[[^]]+\] \[(\w+)\s*\] .* - goroutine \d+ message \d+$`)
+	out := strings.TrimSuffix(strings.TrimSuffix(buf.String(), "\x1b[0m"), "\n")
+	lines := strings.Split(out, "\n")
+	assert.Len(t, lines, goroutines*count)
+	for _, l := range lines {
+		m := reg.FindStringSubmatch(l)
+		if assert.NotNil(t, m, "interleaved log %q", l) {
+			assert.Equal(t, levelColors[m[2]], m[1], "wrong color for %q", l)
+		}
+	}
+}
+
+func TestConcurrentLoggerWith(t *testing.T) {
+	logger, read := fileLogger(t)
+
+	const goroutines, count = 8, 200
+	wg := sync.WaitGroup{}
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l := logger.With("worker", i)
+			for j := 0; j < count; j++ {
+				sl := l.With("seq", j)
+				sl.InfoKV("concurrent", "extra", strings.Repeat("x", j%7))
+			}
+		}(i)
+	}
+	wg.Wait()
+	logger.Clean()
+
+	lines := read()
+	assert.Len(t, lines, goroutines*count)
+	seen := make(map[string]bool)
+	reg := regexp.MustCompile(` - concurrent worker=(\d+) seq=(\d+) extra=(x*|"")$`)
+	for _, l := range lines {
+		m := reg.FindStringSubmatch(l)
+		if assert.NotNil(t, m, "broken log %q", l) {
+			seen[m[1]+"/"+m[2]] = true
+		}
+	}
+	assert.Len(t, seen, goroutines*count)
+}
+
+func TestConcurrentClean(t *testing.T) {
+	logger, read := fileLogger(t)
+	js := new(bytes.Buffer)
+	jlogger := ylog.NewLogger(ylog.NewJSONWriter(js, all))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logger.Info("goroutine %d message %d", i, j)
+				jlogger.InfoKV("message", "goroutine", i, "seq", j)
+			}
+		}(i)
+	}
+	logger.Clean()
+	jlogger.Clean()
+	wg.Wait()
+
+	// Clean之后的日志被忽略，已经写入的日志都是完整的
+	for _, l := range read() {
+		if l != "" {
+			assert.Regexp(t, ` - goroutine \d+ message \d+$`, l)
+		}
+	}
+	logger.Clean()
+}
+
+func benchmarkParallel(b *testing.B, logger ylog.Logger) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		l := logger.With("bench", "parallel")
+		i := 0
+		for pb.Next() {
+			l.InfoKV("parallel benchmark message", "seq", i)
+			i++
+		}
+	})
+	b.StopTimer()
+	logger.Clean()
+}
+
+func BenchmarkParallelConsoleWriter(b *testing.B) {
+	for _, colored := range []bool{false, true} {
+		b.Run(fmt.Sprintf("colored=%v", colored), func(b *testing.B) {
+			benchmarkParallel(b, ylog.NewLogger(ylog.NewConsoleWriterTo(io.Discard, all, colored)))
+		})
+	}
+}
+
+func BenchmarkParallelFileWriter(b *testing.B) {
+	w, err := ylog.NewFileWriter(b.TempDir(), "bench.log", all, 0, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkParallel(b, ylog.NewLogger(w))
+}
+
+func BenchmarkParallelJSONWriter(b *testing.B) {
+	benchmarkParallel(b, ylog.NewLogger(ylog.NewJSONWriter(io.Discard, all)))
+}
+
+func BenchmarkParallelAsyncFileWriter(b *testing.B) {
+	w, err := ylog.NewFileWriter(b.TempDir(), "bench.log", all, 0, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkParallel(b, ylog.NewLogger(ylog.NewAsyncWriter(w, ylog.AsyncOptions{})))
+}
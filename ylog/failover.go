@@ -0,0 +1,286 @@
+package ylog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriterState 输出目标的健康状态
+type WriterState int
+
+const (
+	WRITER_HEALTHY  WriterState = iota
+	WRITER_DEGRADED             // 最近一次写入失败，日志丢失或者写入了备用输出目标
+	WRITER_DISABLED             // 连续失败次数达到上限，不再写入
+)
+
+func (s WriterState) String() string {
+	switch s {
+	case WRITER_HEALTHY:
+		return "healthy"
+	case WRITER_DEGRADED:
+		return "degraded"
+	case WRITER_DISABLED:
+		return "disabled"
+	default:
+		return "unknown"
+	}
+}
+
+var ErrWriterDisabled = errors.New("the log writer is disabled after consecutive failures")
+
+// WriterHealth 输出目标的健康信息
+type WriterHealth struct {
+	Name        string // 文件日志为文件路径，其他为类型名称
+	State       WriterState
+	Failures    uint64 // 累计写入失败的次数，重试成功的不计入
+	Consecutive int    // 连续写入失败的次数
+	LastError   error
+	LastFailure time.Time
+	Fallbacks   uint64 // 转到备用输出目标的日志数量
+}
+
+// healthReporter 自己维护健康状态的输出目标，Logger不再为它记录状态
+type healthReporter interface {
+	health() WriterHealth
+}
+
+// wrapper 包装其他输出目标的输出目标，查找健康状态和名称时使用被包装的输出目标
+type wrapper interface {
+	unwrap() LogWriter
+}
+
+// reopener 可以重新打开的输出目标
+type reopener interface {
+	reopen() error
+}
+
+// findHealthReporter 沿着包装的输出目标查找自己维护健康状态的输出目标
+func findHealthReporter(w LogWriter) (healthReporter, bool) {
+	for w != nil {
+		if hr, ok := w.(healthReporter); ok {
+			return hr, true
+		}
+		u, ok := w.(wrapper)
+		if !ok {
+			break
+		}
+		w = u.unwrap()
+	}
+	return nil, false
+}
+
+// writerName 输出目标的名称
+func writerName(w LogWriter) string {
+	if n, ok := w.(interface{ label() string }); ok {
+		return n.label()
+	}
+	if u, ok := w.(wrapper); ok {
+		return writerName(u.unwrap())
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", w), "*ylog.")
+}
+
+// writerStatus Logger为没有实现healthReporter的输出目标记录的状态，错误只在开始失败和恢复时输出一次
+type writerStatus struct {
+	mu          sync.Mutex
+	failures    uint64
+	consecutive int
+	lastErr     error
+	lastFailure time.Time
+}
+
+func (s *writerStatus) record(w LogWriter, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		if s.consecutive > 0 {
+			Errorf("the log writer [%s] recovered after %d failures\n", writerName(w), s.consecutive)
+			s.consecutive = 0
+		}
+		return
+	}
+	if s.consecutive == 0 {
+		Errorf("failed to write log to [%s], further errors are suppressed until it recovers: %v\n", writerName(w), err)
+	}
+	s.failures++
+	s.consecutive++
+	s.lastErr = err
+	s.lastFailure = time.Now()
+}
+
+func (s *writerStatus) health(w LogWriter) WriterHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := WriterHealth{
+		Name:        writerName(w),
+		Failures:    s.failures,
+		Consecutive: s.consecutive,
+		LastError:   s.lastErr,
+		LastFailure: s.lastFailure,
+	}
+	if s.consecutive > 0 {
+		h.State = WRITER_DEGRADED
+	}
+	return h
+}
+
+// FailoverOptions 写入失败时的处理策略，零值表示不重试、没有备用输出目标、不停用
+type FailoverOptions struct {
+	Retries      int           // 失败之后重试的次数
+	RetryDelay   time.Duration // 每次重试之前等待的时间
+	Reopen       bool          // 重试之前重新打开被包装的输出目标，只对文件日志有效
+	Fallback     LogWriter     // 重试之后仍然失败或者停用时写入的备用输出目标，为nil时日志丢失
+	DisableAfter int           // 连续失败达到此次数之后停用，不大于0时不停用
+	RecoverAfter time.Duration // 停用之后每隔多久尝试写入一次，成功时恢复，不大于0时一直停用
+}
+
+// FailoverWriter 按照FailoverOptions处理被包装的输出目标的写入失败，并维护它的健康状态。
+// 写入了备用输出目标的日志不作为错误返回，错误只在开始失败、停用和恢复时输出一次
+type FailoverWriter struct {
+	inner LogWriter
+	opts  FailoverOptions
+
+	mu          sync.Mutex
+	failures    uint64
+	consecutive int
+	lastErr     error
+	lastFailure time.Time
+	disabled    bool
+	probed      time.Time
+	fallbacks   uint64
+}
+
+func NewFailoverWriter(inner LogWriter, opts FailoverOptions) *FailoverWriter {
+	return &FailoverWriter{inner: inner, opts: opts}
+}
+
+func (w *FailoverWriter) unwrap() LogWriter {
+	return w.inner
+}
+
+func (w *FailoverWriter) health() WriterHealth {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	h := WriterHealth{
+		Name:        writerName(w.inner),
+		Failures:    w.failures,
+		Consecutive: w.consecutive,
+		LastError:   w.lastErr,
+		LastFailure: w.lastFailure,
+		Fallbacks:   w.fallbacks,
+	}
+	switch {
+	case w.disabled:
+		h.State = WRITER_DISABLED
+	case w.consecutive > 0:
+		h.State = WRITER_DEGRADED
+	}
+	return h
+}
+
+// attempt 写入被包装的输出目标，失败时按照配置重新打开并重试，调用者需要持有锁
+func (w *FailoverWriter) attempt(write func(LogWriter) error) error {
+	err := write(w.inner)
+	for i := 0; err != nil && i < w.opts.Retries; i++ {
+		if w.opts.RetryDelay > 0 {
+			time.Sleep(w.opts.RetryDelay)
+		}
+		if r, ok := w.inner.(reopener); ok && w.opts.Reopen {
+			if rerr := r.reopen(); rerr != nil {
+				err = fmt.Errorf("failed to reopen: %w", rerr)
+				continue
+			}
+		}
+		err = write(w.inner)
+	}
+	return err
+}
+
+// do 写入一条日志，成功时返回nil
+func (w *FailoverWriter) do(write func(LogWriter) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	if w.disabled && (w.opts.RecoverAfter <= 0 || now.Sub(w.probed) < w.opts.RecoverAfter) {
+		return w.fallback(write, ErrWriterDisabled)
+	}
+
+	err := w.attempt(write)
+	if err == nil {
+		if w.consecutive > 0 {
+			Errorf("the log writer [%s] recovered after %d failures\n", writerName(w.inner), w.consecutive)
+		}
+		w.consecutive = 0
+		w.disabled = false
+		return nil
+	}
+
+	if w.consecutive == 0 {
+		Errorf("failed to write log to [%s], further errors are suppressed until it recovers: %v\n", writerName(w.inner), err)
+	}
+	w.failures++
+	w.consecutive++
+	w.lastErr = err
+	w.lastFailure = now
+	w.probed = now
+	if !w.disabled && w.opts.DisableAfter > 0 && w.consecutive >= w.opts.DisableAfter {
+		w.disabled = true
+		Errorf("the log writer [%s] is disabled after %d consecutive failures\n", writerName(w.inner), w.consecutive)
+	}
+	return w.fallback(write, err)
+}
+
+// fallback 写入备用输出目标，没有备用输出目标或者写入失败时返回err，调用者需要持有锁
+func (w *FailoverWriter) fallback(write func(LogWriter) error, err error) error {
+	if w.opts.Fallback == nil {
+		return err
+	}
+	if ferr := write(w.opts.Fallback); ferr != nil {
+		return fmt.Errorf("%w, and the fallback failed: %v", err, ferr)
+	}
+	w.fallbacks++
+	return nil
+}
+
+func (w *FailoverWriter) writeRecord(m LogMessage) error {
+	return w.do(func(lw LogWriter) error {
+		if lw != w.inner && !lw.enable(m.level) {
+			return nil
+		}
+		return lw.writeRecord(m)
+	})
+}
+
+func (w *FailoverWriter) enable(l int8) bool {
+	return w.inner.enable(l)
+}
+
+func (w *FailoverWriter) Write(p []byte) (int, error) {
+	err := w.do(func(lw LogWriter) error {
+		_, err := lw.Write(p)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *FailoverWriter) flush() {
+	w.inner.flush()
+	if w.opts.Fallback != nil {
+		w.opts.Fallback.flush()
+	}
+}
+
+func (w *FailoverWriter) clean() {
+	w.inner.clean()
+	if w.opts.Fallback != nil {
+		w.opts.Fallback.clean()
+	}
+}
@@ -0,0 +1,166 @@
+package ylog_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyWriter 可以控制是否写入失败的io.Writer
+type flakyWriter struct {
+	mu       sync.Mutex
+	fail     bool
+	attempts int
+	buf      bytes.Buffer
+}
+
+var errDiskFull = errors.New("no space left on device")
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.attempts++
+	if w.fail {
+		return 0, errDiskFull
+	}
+	return w.buf.Write(p)
+}
+
+func (w *flakyWriter) set(fail bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fail = fail
+}
+
+func (w *flakyWriter) stats() (int, string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.attempts, w.buf.String()
+}
+
+func TestLoggerHealth(t *testing.T) {
+	out := &flakyWriter{fail: true}
+	good := new(bytes.Buffer)
+	logger := ylog.NewLogger(ylog.NewConsoleWriterTo(out, all, false), ylog.NewConsoleWriterTo(good, all, false))
+
+	for i := 0; i < 3; i++ {
+		logger.Info("lost %d", i)
+	}
+	hs := logger.Health()
+	if assert.Len(t, hs, 2) {
+		assert.Equal(t, "ConsoleWriter", hs[0].Name)
+		assert.Equal(t, ylog.WRITER_DEGRADED, hs[0].State)
+		assert.Equal(t, uint64(3), hs[0].Failures)
+		assert.Equal(t, 3, hs[0].Consecutive)
+		assert.ErrorIs(t, hs[0].LastError, errDiskFull)
+		assert.False(t, hs[0].LastFailure.IsZero())
+		assert.Equal(t, ylog.WRITER_HEALTHY, hs[1].State)
+	}
+	assert.Len(t, logger.Degraded(), 1)
+
+	out.set(false)
+	logger.Info("written")
+	hs = logger.Health()
+	assert.Equal(t, ylog.WRITER_HEALTHY, hs[0].State)
+	assert.Equal(t, uint64(3), hs[0].Failures)
+	assert.Equal(t, 0, hs[0].Consecutive)
+	assert.Empty(t, logger.Degraded())
+	assert.Equal(t, "degraded", ylog.WRITER_DEGRADED.String())
+}
+
+func TestFailoverFallbackAndDisable(t *testing.T) {
+	out := &flakyWriter{fail: true}
+	fallback := new(bytes.Buffer)
+	fw := ylog.NewFailoverWriter(ylog.NewConsoleWriterTo(out, all, false), ylog.FailoverOptions{
+		Retries:      1,
+		Fallback:     ylog.NewConsoleWriterTo(fallback, func(l int8) bool { return l >= ylog.INFO }, false),
+		DisableAfter: 2,
+		RecoverAfter: 50 * time.Millisecond,
+	})
+	logger := ylog.NewLogger(fw)
+
+	logger.Info("first")
+	logger.Info("second")
+	logger.Info("third")
+	logger.Debug("below the fallback level")
+	attempts, _ := out.stats()
+	// 每条日志写入一次并重试一次，停用之后不再写入
+	assert.Equal(t, 4, attempts)
+	assert.Equal(t, 3, strings.Count(fallback.String(), "\n"))
+	assert.Contains(t, fallback.String(), "third")
+
+	hs := logger.Health()
+	if assert.Len(t, hs, 1) {
+		assert.Equal(t, ylog.WRITER_DISABLED, hs[0].State)
+		assert.Equal(t, uint64(2), hs[0].Failures)
+		assert.Equal(t, uint64(4), hs[0].Fallbacks)
+		assert.ErrorIs(t, hs[0].LastError, errDiskFull)
+	}
+
+	out.set(false)
+	logger.Info("still disabled")
+	attempts, _ = out.stats()
+	assert.Equal(t, 4, attempts)
+
+	time.Sleep(60 * time.Millisecond)
+	logger.Info("recovered")
+	_, text := out.stats()
+	assert.Contains(t, text, "recovered")
+	assert.NotContains(t, text, "still disabled")
+	assert.Equal(t, ylog.WRITER_HEALTHY, logger.Health()[0].State)
+}
+
+func TestFailoverWithoutFallback(t *testing.T) {
+	out := &flakyWriter{fail: true}
+	fw := ylog.NewFailoverWriter(ylog.NewConsoleWriterTo(out, all, false), ylog.FailoverOptions{DisableAfter: 1})
+	_, err := fw.Write([]byte("lost\n"))
+	assert.ErrorIs(t, err, errDiskFull)
+	_, err = fw.Write([]byte("lost again\n"))
+	assert.ErrorIs(t, err, ylog.ErrWriterDisabled)
+}
+
+func TestFailoverReopenFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	file, err := ylog.NewFileWriter(dir, "app.log", all, 0, nil)
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(ylog.NewFailoverWriter(file, ylog.FailoverOptions{Retries: 1, Reopen: true}))
+	logger.Info("before")
+
+	// 关闭文件并删除日志目录，重试之前重新打开
+	other := ylog.NewLogger(file)
+	other.Clean()
+	assert.NoError(t, os.RemoveAll(dir))
+	logger.Info("after")
+	logger.Clean()
+
+	bs, err := os.ReadFile(filepath.Join(dir, "app.log"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(bs), "after")
+	hs := logger.Health()
+	assert.Equal(t, ylog.WRITER_HEALTHY, hs[0].State)
+	assert.Equal(t, uint64(0), hs[0].Failures)
+	abs, _ := filepath.Abs(filepath.Join(dir, "app.log"))
+	assert.Equal(t, abs, hs[0].Name)
+}
+
+func TestAsyncWriterHealth(t *testing.T) {
+	out := &flakyWriter{fail: true}
+	aw := ylog.NewAsyncWriter(ylog.NewConsoleWriterTo(out, all, false), ylog.AsyncOptions{})
+	logger := ylog.NewLogger(aw)
+	logger.Info("lost")
+	aw.Flush()
+
+	hs := logger.Health()
+	assert.Equal(t, "ConsoleWriter", hs[0].Name)
+	assert.Equal(t, ylog.WRITER_DEGRADED, hs[0].State)
+	assert.Equal(t, uint64(1), hs[0].Failures)
+	logger.Clean()
+}
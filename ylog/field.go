@@ -0,0 +1,98 @@
+package ylog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BAD_KEY = "!BADKEY"
+)
+
+// Field 日志记录中的一个键值对字段
+type Field struct {
+	Key   string
+	Value any
+}
+
+// F 创建字段
+func F(key string, value any) Field {
+	return Field{key, value}
+}
+
+// toFields 将交替出现的键和值转换为字段，参数可以直接是Field；键不是字符串或者缺少值时，键为BAD_KEY
+func toFields(kv []any) []Field {
+	res := make([]Field, 0, len(kv)/2+1)
+	for i := 0; i < len(kv); {
+		switch k := kv[i].(type) {
+		case Field:
+			res = append(res, k)
+			i++
+		case string:
+			if i+1 >= len(kv) {
+				res = append(res, Field{BAD_KEY, k})
+				i++
+			} else {
+				res = append(res, Field{k, kv[i+1]})
+				i += 2
+			}
+		default:
+			res = append(res, Field{BAD_KEY, k})
+			i++
+		}
+	}
+	return res
+}
+
+// fieldString 字段值的文本形式
+func fieldString(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "<nil>"
+	case string:
+		return x
+	case error:
+		return x.Error()
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return x.String()
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// needQuote 值为空或包含空白、引号、等号时需要加引号，保证k=v可以被解析
+func needQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '"' || r == '=' || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// formatFields 将字段渲染为文本日志中以空格开头的k=v序列
+func formatFields(fields []Field) string {
+	if len(fields) == 0 {
+		return ""
+	}
+
+	sb := strings.Builder{}
+	for _, f := range fields {
+		sb.WriteByte(' ')
+		sb.WriteString(f.Key)
+		sb.WriteByte('=')
+		v := fieldString(f.Value)
+		if needQuote(v) {
+			v = strconv.Quote(v)
+		}
+		sb.WriteString(v)
+	}
+	return sb.String()
+}
@@ -0,0 +1,63 @@
+package ylog_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+// fileLogger 创建写入临时目录的Logger，返回读取日志内容的函数
+func fileLogger(t *testing.T) (ylog.Logger, func() []string) {
+	dir := t.TempDir()
+	file, err := ylog.NewFileWriter(dir, "kv.log", func(i int8) bool { return i >= ylog.TRACE }, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := ylog.NewLogger(file)
+	return logger, func() []string {
+		bs, err := os.ReadFile(filepath.Join(dir, "kv.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(string(bs)), "\n")
+	}
+}
+
+func TestKVLogger(t *testing.T) {
+	logger, lines := fileLogger(t)
+	defer logger.Clean()
+
+	logger.InfoKV("start to backup", "job", "42", "size", 1024, ylog.F("err", errors.New("disk full")))
+	logger.Info("plain %s", "message")
+	logger.WarnKV("odd", "key", "v", 7, "dangling")
+
+	ls := lines()
+	assert.Len(t, ls, 3)
+	assert.True(t, strings.HasSuffix(ls[0], `- start to backup job=42 size=1024 err="disk full"`), ls[0])
+	assert.Contains(t, ls[0], "field_test.go:")
+	assert.True(t, strings.HasSuffix(ls[1], "- plain message"), ls[1])
+	assert.True(t, strings.HasSuffix(ls[2], `- odd key=v !BADKEY=7 !BADKEY=dangling`), ls[2])
+}
+
+func TestWith(t *testing.T) {
+	logger, lines := fileLogger(t)
+	defer logger.Clean()
+
+	job := logger.With("job", "42", "app", "orcl")
+	step := job.With("phase", "backup")
+	step.InfoKV("done", "quoted", "a=b", "empty", "")
+	job.Error("failed: %d", 1)
+	logger.Info("unbound")
+
+	ls := lines()
+	assert.Len(t, ls, 3)
+	assert.True(t, strings.HasSuffix(ls[0], `- done job=42 app=orcl phase=backup quoted="a=b" empty=""`), ls[0])
+	assert.True(t, strings.HasSuffix(ls[1], "- failed: 1 job=42 app=orcl"), ls[1])
+	assert.True(t, strings.HasSuffix(ls[2], "- unbound"), ls[2])
+	assert.Len(t, job.Fields(), 2, "deriving should not modify the parent")
+}
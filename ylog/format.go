@@ -0,0 +1,261 @@
+package ylog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// LOG_TEMPLATE 默认的日志模板，没有调用栈时与LOG_MSG_FORMAT的格式相同
+	LOG_TEMPLATE = "[{time}] [{level}] [{caller}] - {msg}{fields}{stack}"
+)
+
+// Formatter 将日志记录渲染为一行文本，结果需要以换行结尾
+type Formatter interface {
+	Format(m LogMessage) []byte
+}
+
+// DefaultTemplate 使用LOG_TEMPLATE的模板，没有设置Formatter的输出目标都使用此格式
+var DefaultTemplate = MustParseTemplate(LOG_TEMPLATE)
+
+// captureGoroutine 有模板使用{gid}之后才在生成日志记录时获取goroutine ID，避免每条日志都读取栈
+var captureGoroutine atomic.Bool
+
+// goroutineID 从当前goroutine的栈信息中解析ID
+func goroutineID() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i > 0 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
+
+type segment = func(b *strings.Builder, m *LogMessage)
+
+// Template 由文本和占位符组成的日志模板，占位符为 {名称} 或者 {名称|参数|参数}，{{和}}表示花括号本身：
+//
+//	{time|layout|zone}  时间，layout默认为LOG_TIME_FMT，zone可以是UTC、Local或者IANA时区名称，默认为本地时区
+//	{level|width}       级别名称，默认补齐到5个字符，width为0时不补齐
+//	{caller|depth}      调用者的 文件:行号，文件保留depth级路径，默认为2
+//	{file|depth}        调用者的文件，{line}为行号
+//	{func|short}        调用者的函数全名，short时去掉包的路径
+//	{pid}               进程ID
+//	{gid}               记录日志的goroutine ID
+//	{msg}               日志消息
+//	{fields}            以空格开头的k=v字段，没有字段时为空
+//	{stack}             附加的调用栈，每一帧为换行之后以STACK_PREFIX开头的一行，没有调用栈时为空
+type Template struct {
+	src  string
+	segs []segment
+}
+
+func MustParseTemplate(s string) *Template {
+	t, err := ParseTemplate(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func ParseTemplate(s string) (*Template, error) {
+	t := &Template{src: s}
+	lit := strings.Builder{}
+	flush := func() {
+		if lit.Len() > 0 {
+			text := lit.String()
+			t.segs = append(t.segs, func(b *strings.Builder, m *LogMessage) { b.WriteString(text) })
+			lit.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '{' && i+1 < len(s) && s[i+1] == '{':
+			lit.WriteByte('{')
+			i++
+		case c == '}' && i+1 < len(s) && s[i+1] == '}':
+			lit.WriteByte('}')
+			i++
+		case c == '}':
+			return nil, fmt.Errorf("unexpected } at %d in the log template [%s]", i, s)
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed placeholder at %d in the log template [%s]", i, s)
+			}
+			seg, err := placeholder(strings.Split(s[i+1:i+end], "|"))
+			if err != nil {
+				return nil, fmt.Errorf("%w in the log template [%s]", err, s)
+			}
+			flush()
+			t.segs = append(t.segs, seg)
+			i += end
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	flush()
+	return t, nil
+}
+
+func arg(args []string, i int, def string) string {
+	if i < len(args) && args[i] != "" {
+		return args[i]
+	}
+	return def
+}
+
+func intArg(args []string, i int, def int) (int, error) {
+	v := arg(args, i, "")
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("illegal argument [%s] of {%s}", v, args[0])
+	}
+	return n, nil
+}
+
+// placeholder 生成占位符对应的片段，args[0]为名称
+func placeholder(args []string) (segment, error) {
+	switch args[0] {
+	case "time":
+		layout := arg(args, 1, LOG_TIME_FMT)
+		var loc *time.Location
+		if z := arg(args, 2, ""); z != "" {
+			l, err := time.LoadLocation(z)
+			if err != nil {
+				return nil, fmt.Errorf("unknown time zone [%s]", z)
+			}
+			loc = l
+		}
+		return func(b *strings.Builder, m *LogMessage) {
+			t := m.time
+			if loc != nil {
+				t = t.In(loc)
+			}
+			b.WriteString(t.Format(layout))
+		}, nil
+	case "level":
+		width, err := intArg(args, 1, 5)
+		if err != nil {
+			return nil, err
+		}
+		return func(b *strings.Builder, m *LogMessage) {
+			l := parseLogLevel(int(m.level))
+			b.WriteString(l)
+			for i := len(l); i < width; i++ {
+				b.WriteByte(' ')
+			}
+		}, nil
+	case "caller", "file":
+		depth, err := intArg(args, 1, 2)
+		if err != nil {
+			return nil, err
+		}
+		withLine := args[0] == "caller"
+		return func(b *strings.Builder, m *LogMessage) {
+			b.WriteString(SubPath(m.file, depth))
+			if withLine {
+				b.WriteByte(':')
+				b.WriteString(strconv.Itoa(m.line))
+			}
+		}, nil
+	case "line":
+		return func(b *strings.Builder, m *LogMessage) { b.WriteString(strconv.Itoa(m.line)) }, nil
+	case "func":
+		short := arg(args, 1, "") == "short"
+		return func(b *strings.Builder, m *LogMessage) {
+			name := m.function
+			if short {
+				name = name[strings.LastIndexByte(name, '/')+1:]
+			}
+			b.WriteString(name)
+		}, nil
+	case "pid":
+		pid := strconv.Itoa(os.Getpid())
+		return func(b *strings.Builder, m *LogMessage) { b.WriteString(pid) }, nil
+	case "gid":
+		captureGoroutine.Store(true)
+		return func(b *strings.Builder, m *LogMessage) { b.WriteString(strconv.FormatUint(m.goroutine, 10)) }, nil
+	case "msg":
+		return func(b *strings.Builder, m *LogMessage) { b.WriteString(m.text) }, nil
+	case "fields":
+		return func(b *strings.Builder, m *LogMessage) { b.WriteString(formatFields(m.fields)) }, nil
+	case "stack":
+		return func(b *strings.Builder, m *LogMessage) { formatStack(b, m.stack) }, nil
+	default:
+		return nil, fmt.Errorf("unknown placeholder {%s}", strings.Join(args, "|"))
+	}
+}
+
+func (t *Template) String() string {
+	return t.src
+}
+
+// Format 按照模板渲染日志记录，以当前平台的换行结尾
+func (t *Template) Format(m LogMessage) []byte {
+	b := strings.Builder{}
+	for _, seg := range t.segs {
+		seg(&b, &m)
+	}
+	if runtime.GOOS == "windows" {
+		b.WriteString("\r\n")
+	} else {
+		b.WriteString("\n")
+	}
+	return []byte(b.String())
+}
+
+// render 使用formatter渲染日志记录，formatter为nil时使用生成记录时按照DefaultTemplate渲染的文本
+func render(formatter Formatter, m LogMessage) []byte {
+	if formatter == nil {
+		return []byte(m.msg)
+	}
+	return formatter.Format(m)
+}
+
+// Level 日志记录的级别
+func (m LogMessage) Level() int8 {
+	return m.level
+}
+
+func (m LogMessage) Time() time.Time {
+	return m.time
+}
+
+// Caller 调用者的文件和行号
+func (m LogMessage) Caller() (string, int) {
+	return m.file, m.line
+}
+
+// Function 调用者的函数全名
+func (m LogMessage) Function() string {
+	return m.function
+}
+
+// Text 日志消息，不包含时间、级别等前缀
+func (m LogMessage) Text() string {
+	return m.text
+}
+
+// Fields 日志记录的字段
+func (m LogMessage) Fields() []Field {
+	return append([]Field(nil), m.fields...)
+}
+
+// Goroutine 记录日志的goroutine ID，没有模板使用{gid}时为0
+func (m LogMessage) Goroutine() uint64 {
+	return m.goroutine
+}
@@ -0,0 +1,66 @@
+package ylog_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, s := range []string{"{msg", "msg}", "{unknown}", "{level|x}", "{caller|-1}", "{time|2006|Mars/Base}"} {
+		_, err := ylog.ParseTemplate(s)
+		assert.Error(t, err, s)
+	}
+	tpl, err := ylog.ParseTemplate("{{literal}} {msg}")
+	assert.NoError(t, err)
+	assert.Equal(t, "{{literal}} {msg}", tpl.String())
+}
+
+func TestDefaultTemplate(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := ylog.NewConsoleWriterTo(buf, all, false)
+	logger := ylog.NewLogger(w)
+	logger.InfoKV("same as before", "k", "v")
+	plain := buf.String()
+
+	buf.Reset()
+	w.SetFormatter(ylog.MustParseTemplate(ylog.LOG_TEMPLATE))
+	logger.InfoKV("same as before", "k", "v")
+	assert.Regexp(t, `^\[\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\] \[INFO \] \[ylog/format_test\.go:\d+\] - same as before k=v\n$`, plain)
+	assert.Regexp(t, `^\[\d{4}-\d\d-\d\d \d\d:\d\d:\d\d\] \[INFO \] \[ylog/format_test\.go:\d+\] - same as before k=v\n$`, buf.String())
+}
+
+func TestTemplatePlaceholders(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := ylog.NewConsoleWriterTo(buf, all, false)
+	w.SetFormatter(ylog.MustParseTemplate("{time|2006-01-02T15:04:05Z07:00|UTC} {level|0} {caller|1} {file|3}#{line} {func|short} {pid} {gid} {{{msg}}}{fields}"))
+	logger := ylog.NewLogger(w)
+	logger.WarnKV("custom", "n", 1)
+
+	pid := strconv.Itoa(os.Getpid())
+	assert.Regexp(t, `^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ WARN format_test\.go:\d+ [^/ ]+/ylog/format_test\.go#\d+ ylog_test\.TestTemplatePlaceholders `+pid+` [1-9]\d* \{custom\} n=1\n$`, buf.String())
+}
+
+func TestWritersWithDifferentTemplates(t *testing.T) {
+	dir := t.TempDir()
+	buf := new(bytes.Buffer)
+	console := ylog.NewConsoleWriterTo(buf, all, false)
+	console.SetFormatter(ylog.MustParseTemplate("{level} {msg}"))
+	file, err := ylog.NewFileWriter(dir, "app.log", all, 0, nil)
+	assert.NoError(t, err)
+	file.SetFormatter(ylog.MustParseTemplate("{time|2006-01-02T15:04:05.000000} {pid} [{level|0}] {caller|1} {msg}{fields}"))
+	logger := ylog.NewLogger(console, file)
+
+	logger.ErrorKV("disk full", "path", "/data")
+	logger.Clean()
+
+	assert.Equal(t, "ERROR disk full\n", buf.String())
+	bs, err := os.ReadFile(filepath.Join(dir, "app.log"))
+	assert.NoError(t, err)
+	assert.Regexp(t, `^\S+ \d+ \[ERROR\] format_test\.go:\d+ disk full path=/data\n$`, string(bs))
+}
@@ -0,0 +1,148 @@
+package ylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	LOG_JSON_TIME_FMT = "2006-01-02T15:04:05.000000000Z07:00"
+)
+
+// JSONWriter 每条日志输出为一行json对象，包含时间、级别、调用者、消息和字段，便于日志系统索引。
+// 输出目标为LogWriter（例如FileWriter）时，清理JSONWriter会同时清理输出目标
+type JSONWriter struct {
+	out         io.Writer
+	levelFilter LevelFilter
+	mu          sync.Mutex
+}
+
+type jsonCaller struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Function string `json:"function,omitempty"`
+}
+
+type jsonRecord struct {
+	Time    string          `json:"time"`
+	Level   string          `json:"level"`
+	Caller  jsonCaller      `json:"caller"`
+	Message string          `json:"msg"`
+	Fields  json.RawMessage `json:"fields,omitempty"`
+	Stack   []jsonCaller    `json:"stack,omitempty"`
+}
+
+func NewJSONWriter(out io.Writer, level LevelFilter) *JSONWriter {
+	return &JSONWriter{
+		out:         out,
+		levelFilter: level,
+	}
+}
+
+// NewJSONFileWriter 创建写入日志文件的JSONWriter，日志文件的过期删除和归档与NewFileWriter相同
+func NewJSONFileWriter(logPath string, filename string, level LevelFilter, expire time.Duration, archive Archive) (*JSONWriter, error) {
+	file, err := NewFileWriter(logPath, filename, level, expire, archive)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONWriter(file, level), nil
+}
+
+// fieldJSON 字段值的json形式，error使用错误信息，无法序列化的值使用文本形式
+func fieldJSON(v any) []byte {
+	switch x := v.(type) {
+	case error:
+		v = x.Error()
+	case time.Time:
+		v = x.Format(LOG_JSON_TIME_FMT)
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		bs, _ = json.Marshal(fieldString(v))
+	}
+	return bs
+}
+
+// encodeFields 按照字段的顺序生成json对象
+func encodeFields(fields []Field) json.RawMessage {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(f.Key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(fieldJSON(f.Value))
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// encodeStack 调用栈的每一帧与调用者的格式相同
+func encodeStack(stack []Frame) []jsonCaller {
+	if len(stack) == 0 {
+		return nil
+	}
+	res := make([]jsonCaller, 0, len(stack))
+	for _, f := range stack {
+		res = append(res, jsonCaller{f.File, f.Line, f.Function})
+	}
+	return res
+}
+
+// encodeRecord 将日志记录转换为以换行结尾的json对象
+func encodeRecord(m LogMessage) ([]byte, error) {
+	bs, err := json.Marshal(jsonRecord{
+		Time:    m.time.Format(LOG_JSON_TIME_FMT),
+		Level:   parseLogLevel(int(m.level)),
+		Caller:  jsonCaller{m.file, m.line, m.function},
+		Message: m.text,
+		Fields:  encodeFields(m.fields),
+		Stack:   encodeStack(m.stack),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(bs, '\n'), nil
+}
+
+func (w *JSONWriter) writeRecord(m LogMessage) error {
+	bs, err := encodeRecord(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bs)
+	return err
+}
+
+func (w *JSONWriter) enable(l int8) bool {
+	return w.levelFilter(l)
+}
+
+// Write 将已经编码的内容原样写入输出目标
+func (w *JSONWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Write(p)
+}
+
+func (w *JSONWriter) flush() {
+	if lw, ok := w.out.(LogWriter); ok {
+		lw.flush()
+	}
+}
+
+func (w *JSONWriter) clean() {
+	if lw, ok := w.out.(LogWriter); ok {
+		lw.clean()
+	}
+}
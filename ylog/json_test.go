@@ -0,0 +1,92 @@
+package ylog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+type jsonLine struct {
+	Time   string `json:"time"`
+	Level  string `json:"level"`
+	Caller struct {
+		File     string `json:"file"`
+		Line     int    `json:"line"`
+		Function string `json:"function"`
+	} `json:"caller"`
+	Msg    string         `json:"msg"`
+	Fields map[string]any `json:"fields"`
+}
+
+func decodeLines(t *testing.T, s string) []jsonLine {
+	res := make([]jsonLine, 0)
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		var jl jsonLine
+		if err := json.Unmarshal([]byte(l), &jl); err != nil {
+			t.Fatalf("invalid json line %q: %v", l, err)
+		}
+		res = append(res, jl)
+	}
+	return res
+}
+
+func TestJSONWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	dir := t.TempDir()
+	file, err := ylog.NewFileWriter(dir, "text.log", func(i int8) bool { return i >= ylog.TRACE }, 0, nil)
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(ylog.NewJSONWriter(buf, func(i int8) bool { return i >= ylog.INFO }), file)
+	defer logger.Clean()
+
+	job := logger.With("job", "42")
+	job.Debug("filtered")
+	job.InfoKV("backup finished", "bytes", 1024, "err", errors.New("partial"), "ok", true)
+	job.Warn("retry %d", 3)
+
+	lines := decodeLines(t, buf.String())
+	assert.Len(t, lines, 2)
+
+	l := lines[0]
+	ts, err := time.Parse(time.RFC3339Nano, l.Time)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), ts, time.Minute)
+	assert.Equal(t, "INFO", l.Level)
+	assert.Equal(t, "json_test.go", filepath.Base(l.Caller.File))
+	assert.NotZero(t, l.Caller.Line)
+	assert.True(t, strings.HasSuffix(l.Caller.Function, "TestJSONWriter"), l.Caller.Function)
+	assert.Equal(t, "backup finished", l.Msg)
+	assert.Equal(t, map[string]any{"job": "42", "bytes": float64(1024), "err": "partial", "ok": true}, l.Fields)
+
+	assert.Equal(t, "WARN", lines[1].Level)
+	assert.Equal(t, "retry 3", lines[1].Msg)
+	assert.Equal(t, map[string]any{"job": "42"}, lines[1].Fields)
+
+	bs, err := os.ReadFile(filepath.Join(dir, "text.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(bs), "\n"), "text writer should keep receiving rendered lines")
+	assert.Contains(t, string(bs), "- backup finished job=42 bytes=1024 err=partial ok=true")
+}
+
+func TestJSONFileWriter(t *testing.T) {
+	dir := t.TempDir()
+	w, err := ylog.NewJSONFileWriter(dir, "json.log", func(i int8) bool { return i >= ylog.TRACE }, 0, nil)
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+	logger.TraceKV("unmarshalable", "ch", make(chan int))
+	logger.Clean()
+
+	bs, err := os.ReadFile(filepath.Join(dir, "json.log"))
+	assert.NoError(t, err)
+	lines := decodeLines(t, string(bs))
+	assert.Len(t, lines, 1)
+	assert.Equal(t, "TRACE", lines[0].Level)
+	assert.IsType(t, "", lines[0].Fields["ch"], "unmarshalable value should fall back to text")
+}
@@ -0,0 +1,237 @@
+package ylog
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	LEVEL_WATCH_INTERVAL = 2 * time.Second
+)
+
+// ParseLevel 解析级别名称（不区分大小写）或者级别的数字
+func ParseLevel(s string) (int8, error) {
+	s = strings.TrimSpace(s)
+	for l := TRACE; l <= FATAL; l++ {
+		if strings.EqualFold(s, parseLogLevel(l)) {
+			return int8(l), nil
+		}
+	}
+	if strings.EqualFold(s, "WARNING") {
+		return WARN, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= TRACE && n <= FATAL {
+		return int8(n), nil
+	}
+	return 0, fmt.Errorf("unknown log level [%s]", s)
+}
+
+// LevelVar 可以在运行时原子修改的最低日志级别，通过Filter作为输出目标的LevelFilter
+type LevelVar struct {
+	level atomic.Int32
+}
+
+func NewLevelVar(level int8) *LevelVar {
+	v := new(LevelVar)
+	v.Set(level)
+	return v
+}
+
+func (v *LevelVar) Level() int8 {
+	return int8(v.level.Load())
+}
+
+func (v *LevelVar) Set(level int8) {
+	v.level.Store(int32(level))
+}
+
+// Enabled 级别l是否不低于当前级别，方法值可以直接作为LevelFilter
+func (v *LevelVar) Enabled(l int8) bool {
+	return l >= v.Level()
+}
+
+// Filter 返回不低于当前级别的LevelFilter，级别修改之后立即生效
+func (v *LevelVar) Filter() LevelFilter {
+	return v.Enabled
+}
+
+// LevelControl 根据环境变量和控制文件设置已登记的LevelVar，可以在收到SIGHUP或者控制文件变化时重新加载。
+// 环境变量Env设置所有级别，Env_名称（名称为大写）设置单个级别；控制文件的每一行为 级别 或者 名称=级别，
+// 以#开头的行为注释。控制文件优先于环境变量，单个级别优先于所有级别，都没有设置时恢复为登记时的级别
+type LevelControl struct {
+	Env      string
+	File     string
+	Interval time.Duration // 检查控制文件变化的周期，不大于0时使用LEVEL_WATCH_INTERVAL
+
+	mu       sync.Mutex
+	vars     map[string]*LevelVar
+	defaults map[string]int8
+}
+
+func NewLevelControl(env, file string) *LevelControl {
+	return &LevelControl{
+		Env:      env,
+		File:     file,
+		vars:     make(map[string]*LevelVar),
+		defaults: make(map[string]int8),
+	}
+}
+
+// Register 登记名称为name的级别，当前级别作为没有配置时的默认级别，并立即按照当前配置设置级别
+func (c *LevelControl) Register(name string, v *LevelVar) error {
+	c.mu.Lock()
+	c.vars[name] = v
+	c.defaults[name] = v.Level()
+	c.mu.Unlock()
+	return c.Reload()
+}
+
+// parseLevels 解析控制文件，键为级别的名称，空字符串表示所有级别
+func parseLevels(path string) (map[string]int8, error) {
+	res := make(map[string]int8)
+	if path == "" {
+		return res, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return res, err
+	}
+	defer f.Close()
+
+	var perr error
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value := "", line
+		if i := strings.IndexByte(line, '='); i >= 0 {
+			name, value = strings.TrimSpace(line[:i]), line[i+1:]
+		}
+		l, err := ParseLevel(value)
+		if err != nil {
+			if perr == nil {
+				perr = fmt.Errorf("%s: %w", path, err)
+			}
+			continue
+		}
+		res[name] = l
+	}
+	if err := scanner.Err(); err != nil {
+		return res, err
+	}
+	return res, perr
+}
+
+// Reload 重新读取环境变量和控制文件并设置所有登记的级别，配置有误时返回第一个错误，其余正确的配置仍然生效
+func (c *LevelControl) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var rerr error
+	env := func(key string) (int8, bool) {
+		s := os.Getenv(key)
+		if key == "" || s == "" {
+			return 0, false
+		}
+		l, err := ParseLevel(s)
+		if err != nil {
+			if rerr == nil {
+				rerr = fmt.Errorf("%s: %w", key, err)
+			}
+			return 0, false
+		}
+		return l, true
+	}
+	file, err := parseLevels(c.File)
+	if err != nil {
+		rerr = err
+	}
+
+	for name, v := range c.vars {
+		if l, ok := file[name]; ok {
+			v.Set(l)
+		} else if l, ok := file[""]; ok {
+			v.Set(l)
+		} else if l, ok := env(c.Env + "_" + strings.ToUpper(name)); ok {
+			v.Set(l)
+		} else if l, ok := env(c.Env); ok {
+			v.Set(l)
+		} else {
+			v.Set(c.defaults[name])
+		}
+	}
+	return rerr
+}
+
+func (c *LevelControl) interval() time.Duration {
+	if c.Interval > 0 {
+		return c.Interval
+	}
+	return LEVEL_WATCH_INTERVAL
+}
+
+// fileStamp 控制文件的修改时间和大小，用于判断文件是否变化，文件不存在时为空
+func (c *LevelControl) fileStamp() string {
+	if c.File == "" {
+		return ""
+	}
+	fi, err := os.Stat(c.File)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size())
+}
+
+// Watch 在后台等待SIGHUP并定期检查控制文件，收到信号或者控制文件变化（包括删除）时重新加载，返回停止的函数。
+// 监听期间SIGHUP不会再终止进程
+func (c *LevelControl) Watch() func() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	done := make(chan struct{})
+	reload := func() {
+		if err := c.Reload(); err != nil {
+			Errorf("failed to reload the log levels: %v\n", err)
+		}
+	}
+
+	last := c.fileStamp()
+	go func() {
+		ticker := time.NewTicker(c.interval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-sig:
+				last = c.fileStamp()
+				reload()
+			case <-ticker.C:
+				if s := c.fileStamp(); s != last {
+					last = s
+					reload()
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sig)
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux || darwin || aix
+// +build linux darwin aix
+
+package ylog_test
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevelControlSIGHUP(t *testing.T) {
+	c := ylog.NewLevelControl("TEST_LOG_LEVEL", "")
+	c.Interval = time.Hour
+	lv := ylog.NewLevelVar(ylog.INFO)
+	assert.NoError(t, c.Register("console", lv))
+
+	stop := c.Watch()
+	defer stop()
+
+	t.Setenv("TEST_LOG_LEVEL", "trace")
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	assert.Eventually(t, func() bool { return lv.Level() == ylog.TRACE }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,84 @@
+package ylog_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLevel(t *testing.T) {
+	for s, l := range map[string]int8{"trace": ylog.TRACE, "DEBUG": ylog.DEBUG, " Info ": ylog.INFO, "warning": ylog.WARN, "4": ylog.ERROR, "fatal": ylog.FATAL} {
+		got, err := ylog.ParseLevel(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, l, got, s)
+	}
+	for _, s := range []string{"", "verbose", "6", "-1"} {
+		_, err := ylog.ParseLevel(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestLevelVarFilter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	lv := ylog.NewLevelVar(ylog.INFO)
+	logger := ylog.NewLogger(ylog.NewConsoleWriterTo(buf, lv.Filter(), false))
+
+	logger.Debug("hidden")
+	lv.Set(ylog.TRACE)
+	logger.Trace("shown")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "shown")
+}
+
+func TestLevelControlReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "levels")
+	c := ylog.NewLevelControl("TEST_LOG_LEVEL", file)
+	console, job := ylog.NewLevelVar(ylog.DEBUG), ylog.NewLevelVar(ylog.INFO)
+
+	t.Setenv("TEST_LOG_LEVEL", "warn")
+	t.Setenv("TEST_LOG_LEVEL_JOB", "error")
+	assert.NoError(t, c.Register("console", console))
+	assert.NoError(t, c.Register("job", job))
+	assert.Equal(t, int8(ylog.WARN), console.Level())
+	assert.Equal(t, int8(ylog.ERROR), job.Level(), "a single level takes precedence over all levels")
+
+	// 控制文件优先于环境变量
+	assert.NoError(t, os.WriteFile(file, []byte("# turn on trace\ntrace\nconsole = info\n"), 0600))
+	assert.NoError(t, c.Reload())
+	assert.Equal(t, int8(ylog.INFO), console.Level())
+	assert.Equal(t, int8(ylog.TRACE), job.Level())
+
+	assert.NoError(t, os.WriteFile(file, []byte("job=loud\nconsole=error\n"), 0600))
+	assert.Error(t, c.Reload())
+	assert.Equal(t, int8(ylog.ERROR), console.Level(), "valid lines still take effect")
+	assert.Equal(t, int8(ylog.ERROR), job.Level())
+
+	// 没有任何配置时恢复为登记时的级别
+	assert.NoError(t, os.Remove(file))
+	os.Unsetenv("TEST_LOG_LEVEL")
+	os.Unsetenv("TEST_LOG_LEVEL_JOB")
+	assert.NoError(t, c.Reload())
+	assert.Equal(t, int8(ylog.DEBUG), console.Level())
+	assert.Equal(t, int8(ylog.INFO), job.Level())
+}
+
+func TestLevelControlWatchFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "levels")
+	c := ylog.NewLevelControl("", file)
+	c.Interval = 10 * time.Millisecond
+	lv := ylog.NewLevelVar(ylog.INFO)
+	assert.NoError(t, c.Register("file", lv))
+
+	stop := c.Watch()
+	defer stop()
+
+	assert.NoError(t, os.WriteFile(file, []byte("file=trace\n"), 0600))
+	assert.Eventually(t, func() bool { return lv.Level() == ylog.TRACE }, time.Second, 10*time.Millisecond)
+	assert.NoError(t, os.Remove(file))
+	assert.Eventually(t, func() bool { return lv.Level() == ylog.INFO }, time.Second, 10*time.Millisecond)
+}
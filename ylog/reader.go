@@ -0,0 +1,381 @@
+package ylog
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FOLLOW_INTERVAL = 500 * time.Millisecond
+)
+
+// recordHeader 按照LOG_MSG_FORMAT输出的日志的第一行，不以此开头的行属于上一条日志
+var recordHeader = regexp.MustCompile(`^\[(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d)\] \[(TRACE|DEBUG|INFO |WARN |ERROR|FATAL)\] \[(.*):(\d+)\] - (.*)$`)
+
+// fieldTail 日志末尾的一个k=v字段，值为空或者包含空白、引号、等号时带引号
+var fieldTail = regexp.MustCompile(` ([^\s="]+)=("(?:[^"\\]|\\.)*"|[^\s="]+)$`)
+
+// Record 从日志文件中解析出的一条日志，多行的日志合并为一条，以STACK_PREFIX开头的行为调用栈。
+// 字段从消息末尾的k=v中解析，值都为字符串，消息本身以k=v结尾时也会被当作字段
+type Record struct {
+	Time   time.Time
+	Level  int8
+	File   string // 日志中记录的调用者文件，一般为两级路径
+	Line   int
+	Text   string
+	Fields []Field
+	Stack  []string // 调用栈的每一帧，去掉了STACK_PREFIX
+	Source string   // 日志文件的路径，打包文件中的日志为 包的路径/日志名称
+	Raw    string   // 日志的原始文本，不包含最后的换行
+}
+
+// Field 返回名称为key的字段的值
+func (r Record) Field(key string) (string, bool) {
+	for _, f := range r.Fields {
+		if f.Key == key {
+			s, _ := f.Value.(string)
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// splitFields 从消息末尾解析字段
+func splitFields(text string) (string, []Field) {
+	fields := make([]Field, 0)
+	for {
+		m := fieldTail.FindStringSubmatchIndex(text)
+		if m == nil {
+			break
+		}
+		v := text[m[4]:m[5]]
+		if strings.HasPrefix(v, `"`) {
+			uq, err := strconv.Unquote(v)
+			if err != nil {
+				break
+			}
+			v = uq
+		}
+		fields = append([]Field{{text[m[2]:m[3]], v}}, fields...)
+		text = text[:m[0]]
+	}
+	return text, fields
+}
+
+// recordParser 逐行解析日志，遇到下一条日志的第一行或者flush时输出上一条日志
+type recordParser struct {
+	source string
+	cur    *Record
+	emit   func(Record) bool
+	done   bool
+}
+
+func (p *recordParser) line(s string) {
+	s = strings.TrimRight(s, "\r\n")
+	gs := recordHeader.FindStringSubmatch(s)
+	if gs == nil {
+		if p.cur == nil {
+			return
+		}
+		if strings.HasPrefix(s, STACK_PREFIX) {
+			p.cur.Stack = append(p.cur.Stack, strings.TrimPrefix(s, STACK_PREFIX))
+		} else {
+			p.cur.Text += "\n" + s
+		}
+		p.cur.Raw += "\n" + s
+		return
+	}
+
+	p.flush()
+	t, _ := time.ParseInLocation(LOG_TIME_FMT, gs[1], time.Local)
+	l, _ := ParseLevel(gs[2])
+	ln, _ := strconv.Atoi(gs[4])
+	p.cur = &Record{Time: t, Level: l, File: gs[3], Line: ln, Text: gs[5], Source: p.source, Raw: s}
+}
+
+func (p *recordParser) flush() {
+	if p.cur == nil {
+		return
+	}
+	r := *p.cur
+	p.cur = nil
+	r.Text, r.Fields = splitFields(r.Text)
+	if !p.done && !p.emit(r) {
+		p.done = true
+	}
+}
+
+// ParseRecords 解析r中的日志，对每条日志调用fn，fn返回false时停止
+func ParseRecords(r io.Reader, source string, fn func(Record) bool) error {
+	p := &recordParser{source: source, emit: fn}
+	br := bufio.NewReader(r)
+	for !p.done {
+		s, err := br.ReadString('\n')
+		if s != "" {
+			p.line(s)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	p.flush()
+	return nil
+}
+
+// ReadLogFile 解析日志文件，支持未压缩的日志、单独gzip压缩的日志和tar.gz打包的日志
+func ReadLogFile(path string, fn func(Record) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch {
+	case strings.HasSuffix(path, BUNDLE_EXT):
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		tr := tar.NewReader(gr)
+		stopped := false
+		for !stopped {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = ParseRecords(tr, path+"/"+hdr.Name, func(r Record) bool {
+				if !fn(r) {
+					stopped = true
+				}
+				return !stopped
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case strings.HasSuffix(path, GzipCompressor{}.Ext()):
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		return ParseRecords(gr, path, fn)
+	default:
+		return ParseRecords(f, path, fn)
+	}
+}
+
+// LogFiles 列出日志目录和一级归档目录中名称满足match的文件，按照修改时间排序，match为nil时列出所有文件。
+// 以.开头的文件（锁文件和临时文件）会被忽略
+func LogFiles(dir string, match func(name string) bool) ([]string, error) {
+	type file struct {
+		path string
+		mod  time.Time
+	}
+	files := make([]file, 0)
+	root := filepath.Clean(dir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if filepath.Dir(path) == root {
+				return nil
+			}
+			return filepath.SkipDir
+		}
+		if match != nil && !match(d.Name()) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, file{path, fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].mod.Before(files[j].mod)
+	})
+	res := make([]string, 0, len(files))
+	for _, f := range files {
+		res = append(res, f.path)
+	}
+	return res, nil
+}
+
+// ReadLogs 读取多个日志文件中满足filter的日志，按照时间合并，同一时间的日志保持文件和文件内的顺序，filter为nil时读取全部日志
+func ReadLogs(paths []string, filter func(Record) bool) ([]Record, error) {
+	res := make([]Record, 0)
+	for _, p := range paths {
+		err := ReadLogFile(p, func(r Record) bool {
+			if filter == nil || filter(r) {
+				res = append(res, r)
+			}
+			return true
+		})
+		if err != nil {
+			return res, err
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Time.Before(res[j].Time)
+	})
+	return res, nil
+}
+
+// newestLogFile 日志目录（不包括归档目录）中最新修改的文件
+func newestLogFile(dir string, match func(name string) bool) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var (
+		res string
+		mod time.Time
+	)
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || (match != nil && !match(e.Name())) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if res == "" || fi.ModTime().After(mod) {
+			res, mod = filepath.Join(dir, e.Name()), fi.ModTime()
+		}
+	}
+	return res, nil
+}
+
+// Follow 持续读取日志目录中最新的日志文件，从文件的末尾开始，出现更新的文件（例如轮转或者新的任务）时切换到新文件并从头读取。
+// 每interval检查一次，没有新内容时输出尚未结束的多行日志，stop关闭时返回
+func Follow(dir string, match func(name string) bool, interval time.Duration, stop <-chan struct{}, fn func(Record)) error {
+	if interval <= 0 {
+		interval = FOLLOW_INTERVAL
+	}
+
+	var (
+		cur     string
+		f       *os.File
+		br      *bufio.Reader
+		partial string
+		p       *recordParser
+	)
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	// read 读取当前文件中新的完整行，返回是否读到了内容
+	read := func() bool {
+		if br == nil {
+			return false
+		}
+		got := false
+		for {
+			s, err := br.ReadString('\n')
+			if s != "" {
+				got = true
+			}
+			if err != nil {
+				partial += s
+				return got
+			}
+			p.line(partial + s)
+			partial = ""
+		}
+	}
+	open := func(path string, fromEnd bool) error {
+		if f != nil {
+			read()
+			if partial != "" {
+				p.line(partial)
+			}
+			p.flush()
+			f.Close()
+		}
+		nf, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		if fromEnd {
+			if _, err := nf.Seek(0, io.SeekEnd); err != nil {
+				nf.Close()
+				return err
+			}
+		}
+		cur, f, br, partial = path, nf, bufio.NewReader(nf), ""
+		p = &recordParser{source: path, emit: func(r Record) bool { fn(r); return true }}
+		return nil
+	}
+
+	if n, err := newestLogFile(dir, match); err != nil {
+		return err
+	} else if n != "" {
+		if err := open(n, true); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			if p != nil {
+				read()
+				p.flush()
+			}
+			return nil
+		case <-ticker.C:
+			if !read() && p != nil && partial == "" {
+				p.flush()
+			}
+			n, err := newestLogFile(dir, match)
+			if err != nil {
+				return err
+			}
+			if n != "" && n != cur {
+				if err := open(n, false); err != nil {
+					return err
+				}
+				read()
+			}
+		}
+	}
+}
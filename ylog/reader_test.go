@@ -0,0 +1,192 @@
+package ylog_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRecords(t *testing.T) {
+	text := "[2024-03-01 10:00:00] [INFO ] [pvd/idea.go:120] - start job_id=j1 command=keen_mount\n" +
+		"[2024-03-01 10:00:01] [ERROR] [pvd/idea.go:130] - failed:\n" +
+		"  line two\n" +
+		"  line three path=\"/a b\" empty=\"\"\r\n" +
+		"[2024-03-01 10:00:02] [WARN ] [ylog/x.go:7] - plain message\n"
+	res := make([]ylog.Record, 0)
+	err := ylog.ParseRecords(strings.NewReader(text), "test.log", func(r ylog.Record) bool {
+		res = append(res, r)
+		return true
+	})
+	assert.NoError(t, err)
+	if !assert.Len(t, res, 3) {
+		return
+	}
+
+	assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local), res[0].Time)
+	assert.Equal(t, int8(ylog.INFO), res[0].Level)
+	assert.Equal(t, "pvd/idea.go", res[0].File)
+	assert.Equal(t, 120, res[0].Line)
+	assert.Equal(t, "start", res[0].Text)
+	v, ok := res[0].Field("job_id")
+	assert.True(t, ok)
+	assert.Equal(t, "j1", v)
+	assert.Equal(t, "test.log", res[0].Source)
+
+	assert.Equal(t, int8(ylog.ERROR), res[1].Level)
+	assert.Equal(t, "failed:\n  line two\n  line three", res[1].Text)
+	assert.Equal(t, []ylog.Field{{Key: "path", Value: "/a b"}, {Key: "empty", Value: ""}}, res[1].Fields)
+	assert.Equal(t, "[2024-03-01 10:00:01] [ERROR] [pvd/idea.go:130] - failed:\n  line two\n  line three path=\"/a b\" empty=\"\"", res[1].Raw)
+
+	assert.Equal(t, "plain message", res[2].Text)
+	assert.Empty(t, res[2].Fields)
+
+	// fn返回false时停止
+	n := 0
+	err = ylog.ParseRecords(strings.NewReader(text), "test.log", func(r ylog.Record) bool {
+		n++
+		return false
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestParseRecordsFromLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := ylog.NewLogger(ylog.NewConsoleWriterTo(buf, all, false))
+	logger.WarnKV("disk almost full", "path", "/data dir", "used", 0.9)
+
+	res := make([]ylog.Record, 0)
+	assert.NoError(t, ylog.ParseRecords(buf, "", func(r ylog.Record) bool {
+		res = append(res, r)
+		return true
+	}))
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, int8(ylog.WARN), res[0].Level)
+		assert.Equal(t, "ylog/reader_test.go", res[0].File)
+		assert.Equal(t, "disk almost full", res[0].Text)
+		assert.Equal(t, []ylog.Field{{Key: "path", Value: "/data dir"}, {Key: "used", Value: "0.9"}}, res[0].Fields)
+	}
+}
+
+func writeGzip(t *testing.T, path, content string) {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	gw.Write([]byte(content))
+	gw.Close()
+	assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+}
+
+func writeBundle(t *testing.T, path string, files map[string]string, names ...string) {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for _, n := range names {
+		tw.WriteHeader(&tar.Header{Name: n, Mode: 0644, Size: int64(len(files[n]))})
+		tw.Write([]byte(files[n]))
+	}
+	tw.Close()
+	gw.Close()
+	assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+}
+
+func TestReadLogsFromArchives(t *testing.T) {
+	dir := t.TempDir()
+	arch := filepath.Join(dir, "keen_mount")
+	assert.NoError(t, os.Mkdir(arch, 0755))
+	nested := filepath.Join(arch, "deeper")
+	assert.NoError(t, os.Mkdir(nested, 0755))
+
+	writeBundle(t, filepath.Join(arch, "keen_mount_202403.tar.gz"), map[string]string{
+		"a.log": "[2024-03-01 10:00:00] [INFO ] [x/a.go:1] - a1\n[2024-03-01 10:00:04] [INFO ] [x/a.go:2] - a2\n",
+		"b.log": "[2024-03-01 10:00:02] [INFO ] [x/b.go:1] - b1\n",
+	}, "a.log", "b.log")
+	writeGzip(t, filepath.Join(arch, "c.log.gz"), "[2024-03-01 10:00:03] [ERROR] [x/c.go:1] - c1\n")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "d.log"), []byte("[2024-03-01 10:00:01] [DEBUG] [x/d.go:1] - d1\n[2024-03-01 10:00:04] [INFO ] [x/d.go:2] - d2\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ".keen-archive.lock"), []byte("1"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(nested, "e.log"), []byte("[2024-03-01 10:00:00] [INFO ] [x/e.go:1] - e1\n"), 0644))
+
+	old := time.Now().Add(-time.Hour)
+	for i, n := range []string{"keen_mount/keen_mount_202403.tar.gz", "keen_mount/c.log.gz", "d.log"} {
+		mt := old.Add(time.Duration(i) * time.Minute)
+		assert.NoError(t, os.Chtimes(filepath.Join(dir, n), mt, mt))
+	}
+
+	files, err := ylog.LogFiles(dir, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(arch, "keen_mount_202403.tar.gz"),
+		filepath.Join(arch, "c.log.gz"),
+		filepath.Join(dir, "d.log"),
+	}, files)
+
+	res, err := ylog.ReadLogs(files, nil)
+	assert.NoError(t, err)
+	texts := make([]string, 0)
+	for _, r := range res {
+		texts = append(texts, r.Text)
+	}
+	// 同一时间的a2和d2保持文件的顺序
+	assert.Equal(t, []string{"a1", "d1", "b1", "c1", "a2", "d2"}, texts)
+	assert.Equal(t, filepath.Join(arch, "keen_mount_202403.tar.gz")+"/b.log", res[2].Source)
+
+	res, err = ylog.ReadLogs(files, func(r ylog.Record) bool { return r.Level >= ylog.ERROR })
+	assert.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, "c1", res[0].Text)
+	}
+
+	files, err = ylog.LogFiles(dir, func(name string) bool { return strings.HasSuffix(name, ".gz") })
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestFollowNewestFile(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.log")
+	assert.NoError(t, os.WriteFile(first, []byte("[2024-03-01 10:00:00] [INFO ] [x/a.go:1] - existing\n"), 0644))
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(first, old, old))
+
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	texts := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), got...)
+	}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- ylog.Follow(dir, nil, 10*time.Millisecond, stop, func(r ylog.Record) {
+			mu.Lock()
+			got = append(got, r.Text)
+			mu.Unlock()
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	f, err := os.OpenFile(first, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	f.WriteString("[2024-03-01 10:00:01] [INFO ] [x/a.go:2] - appended\n  continued\n")
+	f.Close()
+	assert.Eventually(t, func() bool { return len(texts()) == 1 }, time.Second, 10*time.Millisecond)
+
+	second := filepath.Join(dir, "second.log")
+	assert.NoError(t, os.WriteFile(second, []byte("[2024-03-01 10:00:02] [INFO ] [x/b.go:1] - new file\n"), 0644))
+	assert.Eventually(t, func() bool { return len(texts()) == 2 }, time.Second, 10*time.Millisecond)
+
+	close(stop)
+	assert.NoError(t, <-done)
+	assert.Equal(t, []string{"appended\n  continued", "new file"}, texts())
+}
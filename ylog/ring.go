@@ -0,0 +1,198 @@
+package ylog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_RING_SIZE = 1000
+	CRASH_FILE_EXT    = ".crash" // 崩溃文件的扩展名，一般为日志文件名称加上此扩展名
+)
+
+var ErrNoCrashFile = errors.New("the crash file of the ring writer is not set")
+
+// RingWriter 在内存中保留最近的日志，不区分级别，满了之后覆盖最早的日志。
+// Logger.Fatal和Logger.DumpCrash将保留的日志以追加方式写入崩溃文件，写入之后清空，之后的日志不会重复写入
+type RingWriter struct {
+	crashFile string
+	formatter Formatter
+
+	mu      sync.Mutex
+	records []LogMessage
+	head    int
+	size    int
+}
+
+// NewRingWriter size不大于0时使用DEFAULT_RING_SIZE，crashFile为空时只能通过DumpTo输出
+func NewRingWriter(size int, crashFile string) *RingWriter {
+	if size <= 0 {
+		size = DEFAULT_RING_SIZE
+	}
+	return &RingWriter{
+		crashFile: crashFile,
+		records:   make([]LogMessage, size),
+	}
+}
+
+// SetFormatter 设置写入崩溃文件的格式，为nil时使用DefaultTemplate
+func (w *RingWriter) SetFormatter(f Formatter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.formatter = f
+}
+
+// CrashFile 崩溃文件的路径
+func (w *RingWriter) CrashFile() string {
+	return w.crashFile
+}
+
+func (w *RingWriter) push(m LogMessage) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.records[(w.head+w.size)%len(w.records)] = m
+	if w.size < len(w.records) {
+		w.size++
+	} else {
+		w.head = (w.head + 1) % len(w.records)
+	}
+}
+
+// Records 返回保留的日志，从早到晚排列
+func (w *RingWriter) Records() []LogMessage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.snapshot()
+}
+
+// snapshot 调用者需要持有锁
+func (w *RingWriter) snapshot() []LogMessage {
+	res := make([]LogMessage, 0, w.size)
+	for i := 0; i < w.size; i++ {
+		res = append(res, w.records[(w.head+i)%len(w.records)])
+	}
+	return res
+}
+
+// reset 清空保留的日志，调用者需要持有锁
+func (w *RingWriter) reset() {
+	for i := range w.records {
+		w.records[i] = LogMessage{}
+	}
+	w.head, w.size = 0, 0
+}
+
+// DumpTo 将保留的日志写入out，不清空
+func (w *RingWriter) DumpTo(out io.Writer) error {
+	w.mu.Lock()
+	f, records := w.formatter, w.snapshot()
+	w.mu.Unlock()
+
+	for _, m := range records {
+		if _, err := out.Write(render(f, m)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dump 将保留的日志追加到崩溃文件并清空，没有保留的日志时不创建文件
+func (w *RingWriter) Dump() error {
+	if w.crashFile == "" {
+		return ErrNoCrashFile
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.size == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(w.crashFile), os.ModeDir|0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.crashFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	for _, m := range w.snapshot() {
+		if _, err := f.Write(render(w.formatter, m)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	w.reset()
+	return nil
+}
+
+func (w *RingWriter) writeRecord(m LogMessage) error {
+	w.push(m)
+	return nil
+}
+
+// enable 所有级别都保留
+func (w *RingWriter) enable(l int8) bool {
+	return true
+}
+
+// Write 已经渲染好的内容作为INFO级别的日志保留
+func (w *RingWriter) Write(p []byte) (int, error) {
+	msg := string(p)
+	w.push(LogMessage{level: INFO, time: time.Now(), msg: msg, text: strings.TrimRight(msg, "\r\n")})
+	return len(p), nil
+}
+
+func (w *RingWriter) flush() {}
+
+// clean 正常结束时不写入崩溃文件
+func (w *RingWriter) clean() {}
+
+// findRingWriters 沿着包装的输出目标查找RingWriter，经过的AsyncWriter会先等待队列中的日志写入
+func findRingWriters(writers []LogWriter) []*RingWriter {
+	res := make([]*RingWriter, 0)
+	for _, w := range writers {
+		for w != nil {
+			if r, ok := w.(*RingWriter); ok {
+				res = append(res, r)
+				break
+			}
+			if a, ok := w.(*AsyncWriter); ok {
+				a.Flush()
+			}
+			u, ok := w.(wrapper)
+			if !ok {
+				break
+			}
+			w = u.unwrap()
+		}
+	}
+	return res
+}
+
+// DumpCrash 将Logger的所有RingWriter中保留的日志写入各自的崩溃文件，Logger.Fatal会自动调用
+func (log *Logger) DumpCrash() error {
+	if log.core == nil {
+		return nil
+	}
+	log.core.mu.RLock()
+	defer log.core.mu.RUnlock()
+
+	var errs []string
+	for _, r := range findRingWriters(log.core.writers) {
+		if err := r.Dump(); err != nil {
+			errs = append(errs, fmt.Sprintf("[%s] %v", r.crashFile, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to dump the crash logs: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
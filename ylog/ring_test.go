@@ -0,0 +1,109 @@
+package ylog_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingWriterKeepsLatest(t *testing.T) {
+	ring := ylog.NewRingWriter(3, "")
+	info := new(bytes.Buffer)
+	logger := ylog.NewLogger(ylog.NewConsoleWriterTo(info, func(l int8) bool { return l >= ylog.INFO }, false), ring)
+
+	logger.Trace("one")
+	logger.Debug("two")
+	logger.Info("three")
+	logger.TraceKV("four", "k", "v")
+	assert.NotContains(t, info.String(), "four")
+
+	texts := make([]string, 0)
+	for _, m := range ring.Records() {
+		texts = append(texts, m.Text())
+	}
+	assert.Equal(t, []string{"two", "three", "four"}, texts)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, ring.DumpTo(buf))
+	assert.Regexp(t, `^\[[^]]+\] \[DEBUG\] \[ylog/ring_test\.go:\d+\] - two\n.*three\n.*- four k=v\n$`, buf.String())
+	// DumpTo不清空
+	assert.Len(t, ring.Records(), 3)
+
+	assert.ErrorIs(t, ring.Dump(), ylog.ErrNoCrashFile)
+}
+
+func TestDumpCrash(t *testing.T) {
+	dir := t.TempDir()
+	crash := filepath.Join(dir, "job.log"+ylog.CRASH_FILE_EXT)
+	ring := ylog.NewRingWriter(10, crash)
+	logger := ylog.NewLogger(ylog.NewAsyncWriter(ring, ylog.AsyncOptions{}))
+
+	// 没有日志时不创建崩溃文件
+	assert.NoError(t, logger.DumpCrash())
+	assert.NoFileExists(t, crash)
+
+	logger.Trace("detail")
+	logger.ErrorKV("failed", "job_id", "j1")
+	// 队列中的日志先写入RingWriter
+	assert.NoError(t, logger.DumpCrash())
+	logger.Clean()
+	assert.Empty(t, ring.Records())
+	res := make([]ylog.Record, 0)
+	assert.NoError(t, ylog.ReadLogFile(crash, func(r ylog.Record) bool {
+		res = append(res, r)
+		return true
+	}))
+	if assert.Len(t, res, 2) {
+		assert.Equal(t, int8(ylog.TRACE), res[0].Level)
+		assert.Equal(t, "detail", res[0].Text)
+		v, _ := res[1].Field("job_id")
+		assert.Equal(t, "j1", v)
+	}
+
+	// 追加之后的日志，已经写入的日志不会重复
+	ring.Write([]byte("raw line\n"))
+	assert.NoError(t, ring.Dump())
+	bs, err := os.ReadFile(crash)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(bs), "detail"))
+	assert.True(t, strings.HasSuffix(string(bs), "raw line\n"))
+}
+
+func TestCleanDoesNotDumpCrash(t *testing.T) {
+	crash := filepath.Join(t.TempDir(), "job.log"+ylog.CRASH_FILE_EXT)
+	ring := ylog.NewRingWriter(10, crash)
+	logger := ylog.NewLogger(ring)
+	logger.Info("done")
+	logger.Clean()
+	assert.NoFileExists(t, crash)
+	assert.Len(t, ring.Records(), 1)
+}
+
+func TestFatalDumpsCrash(t *testing.T) {
+	if crash := os.Getenv("YLOG_TEST_CRASH_FILE"); crash != "" {
+		logger := ylog.NewLogger(ylog.NewRingWriter(0, crash))
+		logger.Debug("before fatal")
+		logger.Fatal("fatal")
+		return
+	}
+
+	crash := filepath.Join(t.TempDir(), "fatal.log"+ylog.CRASH_FILE_EXT)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFatalDumpsCrash$")
+	cmd.Env = append(os.Environ(), "YLOG_TEST_CRASH_FILE="+crash)
+	err := cmd.Run()
+	if ee, ok := err.(*exec.ExitError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, 1, ee.ExitCode())
+	}
+
+	bs, err := os.ReadFile(crash)
+	assert.NoError(t, err)
+	assert.Contains(t, string(bs), "[DEBUG]")
+	assert.Contains(t, string(bs), "before fatal")
+	assert.Contains(t, string(bs), "[FATAL]")
+}
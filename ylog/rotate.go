@@ -0,0 +1,224 @@
+package ylog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RotateInterval 按照时间轮转的周期
+type RotateInterval int
+
+const (
+	ROTATE_NONE RotateInterval = iota
+	ROTATE_HOURLY
+	ROTATE_DAILY
+)
+
+const (
+	ROTATE_TIME_FMT = "20060102150405"
+)
+
+// RotateNaming 生成轮转后的日志文件名称，t为被轮转的日志文件开始写入的时间，seq用于避免名称重复
+type RotateNaming = func(filename string, t time.Time, seq int) string
+
+// RotateOptions 文件日志的轮转配置，MaxSize和Interval可以同时使用，都为零值时不轮转
+type RotateOptions struct {
+	MaxSize    int64                            // 日志文件的最大字节数，不大于0时不按照大小轮转
+	Interval   RotateInterval                   // 按照小时或者天轮转
+	MaxBackups int                              // 保留的轮转日志数量，不大于0时不限制
+	Naming     RotateNaming                     // 轮转日志的名称，为nil时使用DefaultRotateNaming
+	IsBackup   func(filename, name string) bool // 判断目录中的文件是否是filename的轮转日志，为nil时使用DefaultIsBackup
+	Clock      func() time.Time                 // 当前时间，为nil时使用time.Now
+	Archive    ArchiveOptions                   // 启动和轮转之后归档日志的方式
+}
+
+// DefaultRotateNaming 轮转日志的名称为 名称_时间[-序号].扩展名，例如 backup_1_20240102150405_20240102160000.log
+func DefaultRotateNaming(filename string, t time.Time, seq int) string {
+	ext := filepath.Ext(filename)
+	stem := strings.TrimSuffix(filename, ext)
+	if seq > 0 {
+		return fmt.Sprintf("%s_%s-%d%s", stem, t.Format(ROTATE_TIME_FMT), seq, ext)
+	}
+	return fmt.Sprintf("%s_%s%s", stem, t.Format(ROTATE_TIME_FMT), ext)
+}
+
+// DefaultIsBackup 与DefaultRotateNaming对应
+func DefaultIsBackup(filename, name string) bool {
+	ext := filepath.Ext(filename)
+	stem := strings.TrimSuffix(filename, ext)
+	if !strings.HasPrefix(name, stem+"_") || !strings.HasSuffix(name, ext) {
+		return false
+	}
+	ts := strings.TrimSuffix(strings.TrimPrefix(name, stem+"_"), ext)
+	if i := strings.Index(ts, "-"); i >= 0 {
+		ts = ts[:i]
+	}
+	_, err := time.ParseInLocation(ROTATE_TIME_FMT, ts, time.Local)
+	return err == nil
+}
+
+func (o RotateOptions) now() time.Time {
+	if o.Clock != nil {
+		return o.Clock()
+	}
+	return time.Now()
+}
+
+// next 时间t所在周期的下一个周期的开始时间
+func (o RotateOptions) next(t time.Time) time.Time {
+	switch o.Interval {
+	case ROTATE_HOURLY:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()).Add(time.Hour)
+	case ROTATE_DAILY:
+		return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Time{}
+	}
+}
+
+// open 创建日志文件，appending为true时追加到已有的文件而不截断，调用者需要持有锁
+func (w *FileWriter) open(appending bool) error {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(w.path, flag, 0666)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = fi.Size()
+	w.opened = w.rotate.now()
+	return nil
+}
+
+// shouldRotate 写入n字节之前判断是否需要轮转，调用者需要持有锁
+func (w *FileWriter) shouldRotate(n int) bool {
+	if w.rotate.MaxSize > 0 && w.size > 0 && w.size+int64(n) > w.rotate.MaxSize {
+		return true
+	}
+	if w.rotate.Interval != ROTATE_NONE && !w.rotate.now().Before(w.rotate.next(w.opened)) {
+		return true
+	}
+	return false
+}
+
+// rotateFile 将当前日志文件重命名为轮转日志并创建新的日志文件，调用者需要持有锁。
+// 重命名失败时以追加方式重新打开原文件，已经写入的日志不会被截断
+func (w *FileWriter) rotateFile() error {
+	naming := w.rotate.Naming
+	if naming == nil {
+		naming = DefaultRotateNaming
+	}
+
+	var backup string
+	for seq := 0; ; seq++ {
+		backup = filepath.Join(filepath.Dir(w.path), naming(w.name, w.opened, seq))
+		if _, err := os.Lstat(backup); os.IsNotExist(err) {
+			break
+		}
+	}
+
+	if err := w.file.Close(); err != nil {
+		Errorf("failed to close the log file: %v\n", err)
+	}
+	rerr := os.Rename(w.path, backup)
+	if err := w.open(rerr != nil); err != nil {
+		return err
+	}
+	return rerr
+}
+
+// maintain 轮转之后清理多余的轮转日志、删除过期日志并归档。不持有写入的锁，避免阻塞其他写入，
+// 多次轮转触发的清理通过maintainMu依次执行
+func (w *FileWriter) maintain() {
+	w.maintainMu.Lock()
+	defer w.maintainMu.Unlock()
+
+	if err := w.pruneBackups(); err != nil {
+		Errorf("failed to remove the redundant log files: %v\n", err)
+	}
+	deleteExpiredLogAndArchive(w.logDir, w.expire, w.archive, w.path, w.isBackup, w.rotate.Archive)
+}
+
+// isBackup 判断文件是否是当前日志的轮转日志
+func (w *FileWriter) isBackup(name string) bool {
+	if w.rotate.IsBackup != nil {
+		return w.rotate.IsBackup(w.name, name)
+	}
+	return DefaultIsBackup(w.name, name)
+}
+
+// pruneBackups 只保留最新的MaxBackups个轮转日志，包括已经移动到归档目录中的和单独压缩的轮转日志，
+// 打包之后的轮转日志只会按照过期时间删除
+func (w *FileWriter) pruneBackups() error {
+	if w.rotate.MaxBackups <= 0 {
+		return nil
+	}
+	ext := w.rotate.Archive.compressor().Ext()
+
+	type backup struct {
+		path string
+		mod  time.Time
+	}
+	backups := make([]backup, 0)
+	var scan func(dir string, top bool) error
+	scan = func(dir string, top bool) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				// 只进入一级归档目录
+				if top {
+					if err := scan(filepath.Join(dir, e.Name()), false); err != nil {
+						Errorf("failed to read the archive directory [%s]: %v\n", e.Name(), err)
+					}
+				}
+				continue
+			}
+			name := e.Name()
+			if !top {
+				name = strings.TrimSuffix(name, ext)
+			}
+			if (top && name == w.name) || !w.isBackup(name) {
+				continue
+			}
+			fi, err := e.Info()
+			if err != nil {
+				continue
+			}
+			backups = append(backups, backup{filepath.Join(dir, e.Name()), fi.ModTime()})
+		}
+		return nil
+	}
+	if err := scan(filepath.Dir(w.path), true); err != nil {
+		return err
+	}
+	if len(backups) <= w.rotate.MaxBackups {
+		return nil
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].mod.Equal(backups[j].mod) {
+			return backups[i].path < backups[j].path
+		}
+		return backups[i].mod.Before(backups[j].mod)
+	})
+	for _, b := range backups[:len(backups)-w.rotate.MaxBackups] {
+		if err := os.Remove(b.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
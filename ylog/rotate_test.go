@@ -0,0 +1,243 @@
+package ylog_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	res := make([]string, 0)
+	for _, e := range entries {
+		res = append(res, e.Name())
+	}
+	sort.Strings(res)
+	return res
+}
+
+// fakeClock 可以手动调整的时钟
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{t: time.Date(2024, 1, 2, 15, 0, 0, 0, time.Local)}
+	w, err := ylog.NewRotatingFileWriter(dir, "app.log", all, 0, nil, ylog.RotateOptions{MaxSize: 200, MaxBackups: 2, Clock: clock.now})
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+
+	for i := 0; i < 20; i++ {
+		logger.Info("size rotation message %02d", i)
+		clock.add(time.Second)
+	}
+	logger.Clean()
+
+	names := listDir(t, dir)
+	assert.Len(t, names, 3, "current file and two backups: %v", names)
+	assert.Contains(t, names, "app.log")
+	for _, n := range names {
+		fi, err := os.Stat(filepath.Join(dir, n))
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, fi.Size(), int64(200))
+		if n != "app.log" {
+			assert.True(t, ylog.DefaultIsBackup("app.log", n), n)
+		}
+	}
+
+	bs, err := os.ReadFile(filepath.Join(dir, "app.log"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(bs), "message 19")
+}
+
+func TestRotateByTime(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{t: time.Date(2024, 1, 2, 15, 30, 0, 0, time.Local)}
+	w, err := ylog.NewRotatingFileWriter(dir, "app.log", all, 0, nil, ylog.RotateOptions{Interval: ylog.ROTATE_HOURLY, Clock: clock.now})
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+
+	logger.Info("first hour")
+	clock.add(29 * time.Minute)
+	logger.Info("still first hour")
+	clock.add(time.Minute)
+	logger.Info("second hour")
+	clock.add(2 * time.Hour)
+	logger.Info("fourth hour")
+	logger.Clean()
+
+	assert.Equal(t, []string{"app.log", "app_20240102153000.log", "app_20240102160000.log"}, listDir(t, dir))
+	bs, _ := os.ReadFile(filepath.Join(dir, "app_20240102153000.log"))
+	assert.Equal(t, 2, strings.Count(string(bs), "\n"))
+	bs, _ = os.ReadFile(filepath.Join(dir, "app.log"))
+	assert.Contains(t, string(bs), "fourth hour")
+}
+
+func TestRotateDailyWithNaming(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{t: time.Date(2024, 1, 2, 23, 59, 0, 0, time.Local)}
+	naming := func(fn string, t time.Time, seq int) string { return t.Format("2006-01-02") + "." + fn }
+	w, err := ylog.NewRotatingFileWriter(dir, "app.log", all, 0, nil, ylog.RotateOptions{Interval: ylog.ROTATE_DAILY, Naming: naming, Clock: clock.now})
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+
+	logger.Info("day one")
+	clock.add(time.Minute)
+	logger.Info("day two")
+	logger.Clean()
+
+	assert.Equal(t, []string{"2024-01-02.app.log", "app.log"}, listDir(t, dir))
+}
+
+func TestRotateArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive := func(fn string) (bool, string) {
+		if strings.HasPrefix(fn, "app") {
+			return true, "app"
+		}
+		return false, ""
+	}
+	clock := &fakeClock{t: time.Date(2024, 1, 2, 15, 0, 0, 0, time.Local)}
+	w, err := ylog.NewRotatingFileWriter(dir, "app.log", all, 0, archive, ylog.RotateOptions{MaxSize: 100, Clock: clock.now})
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+
+	for i := 0; i < 5; i++ {
+		logger.Info("archive rotation message %02d", i)
+		clock.add(time.Second)
+	}
+	logger.Clean()
+
+	assert.Equal(t, []string{"app", "app.log"}, listDir(t, dir), "the current file should never be archived")
+	assert.Len(t, listDir(t, filepath.Join(dir, "app")), 4)
+}
+
+// captureStderr 返回fn执行期间输出到标准错误的内容
+func captureStderr(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	old := os.Stderr
+	os.Stderr = w
+	out := make(chan string)
+	go func() {
+		bs, _ := io.ReadAll(r)
+		out <- string(bs)
+	}()
+
+	defer func() { os.Stderr = old }()
+	fn()
+	w.Close()
+	return <-out
+}
+
+func TestRotateRenameFailedKeepsLog(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{t: time.Date(2024, 1, 2, 15, 0, 0, 0, time.Local)}
+	// 轮转日志位于不存在的目录中，重命名一定失败
+	naming := func(fn string, t time.Time, seq int) string { return filepath.Join("missing", fn) }
+	w, err := ylog.NewRotatingFileWriter(dir, "app.log", all, 0, nil, ylog.RotateOptions{MaxSize: 100, Naming: naming, Clock: clock.now})
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+
+	stderr := captureStderr(t, func() {
+		for i := 0; i < 5; i++ {
+			logger.Info("rename failure message %02d", i)
+			clock.add(time.Second)
+		}
+	})
+	logger.Clean()
+	assert.Equal(t, 1, strings.Count(stderr, "failed to rotate the log file"), "the rotation error should be reported once: %s", stderr)
+
+	assert.Equal(t, []string{"app.log"}, listDir(t, dir))
+	bs, err := os.ReadFile(filepath.Join(dir, "app.log"))
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		assert.Contains(t, string(bs), fmt.Sprintf("message %02d", i), "the log should not be truncated")
+	}
+}
+
+func TestRotateRecoveredReported(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{t: time.Date(2024, 1, 2, 15, 0, 0, 0, time.Local)}
+	failing := true
+	naming := func(fn string, t time.Time, seq int) string {
+		if failing {
+			return filepath.Join("missing", fn)
+		}
+		return ylog.DefaultRotateNaming(fn, t, seq)
+	}
+	w, err := ylog.NewRotatingFileWriter(dir, "app.log", all, 0, nil, ylog.RotateOptions{MaxSize: 100, Naming: naming, Clock: clock.now})
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+	defer logger.Clean()
+
+	stderr := captureStderr(t, func() {
+		for i := 0; i < 3; i++ {
+			logger.Info("rename failure message %02d", i)
+			clock.add(time.Second)
+		}
+		failing = false
+		logger.Info("recovered")
+		clock.add(time.Second)
+		logger.Info("rotated again")
+	})
+	assert.Equal(t, 1, strings.Count(stderr, "failed to rotate the log file"), stderr)
+	assert.Equal(t, 1, strings.Count(stderr, "successfully after 2 failures"), stderr)
+	assert.Len(t, listDir(t, dir), 3, "the log should be rotated after recovering")
+}
+
+func TestRotateArchiveMaxBackups(t *testing.T) {
+	for _, mode := range []ylog.ArchiveMode{ylog.ARCHIVE_MOVE, ylog.ARCHIVE_COMPRESS} {
+		dir := t.TempDir()
+		archive := func(fn string) (bool, string) {
+			if strings.HasPrefix(fn, "app_") {
+				return true, "app"
+			}
+			return false, ""
+		}
+		clock := &fakeClock{t: time.Date(2024, 1, 2, 15, 0, 0, 0, time.Local)}
+		w, err := ylog.NewRotatingFileWriter(dir, "app.log", all, 0, archive, ylog.RotateOptions{
+			MaxSize:    100,
+			MaxBackups: 2,
+			Clock:      clock.now,
+			Archive:    ylog.ArchiveOptions{Mode: mode},
+		})
+		assert.NoError(t, err)
+		logger := ylog.NewLogger(w)
+
+		for i := 0; i < 6; i++ {
+			logger.Info("archive rotation message %02d", i)
+			clock.add(time.Second)
+		}
+		logger.Clean()
+
+		assert.Equal(t, []string{"app", "app.log"}, listDir(t, dir))
+		assert.Len(t, listDir(t, filepath.Join(dir, "app")), 2, "mode %d: %v", mode, listDir(t, filepath.Join(dir, "app")))
+	}
+}
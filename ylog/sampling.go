@@ -0,0 +1,176 @@
+package ylog
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_SAMPLE_INTERVAL = time.Minute
+	REPEATED_MSG_FORMAT     = "last message repeated %d times"
+)
+
+// SampleRule 每个调用点在一个周期内的输出限制，First不大于0时不限制
+type SampleRule struct {
+	First      int // 每个周期内最先输出的日志数量
+	Thereafter int // 超过First之后每Thereafter条输出一条，不大于0时全部丢弃
+}
+
+// SamplingOptions 采样和重复日志合并的配置。规则的优先级为 调用点 > 级别 > Default，
+// 调用点的键为 文件:行号，文件保留两级路径，与日志中的调用者相同，例如 pvd/idea.go:120
+type SamplingOptions struct {
+	Interval time.Duration // 采样周期，不大于0时使用DEFAULT_SAMPLE_INTERVAL
+	Default  SampleRule
+	Levels   map[int8]SampleRule
+	Sites    map[string]SampleRule
+	Dedup    bool             // 连续的相同日志只输出第一条，之后输出一条REPEATED_MSG_FORMAT的日志说明重复次数
+	Clock    func() time.Time // 当前时间，为nil时使用time.Now
+}
+
+type sampleCounter struct {
+	start time.Time
+	n     int
+}
+
+// SamplingWriter 对被包装的LogWriter按照调用点和级别采样，并合并连续的重复日志。
+// 重复的日志在出现不同的日志、重复持续超过一个周期或者清理时输出重复次数
+type SamplingWriter struct {
+	inner LogWriter
+	opts  SamplingOptions
+
+	mu       sync.Mutex
+	counters map[string]*sampleCounter
+	last     *LogMessage
+	lastKey  string
+	repeated int
+	since    time.Time
+	dropped  atomic.Uint64
+}
+
+func NewSamplingWriter(inner LogWriter, opts SamplingOptions) *SamplingWriter {
+	if opts.Interval <= 0 {
+		opts.Interval = DEFAULT_SAMPLE_INTERVAL
+	}
+	return &SamplingWriter{
+		inner:    inner,
+		opts:     opts,
+		counters: make(map[string]*sampleCounter),
+	}
+}
+
+// Dropped 因为采样或者重复而没有输出的日志数量
+func (w *SamplingWriter) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+func (w *SamplingWriter) unwrap() LogWriter {
+	return w.inner
+}
+
+func (w *SamplingWriter) now() time.Time {
+	if w.opts.Clock != nil {
+		return w.opts.Clock()
+	}
+	return time.Now()
+}
+
+func site(m LogMessage) string {
+	return SubPath(m.file, 2) + ":" + strconv.Itoa(m.line)
+}
+
+func (w *SamplingWriter) rule(m LogMessage, key string) SampleRule {
+	if r, ok := w.opts.Sites[key]; ok {
+		return r
+	}
+	if r, ok := w.opts.Levels[m.level]; ok {
+		return r
+	}
+	return w.opts.Default
+}
+
+// sample 判断日志是否在采样中保留，调用者需要持有锁
+func (w *SamplingWriter) sample(m LogMessage, now time.Time) bool {
+	key := site(m)
+	r := w.rule(m, key)
+	if r.First <= 0 {
+		return true
+	}
+
+	key += "#" + strconv.Itoa(int(m.level))
+	c, ok := w.counters[key]
+	if !ok || now.Sub(c.start) >= w.opts.Interval {
+		c = &sampleCounter{start: now}
+		w.counters[key] = c
+	}
+	c.n++
+	if c.n <= r.First {
+		return true
+	}
+	return r.Thereafter > 0 && (c.n-r.First)%r.Thereafter == 0
+}
+
+// flushRepeated 输出之前的日志的重复次数，调用者需要持有锁
+func (w *SamplingWriter) flushRepeated(now time.Time) error {
+	if w.repeated == 0 {
+		return nil
+	}
+	l := w.last
+	m := makeMessage(l.level, now, l.pc, l.file, l.line, l.function, fmt.Sprintf(REPEATED_MSG_FORMAT, w.repeated), nil, nil)
+	w.repeated = 0
+	w.since = now
+	return w.inner.writeRecord(m)
+}
+
+func (w *SamplingWriter) writeRecord(m LogMessage) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	if w.opts.Dedup {
+		key := strconv.Itoa(int(m.level)) + " " + m.text + formatFields(m.fields)
+		if w.last != nil && key == w.lastKey {
+			w.repeated++
+			w.dropped.Add(1)
+			if now.Sub(w.since) >= w.opts.Interval {
+				return w.flushRepeated(now)
+			}
+			return nil
+		}
+		if err := w.flushRepeated(now); err != nil {
+			Errorf("failed to write log: %v\n", err)
+		}
+		w.last, w.lastKey, w.since = &m, key, now
+	}
+
+	if !w.sample(m, now) {
+		w.dropped.Add(1)
+		return nil
+	}
+	return w.inner.writeRecord(m)
+}
+
+func (w *SamplingWriter) enable(l int8) bool {
+	return w.inner.enable(l)
+}
+
+// Write 直接写入被包装的LogWriter，不采样
+func (w *SamplingWriter) Write(p []byte) (int, error) {
+	return w.inner.Write(p)
+}
+
+func (w *SamplingWriter) flush() {
+	w.inner.flush()
+}
+
+// clean 输出尚未输出的重复次数之后清理被包装的LogWriter
+func (w *SamplingWriter) clean() {
+	w.mu.Lock()
+	if err := w.flushRepeated(w.now()); err != nil {
+		Errorf("failed to write log: %v\n", err)
+	}
+	w.mu.Unlock()
+	w.inner.clean()
+}
@@ -0,0 +1,94 @@
+package ylog_test
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+func samplingLogger(opts ylog.SamplingOptions) (ylog.Logger, *ylog.SamplingWriter, *bytes.Buffer) {
+	buf := new(bytes.Buffer)
+	console := ylog.NewConsoleWriterTo(buf, all, false)
+	console.SetFormatter(ylog.MustParseTemplate("{level|0} {msg}"))
+	w := ylog.NewSamplingWriter(console, opts)
+	return ylog.NewLogger(w), w, buf
+}
+
+func lines(buf *bytes.Buffer) []string {
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+func TestSamplingFirstThereafter(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 2, 15, 0, 0, 0, time.Local)}
+	logger, w, buf := samplingLogger(ylog.SamplingOptions{
+		Default: ylog.SampleRule{First: 3, Thereafter: 5},
+		Levels:  map[int8]ylog.SampleRule{ylog.ERROR: {}},
+		Clock:   clock.now,
+	})
+
+	for i := 0; i < 20; i++ {
+		logger.Warn("retry %d", i)
+		if i%10 == 0 {
+			logger.Error("errors are not sampled %d", i)
+		}
+	}
+	assert.Equal(t, []string{"WARN retry 0", "ERROR errors are not sampled 0", "WARN retry 1", "WARN retry 2", "WARN retry 7",
+		"ERROR errors are not sampled 10", "WARN retry 12", "WARN retry 17"}, lines(buf))
+	assert.Equal(t, uint64(14), w.Dropped())
+
+	// 新的周期重新计数
+	buf.Reset()
+	clock.add(time.Minute)
+	for i := 0; i < 4; i++ {
+		logger.Warn("retry %d", i)
+	}
+	assert.Equal(t, []string{"WARN retry 0", "WARN retry 1", "WARN retry 2"}, lines(buf))
+}
+
+func TestSamplingSite(t *testing.T) {
+	_, file, line, _ := runtime.Caller(0)
+	key := ylog.SubPath(file, 2) + ":" + strconv.Itoa(line+8)
+	logger, _, buf := samplingLogger(ylog.SamplingOptions{
+		Default: ylog.SampleRule{First: 1},
+		Sites:   map[string]ylog.SampleRule{key: {First: 2}},
+	})
+
+	for i := 0; i < 3; i++ {
+		logger.Info("site %d", i)
+		logger.Info("default %d", i)
+	}
+	assert.Equal(t, []string{"INFO site 0", "INFO default 0", "INFO site 1"}, lines(buf))
+}
+
+func TestSamplingDedup(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 2, 15, 0, 0, 0, time.Local)}
+	logger, _, buf := samplingLogger(ylog.SamplingOptions{Dedup: true, Interval: time.Minute, Clock: clock.now})
+
+	for i := 0; i < 5; i++ {
+		logger.WarnKV("connection refused", "host", "db")
+	}
+	logger.WarnKV("connection refused", "host", "cache")
+	for i := 0; i < 3; i++ {
+		logger.Error("timeout")
+		clock.add(25 * time.Second)
+	}
+	logger.Error("timeout")
+	logger.Clean()
+
+	want := []string{
+		"WARN connection refused",
+		"WARN " + fmt.Sprintf(ylog.REPEATED_MSG_FORMAT, 4),
+		"WARN connection refused",
+		"ERROR timeout",
+		// 重复超过一个周期时先输出一次重复次数
+		"ERROR " + fmt.Sprintf(ylog.REPEATED_MSG_FORMAT, 3),
+	}
+	assert.Equal(t, want, lines(buf))
+}
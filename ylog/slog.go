@@ -0,0 +1,177 @@
+//go:build go1.21
+// +build go1.21
+
+package ylog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// SlogLevel ylog级别对应的slog级别，TRACE低于slog.LevelDebug，FATAL高于slog.LevelError
+func SlogLevel(level int8) slog.Level {
+	switch level {
+	case TRACE:
+		return slog.LevelDebug - 4
+	case DEBUG:
+		return slog.LevelDebug
+	case INFO:
+		return slog.LevelInfo
+	case WARN:
+		return slog.LevelWarn
+	case ERROR:
+		return slog.LevelError
+	default:
+		return slog.LevelError + 4
+	}
+}
+
+// FromSlogLevel slog级别对应的ylog级别，两个级别之间的值归入较低的级别
+func FromSlogLevel(level slog.Level) int8 {
+	switch {
+	case level < slog.LevelDebug:
+		return TRACE
+	case level < slog.LevelInfo:
+		return DEBUG
+	case level < slog.LevelWarn:
+		return INFO
+	case level < slog.LevelError:
+		return WARN
+	case level < slog.LevelError+4:
+		return ERROR
+	default:
+		return FATAL
+	}
+}
+
+// SlogHandler 将slog的日志记录写入ylog.Logger的输出目标，slog的属性转为字段，分组的属性以 分组.键 命名
+type SlogHandler struct {
+	logger Logger
+	group  string
+}
+
+// NewSlogHandler 创建写入logger的slog.Handler，logger上绑定的字段会附加到每一条日志中
+func NewSlogHandler(logger Logger) *SlogHandler {
+	return &SlogHandler{logger: logger}
+}
+
+var _ slog.Handler = (*SlogHandler)(nil)
+
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	c := h.logger.core
+	if c == nil {
+		return false
+	}
+	l := FromSlogLevel(level)
+	for _, w := range c.writers {
+		if w.enable(l) {
+			return true
+		}
+	}
+	return false
+}
+
+// slogFields 将属性展开为字段，分组属性递归展开
+func slogFields(fields []Field, prefix string, attrs ...slog.Attr) []Field {
+	for _, a := range attrs {
+		v := a.Value.Resolve()
+		if a.Equal(slog.Attr{}) {
+			continue
+		}
+		if v.Kind() == slog.KindGroup {
+			p := prefix
+			if a.Key != "" {
+				p += a.Key + "."
+			}
+			fields = slogFields(fields, p, v.Group()...)
+			continue
+		}
+		fields = append(fields, Field{prefix + a.Key, v.Any()})
+	}
+	return fields
+}
+
+func (h *SlogHandler) prefix() string {
+	if h.group == "" {
+		return ""
+	}
+	return h.group + "."
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := make([]Field, 0, len(h.logger.fields)+r.NumAttrs())
+	fields = append(fields, h.logger.fields...)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = slogFields(fields, h.prefix(), a)
+		return true
+	})
+
+	var (
+		file, fun string
+		line      int
+	)
+	if r.PC != 0 {
+		f, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		file, line, fun = f.File, f.Line, f.Function
+	}
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	h.logger.log(makeMessage(FromSlogLevel(r.Level), t, r.PC, file, line, fun, r.Message, fields, nil))
+	return nil
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make([]Field, 0, len(h.logger.fields)+len(attrs))
+	fields = append(fields, h.logger.fields...)
+	fields = slogFields(fields, h.prefix(), attrs...)
+	l := h.logger
+	l.fields = fields
+	return &SlogHandler{logger: l, group: h.group}
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &SlogHandler{logger: h.logger, group: h.prefix() + name}
+}
+
+// SlogWriter 通过已有的slog.Handler输出ylog的日志记录，字段转为属性，记录的调用者作为slog的来源
+type SlogWriter struct {
+	handler slog.Handler
+}
+
+func NewSlogWriter(handler slog.Handler) *SlogWriter {
+	return &SlogWriter{handler}
+}
+
+func (w *SlogWriter) writeRecord(m LogMessage) error {
+	r := slog.NewRecord(m.time, SlogLevel(m.level), m.text, m.pc)
+	for _, f := range m.fields {
+		r.AddAttrs(slog.Any(f.Key, f.Value))
+	}
+	return w.handler.Handle(context.Background(), r)
+}
+
+func (w *SlogWriter) enable(l int8) bool {
+	return w.handler.Enabled(context.Background(), SlogLevel(l))
+}
+
+// Write 已经渲染好的内容作为INFO级别的消息输出
+func (w *SlogWriter) Write(p []byte) (int, error) {
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, strings.TrimRight(string(p), "\r\n"), 0)
+	if err := w.handler.Handle(context.Background(), r); err != nil {
+		return 0, fmt.Errorf("failed to write log through slog: %w", err)
+	}
+	return len(p), nil
+}
+
+func (w *SlogWriter) flush() {}
+
+func (w *SlogWriter) clean() {}
@@ -0,0 +1,56 @@
+//go:build go1.21
+// +build go1.21
+
+package ylog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLevel(t *testing.T) {
+	for l := int8(ylog.TRACE); l <= ylog.FATAL; l++ {
+		assert.Equal(t, l, ylog.FromSlogLevel(ylog.SlogLevel(l)))
+	}
+	assert.Equal(t, int8(ylog.INFO), ylog.FromSlogLevel(slog.LevelInfo+2))
+}
+
+func TestSlogHandler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := ylog.NewLogger(ylog.NewConsoleWriterTo(buf, func(i int8) bool { return i >= ylog.DEBUG }, false))
+	sl := slog.New(ylog.NewSlogHandler(logger.With("job", "j1")))
+
+	assert.False(t, sl.Enabled(context.Background(), slog.LevelDebug-4))
+	sl.Log(context.Background(), slog.LevelDebug-4, "trace level is filtered", "k", 0)
+	sl.With("app", "db").WithGroup("req").Warn("slow query", "ms", 1500, slog.Group("sql", "table", "t1"))
+
+	assert.Regexp(t, `^\[.+\] \[WARN \] \[ylog/slog_test\.go:\d+\] - slow query job=j1 app=db req\.ms=1500 req\.sql\.table=t1\n$`, buf.String())
+}
+
+func TestSlogWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	h := slog.NewJSONHandler(buf, &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug})
+	logger := ylog.NewLogger(ylog.NewSlogWriter(h))
+
+	logger.Trace("not enabled in the handler")
+	logger.ErrorKV("backup failed", "app", "db", "err", errors.New("disk full"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 1)
+	var rec map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, "ERROR", rec["level"])
+	assert.Equal(t, "backup failed", rec["msg"])
+	assert.Equal(t, "db", rec["app"])
+	assert.Equal(t, "disk full", rec["err"])
+	src, _ := rec["source"].(map[string]any)
+	assert.True(t, strings.HasSuffix(src["file"].(string), "slog_test.go"), "source should be the caller: %v", src)
+}
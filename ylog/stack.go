@@ -0,0 +1,109 @@
+package ylog
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	DEFAULT_STACK_DEPTH = 32
+	STACK_PREFIX        = "\tat " // 文本日志中调用栈每一帧的前缀
+)
+
+// Frame 调用栈中的一帧
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// StackOptions 自动附加调用栈的条件，零值不附加
+type StackOptions struct {
+	Levels  LevelFilter // 满足的级别附加调用栈，为nil时不按照级别附加
+	OnError bool        // 字段或者格式化参数中有error时附加调用栈
+	Depth   int         // 最多记录的帧数，不大于0时使用DEFAULT_STACK_DEPTH
+}
+
+// DefaultStackOptions ERROR、FATAL以及带有error的日志附加调用栈
+var DefaultStackOptions = StackOptions{
+	Levels:  func(l int8) bool { return l >= ERROR },
+	OnError: true,
+}
+
+// need 判断日志是否需要附加调用栈
+func (o *StackOptions) need(level int8, fields []Field, args []any) bool {
+	if o.Levels != nil && o.Levels(level) {
+		return true
+	}
+	if !o.OnError {
+		return false
+	}
+	for _, f := range fields {
+		if _, ok := f.Value.(error); ok {
+			return true
+		}
+	}
+	for _, a := range args {
+		if _, ok := a.(error); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// captureStack 从调用captureStack的函数向上跳过skip层开始记录调用栈
+func captureStack(skip, depth int) []Frame {
+	if depth <= 0 {
+		depth = DEFAULT_STACK_DEPTH
+	}
+	pcs := make([]uintptr, depth)
+	n := runtime.Callers(skip+2, pcs)
+	if n == 0 {
+		return nil
+	}
+	frames := runtime.CallersFrames(pcs[:n])
+	res := make([]Frame, 0, n)
+	for {
+		f, more := frames.Next()
+		res = append(res, Frame{f.Function, f.File, f.Line})
+		if !more {
+			break
+		}
+	}
+	return res
+}
+
+// formatStack 每一帧一行，以STACK_PREFIX开头，函数去掉包的路径，文件保留两级路径
+func formatStack(b *strings.Builder, stack []Frame) {
+	for _, f := range stack {
+		b.WriteByte('\n')
+		b.WriteString(STACK_PREFIX)
+		b.WriteString(f.Function[strings.LastIndexByte(f.Function, '/')+1:])
+		b.WriteString(" (")
+		b.WriteString(SubPath(f.File, 2))
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(f.Line))
+		b.WriteByte(')')
+	}
+}
+
+// WithCallerSkip 返回调用者向上多跳过skip层的Logger，可以累加。
+// 封装Logger的函数使用它记录封装函数的调用者，而不是封装函数本身
+func (log *Logger) WithCallerSkip(skip int) Logger {
+	l := *log
+	l.skip += skip
+	return l
+}
+
+// WithStack 返回按照opts自动附加调用栈的Logger，与原Logger共享输出目标
+func (log *Logger) WithStack(opts StackOptions) Logger {
+	l := *log
+	l.stack = &opts
+	return l
+}
+
+// Stack 日志记录附加的调用栈，从记录日志的位置开始，没有附加时为nil
+func (m LogMessage) Stack() []Frame {
+	return append([]Frame(nil), m.stack...)
+}
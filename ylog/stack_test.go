@@ -0,0 +1,142 @@
+package ylog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+// logVia 封装Logger的函数，跳过一层之后调用者为logVia的调用者
+func logVia(logger ylog.Logger, msg string) {
+	logger.Info(msg)
+}
+
+func line() int {
+	_, _, ln, _ := runtime.Caller(1)
+	return ln
+}
+
+func TestWithCallerSkip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := ylog.NewLogger(ylog.NewConsoleWriterTo(buf, all, false))
+
+	logVia(logger, "wrapped")
+	assert.NotContains(t, buf.String(), fmt.Sprintf("stack_test.go:%d]", line()-1))
+
+	buf.Reset()
+	skipped := logger.WithCallerSkip(1)
+	logVia(skipped, "wrapped")
+	assert.Contains(t, buf.String(), fmt.Sprintf("[ylog/stack_test.go:%d] - wrapped", line()-1))
+
+	// With保留跳过的层数
+	buf.Reset()
+	derived := skipped.With("k", "v")
+	logVia(derived, "wrapped")
+	assert.Contains(t, buf.String(), fmt.Sprintf("[ylog/stack_test.go:%d] - wrapped k=v", line()-1))
+}
+
+func TestStackOnErrorLevel(t *testing.T) {
+	buf := new(bytes.Buffer)
+	base := ylog.NewLogger(ylog.NewConsoleWriterTo(buf, all, false))
+	logger := base.WithStack(ylog.DefaultStackOptions)
+
+	logger.Info("no stack")
+	assert.NotContains(t, buf.String(), ylog.STACK_PREFIX)
+
+	buf.Reset()
+	logger.Error("failed")
+	ln := line() - 1
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if assert.Greater(t, len(lines), 1) {
+		assert.Regexp(t, `\[ylog/stack_test\.go:\d+\] - failed$`, lines[0])
+		assert.Equal(t, fmt.Sprintf("%sylog_test.TestStackOnErrorLevel (ylog/stack_test.go:%d)", ylog.STACK_PREFIX, ln), lines[1])
+		assert.True(t, strings.HasPrefix(lines[2], ylog.STACK_PREFIX+"testing.tRunner "))
+	}
+
+	// 没有设置时不附加
+	buf.Reset()
+	base.Error("failed")
+	assert.NotContains(t, buf.String(), ylog.STACK_PREFIX)
+}
+
+func TestStackOnErrorValue(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := ylog.NewLogger(ylog.NewConsoleWriterTo(buf, all, false))
+	logger = logger.WithStack(ylog.StackOptions{OnError: true, Depth: 1})
+	err := errors.New("disk full")
+
+	logger.Warn("retrying: %v", err)
+	assert.Equal(t, 1, strings.Count(buf.String(), ylog.STACK_PREFIX))
+
+	buf.Reset()
+	logger.WarnKV("retrying", "err", err)
+	assert.Regexp(t, `- retrying err="disk full"\n\tat ylog_test\.TestStackOnErrorValue \(ylog/stack_test\.go:\d+\)\n$`, buf.String())
+
+	buf.Reset()
+	logger.Error("no error value")
+	assert.NotContains(t, buf.String(), ylog.STACK_PREFIX)
+
+	// 绑定的error字段同样附加调用栈
+	buf.Reset()
+	bound := logger.With("cause", err)
+	bound.Info("bound")
+	assert.Contains(t, buf.String(), ylog.STACK_PREFIX)
+}
+
+func TestStackInJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := ylog.NewLogger(ylog.NewJSONWriter(buf, all))
+	logger = logger.WithStack(ylog.DefaultStackOptions)
+	logger.Error("failed")
+	logger.Info("plain")
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	var rec struct {
+		Caller struct {
+			Function string `json:"function"`
+		} `json:"caller"`
+		Stack []struct {
+			File     string `json:"file"`
+			Line     int    `json:"line"`
+			Function string `json:"function"`
+		} `json:"stack"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, "gitea.fcdm.top/lixuan/keen/ylog_test.TestStackInJSON", rec.Caller.Function)
+	if assert.NotEmpty(t, rec.Stack) {
+		assert.Equal(t, rec.Caller.Function, rec.Stack[0].Function)
+		assert.True(t, strings.HasSuffix(rec.Stack[0].File, "ylog/stack_test.go"))
+		assert.Greater(t, rec.Stack[0].Line, 0)
+	}
+	assert.NotContains(t, lines[1], `"stack"`)
+}
+
+func TestReadStack(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := ylog.NewLogger(ylog.NewConsoleWriterTo(buf, all, false))
+	logger = logger.WithStack(ylog.StackOptions{OnError: true, Depth: 2})
+	logger.ErrorKV("failed", "err", errors.New("boom"))
+
+	res := make([]ylog.Record, 0)
+	assert.NoError(t, ylog.ParseRecords(buf, "", func(r ylog.Record) bool {
+		res = append(res, r)
+		return true
+	}))
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, "failed", res[0].Text)
+		assert.Equal(t, []ylog.Field{{Key: "err", Value: "boom"}}, res[0].Fields)
+		if assert.Len(t, res[0].Stack, 2) {
+			assert.Regexp(t, `^ylog_test\.TestReadStack \(ylog/stack_test\.go:\d+\)$`, res[0].Stack[0])
+		}
+	}
+}
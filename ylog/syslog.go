@@ -0,0 +1,386 @@
+package ylog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SYSLOG_SOCKET        = "/dev/log"
+	JOURNAL_SOCKET       = "/run/systemd/journal/socket"
+	JOURNAL_FIELD_PREFIX = "KEEN_" // 与journald定义的字段同名的日志字段加上的前缀
+	SYSLOG_TIME_FMT      = "2006-01-02T15:04:05.000000Z07:00"
+	SYSLOG_SD_ID         = "keen@32473" // 默认的结构化数据ID。占位值：32473是RFC 5612保留给文档示例的企业编号，正式部署前需要通过SyslogOptions.SDID替换为申请的编号
+	SYSLOG_NETWORK_UNIX  = "unixgram"
+	SYSLOG_NETWORK_UDP   = "udp"
+	SYSLOG_NETWORK_TCP   = "tcp"
+)
+
+// syslog的设施，应用不能使用kern(0)
+const (
+	SYSLOG_USER   = 1
+	SYSLOG_DAEMON = 3
+	SYSLOG_LOCAL0 = 16
+	SYSLOG_LOCAL1 = 17
+	SYSLOG_LOCAL2 = 18
+	SYSLOG_LOCAL3 = 19
+	SYSLOG_LOCAL4 = 20
+	SYSLOG_LOCAL5 = 21
+	SYSLOG_LOCAL6 = 22
+	SYSLOG_LOCAL7 = 23
+)
+
+// SyslogSeverity ylog级别对应的syslog严重程度，TRACE和DEBUG都为debug(7)，FATAL为crit(2)
+func SyslogSeverity(level int8) int {
+	switch level {
+	case TRACE, DEBUG:
+		return 7
+	case INFO:
+		return 6
+	case WARN:
+		return 4
+	case ERROR:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// datagramConn 面向报文或者流的连接，写入失败时重新连接并重试一次
+type datagramConn struct {
+	network string
+	addr    string
+	conn    net.Conn
+	mu      sync.Mutex
+}
+
+// connect 创建时连接一次以尽早发现错误，断开之后在发送时重连
+func (c *datagramConn) connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn, err := net.Dial(c.network, c.addr)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+func (c *datagramConn) send(bs []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for i := 0; i < 2; i++ {
+		if c.conn == nil {
+			if c.conn, err = net.Dial(c.network, c.addr); err != nil {
+				c.conn = nil
+				continue
+			}
+		}
+		if _, err = c.conn.Write(bs); err == nil {
+			return nil
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+	return err
+}
+
+func (c *datagramConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// SyslogOptions syslog的配置，零值表示以USER设施发送到本机的/dev/log
+type SyslogOptions struct {
+	Network  string // unixgram、udp或者tcp，为空时使用unixgram
+	Addr     string // 为空时使用SYSLOG_SOCKET
+	Facility int    // 为0时使用SYSLOG_USER
+	AppName  string // 为空时使用当前可执行文件的名称
+	Hostname string // 为空时使用os.Hostname
+	SDID     string // 结构化数据的ID，格式为 名称@企业编号，为空时使用占位的SYSLOG_SD_ID
+}
+
+// SyslogWriter 按照RFC 5424格式发送日志，字段和调用者放在SyslogOptions.SDID的结构化数据中。
+// tcp按照RFC 6587的字节计数方式分帧
+type SyslogWriter struct {
+	levelFilter LevelFilter
+	opts        SyslogOptions
+	pid         int
+	conn        *datagramConn
+}
+
+func NewSyslogWriter(level LevelFilter, opts SyslogOptions) (*SyslogWriter, error) {
+	if opts.Network == "" {
+		opts.Network = SYSLOG_NETWORK_UNIX
+	}
+	if opts.Network != SYSLOG_NETWORK_UNIX && opts.Network != SYSLOG_NETWORK_UDP && opts.Network != SYSLOG_NETWORK_TCP {
+		return nil, fmt.Errorf("unsupported syslog network [%s]", opts.Network)
+	}
+	if opts.Addr == "" {
+		opts.Addr = SYSLOG_SOCKET
+	}
+	if opts.Facility == 0 {
+		opts.Facility = SYSLOG_USER
+	}
+	if opts.AppName == "" {
+		opts.AppName = filepath.Base(os.Args[0])
+	}
+	if opts.Hostname == "" {
+		opts.Hostname, _ = os.Hostname()
+	}
+	if opts.SDID == "" {
+		opts.SDID = SYSLOG_SD_ID
+	}
+
+	w := &SyslogWriter{
+		levelFilter: level,
+		opts:        opts,
+		pid:         os.Getpid(),
+		conn:        &datagramConn{network: opts.Network, addr: opts.Addr},
+	}
+	if err := w.conn.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// headerValue RFC 5424头部的字段只能是可打印的ASCII字符，为空时使用-
+func headerValue(s string, max int) string {
+	b := strings.Builder{}
+	for _, r := range s {
+		if r > ' ' && r < 0x7f {
+			b.WriteRune(r)
+		}
+	}
+	res := b.String()
+	if res == "" {
+		return "-"
+	}
+	if len(res) > max {
+		res = res[:max]
+	}
+	return res
+}
+
+// sdName 结构化数据的参数名称不能包含=、空格、]和"，最长32个字符
+func sdName(s string) string {
+	b := strings.Builder{}
+	for _, r := range s {
+		if r > ' ' && r < 0x7f && r != '=' && r != ']' && r != '"' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	res := b.String()
+	if len(res) > 32 {
+		res = res[:32]
+	}
+	return res
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func (w *SyslogWriter) format(severity int, m LogMessage, withSD bool) []byte {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "<%d>1 %s %s %s %d - ",
+		w.opts.Facility*8+severity, m.time.Format(SYSLOG_TIME_FMT),
+		headerValue(w.opts.Hostname, 255), headerValue(w.opts.AppName, 48), w.pid)
+
+	if withSD {
+		buf.WriteString("[" + w.opts.SDID)
+		fmt.Fprintf(buf, ` caller="%s"`, sdEscaper.Replace(SubPath(m.file, 2)+":"+strconv.Itoa(m.line)))
+		for _, f := range m.fields {
+			if n := sdName(f.Key); n != "" {
+				fmt.Fprintf(buf, ` %s="%s"`, n, sdEscaper.Replace(fieldString(f.Value)))
+			}
+		}
+		buf.WriteString("] ")
+	} else {
+		buf.WriteString("- ")
+	}
+	buf.WriteString(m.text)
+
+	if w.opts.Network != SYSLOG_NETWORK_TCP {
+		return buf.Bytes()
+	}
+	return append([]byte(strconv.Itoa(buf.Len())+" "), buf.Bytes()...)
+}
+
+func (w *SyslogWriter) writeRecord(m LogMessage) error {
+	return w.conn.send(w.format(SyslogSeverity(m.level), m, true))
+}
+
+func (w *SyslogWriter) enable(l int8) bool {
+	return w.levelFilter(l)
+}
+
+// Write 已经渲染好的内容作为INFO级别、没有结构化数据的消息发送
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	m := LogMessage{time: time.Now(), text: strings.TrimRight(string(p), "\r\n")}
+	if err := w.conn.send(w.format(SyslogSeverity(INFO), m, false)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *SyslogWriter) flush() {}
+
+func (w *SyslogWriter) clean() {
+	w.conn.close()
+}
+
+// JournalWriter 通过journald的原生协议发送日志，字段名称转为大写的journal字段，
+// 与journald定义的字段同名时加上JOURNAL_FIELD_PREFIX，与同一条日志中前面的字段同名时加上序号。
+// 单条日志不能超过unix报文的大小限制，journald通过memfd传递大日志的方式没有实现
+type JournalWriter struct {
+	levelFilter LevelFilter
+	identifier  string
+	conn        *datagramConn
+}
+
+// NewJournalWriter addr为空时使用JOURNAL_SOCKET，identifier为空时使用当前可执行文件的名称
+func NewJournalWriter(level LevelFilter, addr, identifier string) (*JournalWriter, error) {
+	if addr == "" {
+		addr = JOURNAL_SOCKET
+	}
+	if identifier == "" {
+		identifier = filepath.Base(os.Args[0])
+	}
+	w := &JournalWriter{
+		levelFilter: level,
+		identifier:  identifier,
+		conn:        &datagramConn{network: SYSLOG_NETWORK_UNIX, addr: addr},
+	}
+	if err := w.conn.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+var errJournalField = errors.New("the journal field name is empty")
+
+// journalReserved journald定义的用户字段（systemd.journal-fields(7)），日志的字段不能覆盖
+var journalReserved = map[string]bool{
+	"MESSAGE": true, "MESSAGE_ID": true, "PRIORITY": true, "CODE_FILE": true, "CODE_LINE": true, "CODE_FUNC": true,
+	"ERRNO": true, "INVOCATION_ID": true, "USER_INVOCATION_ID": true, "SYSLOG_FACILITY": true, "SYSLOG_IDENTIFIER": true,
+	"SYSLOG_PID": true, "SYSLOG_TIMESTAMP": true, "SYSLOG_RAW": true, "DOCUMENTATION": true, "TID": true,
+	"UNIT": true, "USER_UNIT": true,
+}
+
+// fitJournalName 加上前缀和后缀之后不超过64个字符
+func fitJournalName(prefix, name, suffix string) string {
+	if n := 64 - len(prefix) - len(suffix); len(name) > n {
+		name = name[:n]
+	}
+	return prefix + name + suffix
+}
+
+// uniqueJournalName 字段的journal名称，保留字段加上JOURNAL_FIELD_PREFIX，used中已有的名称加上序号，返回的名称会加入used
+func uniqueJournalName(key string, used map[string]bool) (string, error) {
+	name, err := journalName(key)
+	if err != nil {
+		return "", err
+	}
+	if journalReserved[name] {
+		name = fitJournalName(JOURNAL_FIELD_PREFIX, name, "")
+	}
+	res := name
+	for i := 2; used[res]; i++ {
+		res = fitJournalName("", name, "_"+strconv.Itoa(i))
+	}
+	used[res] = true
+	return res, nil
+}
+
+// journalName journal字段名称只能包含大写字母、数字和下划线，不能以下划线或者数字开头
+func journalName(s string) (string, error) {
+	b := strings.Builder{}
+	for _, r := range strings.ToUpper(s) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	res := strings.TrimLeft(b.String(), "_0123456789")
+	if res == "" {
+		return "", errJournalField
+	}
+	if len(res) > 64 {
+		res = res[:64]
+	}
+	return res, nil
+}
+
+// appendJournalField 值包含换行时使用长度前缀的二进制格式
+func appendJournalField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(name + "=" + value + "\n")
+		return
+	}
+	buf.WriteString(name + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+func (w *JournalWriter) format(m LogMessage) []byte {
+	buf := new(bytes.Buffer)
+	appendJournalField(buf, "MESSAGE", m.text)
+	appendJournalField(buf, "PRIORITY", strconv.Itoa(SyslogSeverity(m.level)))
+	appendJournalField(buf, "SYSLOG_IDENTIFIER", w.identifier)
+	if m.file != "" {
+		appendJournalField(buf, "CODE_FILE", m.file)
+		appendJournalField(buf, "CODE_LINE", strconv.Itoa(m.line))
+	}
+	if m.function != "" {
+		appendJournalField(buf, "CODE_FUNC", m.function)
+	}
+	used := make(map[string]bool, len(m.fields))
+	for _, f := range m.fields {
+		name, err := uniqueJournalName(f.Key, used)
+		if err != nil {
+			continue
+		}
+		appendJournalField(buf, name, fieldString(f.Value))
+	}
+	return buf.Bytes()
+}
+
+func (w *JournalWriter) writeRecord(m LogMessage) error {
+	return w.conn.send(w.format(m))
+}
+
+func (w *JournalWriter) enable(l int8) bool {
+	return w.levelFilter(l)
+}
+
+// Write 已经渲染好的内容作为INFO级别的消息发送
+func (w *JournalWriter) Write(p []byte) (int, error) {
+	m := LogMessage{level: INFO, text: strings.TrimRight(string(p), "\r\n")}
+	if err := w.conn.send(w.format(m)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *JournalWriter) flush() {}
+
+func (w *JournalWriter) clean() {
+	w.conn.close()
+}
@@ -0,0 +1,107 @@
+//go:build linux || darwin || aix
+// +build linux darwin aix
+
+package ylog_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+func listenUnixgram(t *testing.T) *net.UnixConn {
+	addr := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestSyslogWriterUnix(t *testing.T) {
+	conn := listenUnixgram(t)
+	w, err := ylog.NewSyslogWriter(all, ylog.SyslogOptions{Addr: conn.LocalAddr().String(), AppName: "provider", Hostname: "h"})
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+	defer logger.Clean()
+
+	logger.Debug("unix socket")
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^<15>1 \S+ h provider \d+ - \[keen@32473 caller="ylog/syslog_nix_test\.go:\d+"\] unix socket$`, string(buf[:n]))
+}
+
+// parseJournal 解析journald原生协议的报文
+func parseJournal(t *testing.T, bs []byte) map[string]string {
+	res := make(map[string]string)
+	for len(bs) > 0 {
+		i := bytes.IndexByte(bs, '\n')
+		assert.GreaterOrEqual(t, i, 0)
+		line := string(bs[:i])
+		bs = bs[i+1:]
+		if k, v, ok := strings.Cut(line, "="); ok {
+			res[k] = v
+			continue
+		}
+		n := binary.LittleEndian.Uint64(bs[:8])
+		res[line] = string(bs[8 : 8+n])
+		assert.Equal(t, byte('\n'), bs[8+n])
+		bs = bs[9+n:]
+	}
+	return res
+}
+
+func TestJournalWriter(t *testing.T) {
+	conn := listenUnixgram(t)
+	w, err := ylog.NewJournalWriter(all, conn.LocalAddr().String(), "provider")
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+	defer logger.Clean()
+
+	job := logger.With("job.id", "j1")
+	job.ErrorKV("restore failed\ncaused by timeout", "_app", "db", "1retry", 3)
+
+	buf := make([]byte, 65536)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	rec := parseJournal(t, buf[:n])
+	assert.Equal(t, "restore failed\ncaused by timeout", rec["MESSAGE"])
+	assert.Equal(t, "3", rec["PRIORITY"])
+	assert.Equal(t, "provider", rec["SYSLOG_IDENTIFIER"])
+	assert.True(t, strings.HasSuffix(rec["CODE_FILE"], "syslog_nix_test.go"), rec["CODE_FILE"])
+	assert.NotEmpty(t, rec["CODE_LINE"])
+	assert.Contains(t, rec["CODE_FUNC"], "TestJournalWriter")
+	assert.Equal(t, "j1", rec["JOB_ID"])
+	assert.Equal(t, "db", rec["APP"], "leading underscores are reserved for trusted fields")
+	assert.Equal(t, "3", rec["RETRY"])
+}
+
+func TestJournalReservedFields(t *testing.T) {
+	conn := listenUnixgram(t)
+	w, err := ylog.NewJournalWriter(all, conn.LocalAddr().String(), "provider")
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+	defer logger.Clean()
+
+	logger.InfoKV("real message", "message", "user message", "priority", "high", "job.id", "j1", "job_id", "j2", "JOB-ID", "j3")
+
+	buf := make([]byte, 65536)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	rec := parseJournal(t, buf[:n])
+	assert.Equal(t, "real message", rec["MESSAGE"], "fields should not overwrite the journal message")
+	assert.Equal(t, "6", rec["PRIORITY"])
+	assert.Equal(t, "user message", rec[ylog.JOURNAL_FIELD_PREFIX+"MESSAGE"])
+	assert.Equal(t, "high", rec[ylog.JOURNAL_FIELD_PREFIX+"PRIORITY"])
+	assert.Equal(t, "j1", rec["JOB_ID"])
+	assert.Equal(t, "j2", rec["JOB_ID_2"])
+	assert.Equal(t, "j3", rec["JOB_ID_3"])
+}
@@ -0,0 +1,105 @@
+package ylog_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gitea.fcdm.top/lixuan/keen/ylog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogSeverity(t *testing.T) {
+	assert.Equal(t, []int{7, 7, 6, 4, 3, 2}, []int{
+		ylog.SyslogSeverity(ylog.TRACE), ylog.SyslogSeverity(ylog.DEBUG), ylog.SyslogSeverity(ylog.INFO),
+		ylog.SyslogSeverity(ylog.WARN), ylog.SyslogSeverity(ylog.ERROR), ylog.SyslogSeverity(ylog.FATAL),
+	})
+}
+
+func TestSyslogWriterUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	w, err := ylog.NewSyslogWriter(all, ylog.SyslogOptions{
+		Network:  ylog.SYSLOG_NETWORK_UDP,
+		Addr:     pc.LocalAddr().String(),
+		Facility: ylog.SYSLOG_LOCAL0,
+		AppName:  "provider",
+		Hostname: "host-1",
+	})
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+	defer logger.Clean()
+
+	logger.WarnKV("disk is almost full", "path", `/data "a]"`, "used percent", 95)
+
+	buf := make([]byte, 4096)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	assert.NoError(t, err)
+	// local0(16)*8 + warning(4) = 132
+	assert.Regexp(t, `^<132>1 \d{4}-\d\d-\d\dT\S+ host-1 provider \d+ - \[keen@32473 caller="ylog/syslog_test\.go:\d+" path="/data \\"a\\]\\"" used_percent="95"\] disk is almost full$`, string(buf[:n]))
+}
+
+func TestSyslogWriterSDID(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	w, err := ylog.NewSyslogWriter(all, ylog.SyslogOptions{Network: ylog.SYSLOG_NETWORK_UDP, Addr: pc.LocalAddr().String(), SDID: "provider@55555"})
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+	defer logger.Clean()
+
+	logger.Info("custom id")
+	buf := make([]byte, 4096)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Contains(t, string(buf[:n]), ` - [provider@55555 caller="`)
+}
+
+func TestSyslogWriterTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	w, err := ylog.NewSyslogWriter(all, ylog.SyslogOptions{Network: ylog.SYSLOG_NETWORK_TCP, Addr: l.Addr().String(), AppName: "provider"})
+	assert.NoError(t, err)
+	logger := ylog.NewLogger(w)
+	conn, err := l.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	logger.Info("first")
+	logger.Error("second\nwith two lines")
+	logger.Clean()
+
+	// RFC 6587字节计数分帧：长度 空格 消息
+	r := bufio.NewReader(conn)
+	msgs := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		ls, err := r.ReadString(' ')
+		assert.NoError(t, err)
+		n, err := strconv.Atoi(strings.TrimSpace(ls))
+		assert.NoError(t, err)
+		bs := make([]byte, n)
+		_, err = io.ReadFull(r, bs)
+		assert.NoError(t, err)
+		msgs = append(msgs, string(bs))
+	}
+	assert.True(t, strings.HasPrefix(msgs[0], "<14>1 "), msgs[0])
+	assert.True(t, strings.HasSuffix(msgs[0], "] first"), msgs[0])
+	assert.True(t, strings.HasPrefix(msgs[1], "<11>1 "), msgs[1])
+	assert.True(t, strings.HasSuffix(msgs[1], "] second\nwith two lines"), msgs[1])
+}
+
+func TestSyslogWriterUnsupported(t *testing.T) {
+	_, err := ylog.NewSyslogWriter(all, ylog.SyslogOptions{Network: "udp6"})
+	assert.Error(t, err)
+}
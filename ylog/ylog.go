@@ -3,12 +3,11 @@ package ylog
 import (
 	"fmt"
 	"io"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
@@ -25,7 +24,7 @@ const (
 
 const (
 	LOG_TIME_FMT   = "2006-01-02 15:04:05"
-	LOG_MSG_FORMAT = "[%s] [%-5s] [%s:%d] - %s"
+	LOG_MSG_FORMAT = "[%s] [%-5s] [%s:%d] - %s" // 默认的日志格式，与LOG_TEMPLATE相同
 )
 
 func parseLogLevel(l int) string {
@@ -50,13 +49,25 @@ func parseLogLevel(l int) string {
 type LevelFilter = func(int8) bool
 type Archive = func(fn string) (bool, string)
 
+// LogMessage 一条日志记录，msg为按照DefaultTemplate渲染之后的文本，其他字段供结构化的输出和Formatter使用
 type LogMessage struct {
-	level int8
-	msg   string
-}
-
+	level     int8
+	msg       string
+	time      time.Time
+	pc        uintptr
+	file      string
+	line      int
+	function  string
+	text      string
+	fields    []Field
+	goroutine uint64
+	stack     []Frame
+}
+
+// LogWriter 日志的输出目标，writeRecord和Write都需要在内部保证并发安全。
+// Logger通过writeRecord输出日志记录，Write用于直接写入已经渲染好的内容
 type LogWriter interface {
-	msg(LogMessage)
+	writeRecord(LogMessage) error
 	enable(int8) bool
 	flush()
 	clean()
@@ -65,15 +76,21 @@ type LogWriter interface {
 
 type ConsoleWriter struct {
 	levelFilter func(int8) bool
+	formatter   Formatter
+	out         io.Writer
 	colored     bool
 	colors      []*color.Color
-	curMsg      LogMessage
-	ai          atomic.Bool
+	mu          sync.Mutex
 }
 
 func NewConsoleWriter(level LevelFilter, colourful bool) *ConsoleWriter {
+	return NewConsoleWriterTo(os.Stdout, level, colourful)
+}
+
+// NewConsoleWriterTo 创建输出到out的ConsoleWriter
+func NewConsoleWriterTo(out io.Writer, level LevelFilter, colourful bool) *ConsoleWriter {
 	res := new(ConsoleWriter)
-	res.ai = atomic.Bool{}
+	res.out = out
 	res.levelFilter = level
 	if colourful {
 		traceC := color.New(color.FgCyan)
@@ -91,35 +108,48 @@ func NewConsoleWriter(level LevelFilter, colourful bool) *ConsoleWriter {
 	return res
 }
 
-func (w *ConsoleWriter) msg(m LogMessage) {
-	for !w.ai.CompareAndSwap(false, true) {
+// SetFormatter 设置日志的格式，为nil时使用DefaultTemplate
+func (w *ConsoleWriter) SetFormatter(f Formatter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.formatter = f
+}
+
+// writeRecord 按照日志记录的级别着色输出，着色和内容需要在同一次加锁中写入
+func (w *ConsoleWriter) writeRecord(m LogMessage) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	bs := render(w.formatter, m)
+	var err error
+	if w.colored && int(m.level) < len(w.colors) {
+		_, err = w.colors[m.level].Fprint(w.out, string(bs))
+	} else {
+		_, err = w.out.Write(bs)
 	}
-	w.curMsg = m
+	return err
 }
 
 func (w *ConsoleWriter) enable(l int8) bool {
 	return w.levelFilter(l)
 }
 
+// Write 不着色直接输出
 func (w *ConsoleWriter) Write(bs []byte) (int, error) {
-	var (
-		n   int
-		err error
-	)
-	if w.colored {
-		n, err = w.colors[w.curMsg.level].Fprint(os.Stdout, string(bs))
-	} else {
-		n, err = os.Stdout.Write(bs)
-	}
-	w.ai.CompareAndSwap(true, false)
-	return n, err
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Write(bs)
 }
 
 func (w *ConsoleWriter) flush() {
-	os.Stdout.Sync()
+	if f, ok := w.out.(*os.File); ok {
+		f.Sync()
+	}
 }
 
 func (w *ConsoleWriter) clean() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	if w.colored {
 		for _, c := range w.colors {
 			c.DisableColor()
@@ -128,86 +158,28 @@ func (w *ConsoleWriter) clean() {
 }
 
 type FileWriter struct {
+	formatter   Formatter
 	logDir      string
+	name        string
+	path        string
 	file        *os.File
 	expire      time.Duration
 	archive     Archive
 	levelFilter LevelFilter
+	rotate      RotateOptions
+	size        int64
+	opened      time.Time
+	rotateErrs  int // 连续轮转失败的次数，只在第一次失败和恢复时输出
+	mu          sync.Mutex
+	maintainMu  sync.Mutex
 }
 
-func DeleteExpiredLogAndArchive(logDir string, expire time.Duration, archive Archive) {
-	var (
-		isArc bool
-		arcMp map[string][]string = make(map[string][]string)
-	)
-
-	if archive != nil {
-		isArc = true
-	}
-
-	n := time.Now()
-	st := n.Add(-expire)
-	absPath, _ := filepath.Abs(logDir)
-	Infof("delete expired log files in [%s]: motification datetime <= %s\n", absPath, st.Format(LOG_TIME_FMT))
-	err := filepath.WalkDir(absPath, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if path == absPath {
-			return nil
-		}
-
-		if d.IsDir() {
-			return filepath.SkipDir
-		}
-
-		fi, err := d.Info()
-		if err != nil {
-			return err
-		}
-
-		if expire != 0 && fi.ModTime().Before(st) {
-			err = os.Remove(path)
-			if err != nil {
-				Errorf("failed to delete log file [%s]: %v\n", path, err)
-			}
-		} else {
-			if isArc {
-				if b, dst := archive(d.Name()); b {
-					arcMp[dst] = append(arcMp[dst], path)
-				}
-			}
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		Errorf("error occurred while clean expired log files: %v\n", err)
-	}
-
-	for dir, fs := range arcMp {
-		ndir := filepath.Join(absPath, dir)
-		err := os.Mkdir(ndir, os.ModeDir)
-		if err != nil {
-			if !os.IsExist(err) {
-				Errorf("failed to create archive directory [%s]: %v\n", ndir, err)
-				continue
-			}
-		}
-
-		for _, f := range fs {
-			np := filepath.Join(ndir, filepath.Base(f))
-			err := os.Rename(f, np)
-			if err != nil {
-				Errorf("failed to move the log file from [%s] to [%s]: %v\n", f, np, err)
-			}
-		}
-	}
+func NewFileWriter(logPath string, filename string, level LevelFilter, expire time.Duration, archive Archive) (*FileWriter, error) {
+	return NewRotatingFileWriter(logPath, filename, level, expire, archive, RotateOptions{})
 }
 
-func NewFileWriter(logPath string, filename string, level LevelFilter, expire time.Duration, archive Archive) (*FileWriter, error) {
+// NewRotatingFileWriter 创建按照大小或者时间轮转的文件日志，每次轮转之后都会删除过期日志并归档
+func NewRotatingFileWriter(logPath string, filename string, level LevelFilter, expire time.Duration, archive Archive, rotate RotateOptions) (*FileWriter, error) {
 	f := filepath.Join(logPath, filename)
 	absf, err := filepath.Abs(f)
 	if err != nil {
@@ -218,37 +190,51 @@ func NewFileWriter(logPath string, filename string, level LevelFilter, expire ti
 	dir, err := os.Stat(pd)
 	if err != nil {
 		if os.IsNotExist(err) {
-			err = os.MkdirAll(pd, os.ModeDir|700)
+			err = os.MkdirAll(pd, os.ModeDir|0700)
 			if err != nil {
 				return nil, err
 			}
 		} else {
 			return nil, err
 		}
-	}
-
-	if !dir.IsDir() {
+	} else if !dir.IsDir() {
 		return nil, fmt.Errorf("the path of log directory [%s] is existed and it is a file path", absf)
 	}
 
-	// check expired log and archive log
-	DeleteExpiredLogAndArchive(logPath, expire, archive)
-
-	fs, err := os.Create(absf)
-	if err != nil {
-		return nil, err
-	}
-
 	wr := new(FileWriter)
 	wr.logDir = logPath
-	wr.file = fs
+	wr.name = filename
+	wr.path = absf
 	wr.expire = expire
 	wr.archive = archive
 	wr.levelFilter = level
+	wr.rotate = rotate
+
+	// check expired log and archive log
+	deleteExpiredLogAndArchive(logPath, expire, archive, absf, wr.isBackup, rotate.Archive)
+
+	if err := wr.open(false); err != nil {
+		return nil, err
+	}
 	return wr, nil
 }
 
-func (w *FileWriter) msg(LogMessage) {}
+// SetFormatter 设置日志的格式，为nil时使用DefaultTemplate
+func (w *FileWriter) SetFormatter(f Formatter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.formatter = f
+}
+
+// writeRecord 在锁外渲染日志，只在写入时持有锁
+func (w *FileWriter) writeRecord(m LogMessage) error {
+	w.mu.Lock()
+	f := w.formatter
+	w.mu.Unlock()
+
+	_, err := w.Write(render(f, m))
+	return err
+}
 
 func (w *FileWriter) enable(l int8) bool {
 	return w.levelFilter(l)
@@ -259,100 +245,281 @@ func (w *FileWriter) flush() {
 }
 
 func (w *FileWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	rotated := false
+	if w.shouldRotate(len(p)) {
+		if err := w.rotateFile(); err != nil {
+			if w.rotateErrs == 0 {
+				Errorf("failed to rotate the log file [%s], further errors are suppressed until it recovers: %v\n", w.path, err)
+			}
+			w.rotateErrs++
+		} else {
+			if w.rotateErrs > 0 {
+				Errorf("rotate the log file [%s] successfully after %d failures\n", w.path, w.rotateErrs)
+				w.rotateErrs = 0
+			}
+			rotated = true
+		}
+	}
+
 	// write log
-	return w.file.Write(p)
+	n, err = w.file.Write(p)
+	w.size += int64(n)
+	w.mu.Unlock()
+
+	if rotated {
+		w.maintain()
+	}
+	return n, err
 }
 
 func (w *FileWriter) clean() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	if err := w.file.Close(); err != nil {
 		Errorf("failed to close the log file: %v\n", err)
 	}
 }
 
-type Logger struct {
+func (w *FileWriter) label() string {
+	return w.path
+}
+
+// reopen 重新创建日志目录并以追加方式打开日志文件，日志文件或者目录被删除之后可以恢复写入
+func (w *FileWriter) reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(w.path), os.ModeDir|0700); err != nil {
+		return err
+	}
+	old := w.file
+	if err := w.open(true); err != nil {
+		return err
+	}
+	old.Close()
+	return nil
+}
+
+// core 日志的输出目标，通过With派生的Logger共享同一个core。
+// writers创建之后不再修改，输出时持有读锁，Clean持有写锁并标记closed，之后的日志会被忽略。
+// status与writers一一对应，记录没有自己维护健康状态的输出目标的写入失败
+type core struct {
 	writers []LogWriter
+	status  []*writerStatus
+	mu      sync.RWMutex
+	closed  bool
+}
+
+// Logger 日志记录器，fields为绑定到记录器上的字段，会附加到每一条日志中，skip为调用者额外跳过的层数，stack为附加调用栈的条件。
+// Logger可以在多个goroutine中并发使用，With返回新的Logger，不会修改原Logger
+type Logger struct {
+	core   *core
+	fields []Field
+	skip   int
+	stack  *StackOptions
 }
 
 func NewLogger(writers ...LogWriter) Logger {
+	status := make([]*writerStatus, len(writers))
+	for i := range status {
+		status[i] = new(writerStatus)
+	}
 	l := Logger{
-		writers: writers,
+		core: &core{writers: writers, status: status},
 	}
 
 	return l
 }
 
-func callInfo() (string, int) {
-	_, fn, ln, ok := runtime.Caller(3)
+// With 返回绑定了额外字段的Logger，与原Logger共享输出目标，参数格式与InfoKV相同
+func (log *Logger) With(kv ...any) Logger {
+	fields := make([]Field, 0, len(log.fields)+len(kv)/2)
+	fields = append(fields, log.fields...)
+	fields = append(fields, toFields(kv)...)
+	l := *log
+	l.fields = fields
+	return l
+}
+
+// Fields 返回绑定到Logger上的字段
+func (log *Logger) Fields() []Field {
+	return append([]Field(nil), log.fields...)
+}
+
+// callInfo 调用newMessage的日志方法的调用者，skip为额外跳过的层数
+func callInfo(skip int) (uintptr, string, int, string) {
+	pc, fn, ln, ok := runtime.Caller(3 + skip)
 	if !ok {
 		Errorf("failed to retrieve caller information\n")
+		return pc, fn, ln, ""
 	}
-	return fn, ln
+	var name string
+	if f := runtime.FuncForPC(pc); f != nil {
+		name = f.Name()
+	}
+	return pc, fn, ln, name
 }
 
-func groupInfo(level int, msg string, args ...any) string {
+// newMessage 生成日志记录，必须在Logger的日志方法中直接调用，以保证调用者信息和调用栈正确。
+// kv为附加的字段，args为格式化参数，只用于判断是否带有error
+func (log *Logger) newMessage(level int, text string, kv []any, args []any) LogMessage {
 	t := time.Now()
-	ts := t.Format(LOG_TIME_FMT)
-	fn, ln := callInfo()
-	fn = SubPath(fn, 2)
-	l := parseLogLevel(level)
-	msg = fmt.Sprintf(LOG_MSG_FORMAT, ts, l, fn, ln, fmt.Sprintf(msg, args...))
-
-	if runtime.GOOS == "windows" {
-		msg += "\r\n"
-	} else {
-		msg += "\n"
-	}
+	pc, fn, ln, fun := callInfo(log.skip)
 
-	return msg
+	fields := log.fields
+	if len(kv) > 0 {
+		fields = make([]Field, 0, len(log.fields)+len(kv)/2)
+		fields = append(fields, log.fields...)
+		fields = append(fields, toFields(kv)...)
+	}
+	var stack []Frame
+	if log.stack != nil && log.stack.need(int8(level), fields, args) {
+		stack = captureStack(2+log.skip, log.stack.Depth)
+	}
+	return makeMessage(int8(level), t, pc, fn, ln, fun, text, fields, stack)
+}
+
+// makeMessage 由各个部分生成日志记录并按照DefaultTemplate渲染文本，桥接其他日志库时使用其提供的时间和调用者
+func makeMessage(level int8, t time.Time, pc uintptr, fn string, ln int, fun string, text string, fields []Field, stack []Frame) LogMessage {
+	m := LogMessage{
+		level:    level,
+		time:     t,
+		pc:       pc,
+		file:     fn,
+		line:     ln,
+		function: fun,
+		text:     text,
+		fields:   fields,
+		stack:    stack,
+	}
+	if captureGoroutine.Load() {
+		m.goroutine = goroutineID()
+	}
+	m.msg = string(DefaultTemplate.Format(m))
+	return m
 }
 
 func (log *Logger) log(msg LogMessage) {
-	for _, writer := range log.writers {
+	if log.core == nil {
+		return
+	}
+	log.core.mu.RLock()
+	defer log.core.mu.RUnlock()
+	if log.core.closed {
+		return
+	}
+	for i, writer := range log.core.writers {
 		if writer.enable(msg.level) {
-			writer.msg(msg)
-			_, err := writer.Write([]byte(msg.msg))
-			if err != nil {
-				Errorf("failed to write log: %v\n", err)
+			err := writer.writeRecord(msg)
+			if _, ok := findHealthReporter(writer); !ok {
+				log.core.status[i].record(writer, err)
 			}
 			writer.flush()
 		}
 	}
 }
 
+// Health 返回每个输出目标的健康状态，顺序与创建Logger时相同
+func (log *Logger) Health() []WriterHealth {
+	if log.core == nil {
+		return nil
+	}
+	res := make([]WriterHealth, 0, len(log.core.writers))
+	for i, w := range log.core.writers {
+		if hr, ok := findHealthReporter(w); ok {
+			res = append(res, hr.health())
+		} else {
+			res = append(res, log.core.status[i].health(w))
+		}
+	}
+	return res
+}
+
+// Degraded 返回状态不是WRITER_HEALTHY的输出目标
+func (log *Logger) Degraded() []WriterHealth {
+	res := make([]WriterHealth, 0)
+	for _, h := range log.Health() {
+		if h.State != WRITER_HEALTHY {
+			res = append(res, h)
+		}
+	}
+	return res
+}
+
 func (log *Logger) Trace(msg string, args ...any) {
-	msg = groupInfo(TRACE, msg, args...)
-	log.log(LogMessage{TRACE, msg})
+	log.log(log.newMessage(TRACE, fmt.Sprintf(msg, args...), nil, args))
 }
 
 func (log *Logger) Debug(msg string, args ...any) {
-	msg = groupInfo(DEBUG, msg, args...)
-	log.log(LogMessage{DEBUG, msg})
+	log.log(log.newMessage(DEBUG, fmt.Sprintf(msg, args...), nil, args))
 }
 
 func (log *Logger) Info(msg string, args ...any) {
-	msg = groupInfo(INFO, msg, args...)
-	log.log(LogMessage{INFO, msg})
+	log.log(log.newMessage(INFO, fmt.Sprintf(msg, args...), nil, args))
 }
 
 func (log *Logger) Warn(msg string, args ...any) {
-	msg = groupInfo(WARN, msg, args...)
-	log.log(LogMessage{WARN, msg})
+	log.log(log.newMessage(WARN, fmt.Sprintf(msg, args...), nil, args))
 }
 
 func (log *Logger) Error(msg string, args ...any) {
-	msg = groupInfo(ERROR, msg, args...)
-	log.log(LogMessage{ERROR, msg})
+	log.log(log.newMessage(ERROR, fmt.Sprintf(msg, args...), nil, args))
 }
 
 func (log *Logger) Fatal(msg string, args ...any) {
-	msg = groupInfo(FATAL, msg, args...)
-	log.log(LogMessage{FATAL, msg})
+	log.log(log.newMessage(FATAL, fmt.Sprintf(msg, args...), nil, args))
+	log.crash()
+	log.Clean()
+	os.Exit(1)
+}
+
+// TraceKV 记录带有字段的日志，kv为交替出现的键和值，也可以直接传入Field
+func (log *Logger) TraceKV(msg string, kv ...any) {
+	log.log(log.newMessage(TRACE, msg, kv, nil))
+}
+
+func (log *Logger) DebugKV(msg string, kv ...any) {
+	log.log(log.newMessage(DEBUG, msg, kv, nil))
+}
+
+func (log *Logger) InfoKV(msg string, kv ...any) {
+	log.log(log.newMessage(INFO, msg, kv, nil))
+}
+
+func (log *Logger) WarnKV(msg string, kv ...any) {
+	log.log(log.newMessage(WARN, msg, kv, nil))
+}
+
+func (log *Logger) ErrorKV(msg string, kv ...any) {
+	log.log(log.newMessage(ERROR, msg, kv, nil))
+}
+
+func (log *Logger) FatalKV(msg string, kv ...any) {
+	log.log(log.newMessage(FATAL, msg, kv, nil))
+	log.crash()
 	log.Clean()
 	os.Exit(1)
 }
 
+// crash Fatal退出之前写入崩溃文件
+func (log *Logger) crash() {
+	if err := log.DumpCrash(); err != nil {
+		Errorf("%v\n", err)
+	}
+}
+
+// Clean 清理所有输出目标，之后通过该Logger及其派生的Logger记录的日志都会被忽略，可以重复调用
 func (log *Logger) Clean() {
-	for _, w := range log.writers {
+	if log.core == nil {
+		return
+	}
+	log.core.mu.Lock()
+	defer log.core.mu.Unlock()
+	if log.core.closed {
+		return
+	}
+	log.core.closed = true
+	for _, w := range log.core.writers {
 		w.clean()
 	}
 }
@@ -9,7 +9,7 @@ import (
 	"gitea.fcdm.top/lixuan/keen/ylog"
 )
 
-func TestylogLevel(t *testing.T) {
+func TestYlogLevel(t *testing.T) {
 	t.Log(ylog.TRACE)
 	t.Log(ylog.DEBUG)
 	t.Log(ylog.INFO)
@@ -18,7 +18,7 @@ func TestylogLevel(t *testing.T) {
 	t.Log(ylog.FATAL)
 }
 
-func TestylogConsoleLogger(t *testing.T) {
+func TestYlogConsoleLogger(t *testing.T) {
 	console := ylog.NewConsoleWriter(func(i int8) bool { return i >= ylog.TRACE }, false)
 	logger := ylog.NewLogger(console)
 	logger.Trace("test trace log message")